@@ -0,0 +1,820 @@
+package odp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+)
+
+// Connection tracking flow keys and the ct action.  The ct flow keys
+// are only meaningful for packets that have passed through a ct
+// action, which will usually be followed by a recirculation.
+
+// OVS_KEY_ATTR_CT_STATE: Connection tracking state flow key
+
+// The ct_state flags supported by the kernel
+const ctStateFlags = 0xff
+
+type CtStateFlowKey struct {
+	BlobFlowKey
+}
+
+func NewCtStateFlowKey() CtStateFlowKey {
+	return CtStateFlowKey{newWildcardBlobFlowKey(OVS_KEY_ATTR_CT_STATE, 4)}
+}
+
+func (fk CtStateFlowKey) State() uint32 {
+	return *uint32At(fk.BlobFlowKey.key(), 0)
+}
+
+func (fk CtStateFlowKey) Mask() uint32 {
+	return *uint32At(fk.BlobFlowKey.mask(), 0)
+}
+
+// Match packets where the OVS_CS_F_* flags in mask have the values
+// given in state.  E.g. SetMaskedState(OVS_CS_F_TRACKED|OVS_CS_F_NEW,
+// OVS_CS_F_TRACKED|OVS_CS_F_NEW) matches new connections.
+func (fk *CtStateFlowKey) SetMaskedState(state uint32, mask uint32) {
+	*uint32At(fk.BlobFlowKey.key(), 0) = state
+	*uint32At(fk.BlobFlowKey.mask(), 0) = mask
+}
+
+func (fk *CtStateFlowKey) SetState(state uint32) {
+	fk.SetMaskedState(state, ctStateFlags)
+}
+
+func (fk CtStateFlowKey) String() string {
+	return fmt.Sprintf("CtStateFlowKey{state: %02x&%02x}", fk.State(), fk.Mask())
+}
+
+var ctStateFlowKeyParser = blobFlowKeyParser(4,
+	func(fk BlobFlowKey) FlowKey { return CtStateFlowKey{fk} })
+
+// OVS_KEY_ATTR_CT_ZONE: Connection tracking zone flow key
+
+type CtZoneFlowKey struct {
+	BlobFlowKey
+}
+
+func NewCtZoneFlowKey() CtZoneFlowKey {
+	return CtZoneFlowKey{newWildcardBlobFlowKey(OVS_KEY_ATTR_CT_ZONE, 2)}
+}
+
+func (fk CtZoneFlowKey) Zone() uint16 {
+	return *uint16At(fk.BlobFlowKey.key(), 0)
+}
+
+func (fk CtZoneFlowKey) Mask() uint16 {
+	return *uint16At(fk.BlobFlowKey.mask(), 0)
+}
+
+func (fk *CtZoneFlowKey) SetMaskedZone(zone uint16, mask uint16) {
+	*uint16At(fk.BlobFlowKey.key(), 0) = zone
+	*uint16At(fk.BlobFlowKey.mask(), 0) = mask
+}
+
+func (fk *CtZoneFlowKey) SetZone(zone uint16) {
+	fk.SetMaskedZone(zone, 0xffff)
+}
+
+func (fk CtZoneFlowKey) String() string {
+	if m := fk.Mask(); m != 0xffff {
+		return fmt.Sprintf("CtZoneFlowKey{zone: %d&%x}", fk.Zone(), m)
+	}
+
+	return fmt.Sprintf("CtZoneFlowKey{zone: %d}", fk.Zone())
+}
+
+var ctZoneFlowKeyParser = blobFlowKeyParser(2,
+	func(fk BlobFlowKey) FlowKey { return CtZoneFlowKey{fk} })
+
+// OVS_KEY_ATTR_CT_MARK: Connection tracking mark flow key
+
+type CtMarkFlowKey struct {
+	BlobFlowKey
+}
+
+func NewCtMarkFlowKey() CtMarkFlowKey {
+	return CtMarkFlowKey{newWildcardBlobFlowKey(OVS_KEY_ATTR_CT_MARK, 4)}
+}
+
+func (fk CtMarkFlowKey) Mark() uint32 {
+	return *uint32At(fk.BlobFlowKey.key(), 0)
+}
+
+func (fk CtMarkFlowKey) Mask() uint32 {
+	return *uint32At(fk.BlobFlowKey.mask(), 0)
+}
+
+func (fk *CtMarkFlowKey) SetMaskedMark(mark uint32, mask uint32) {
+	*uint32At(fk.BlobFlowKey.key(), 0) = mark
+	*uint32At(fk.BlobFlowKey.mask(), 0) = mask
+}
+
+func (fk *CtMarkFlowKey) SetMark(mark uint32) {
+	fk.SetMaskedMark(mark, 0xffffffff)
+}
+
+func (fk CtMarkFlowKey) String() string {
+	if m := fk.Mask(); m != 0xffffffff {
+		return fmt.Sprintf("CtMarkFlowKey{mark: %x&%x}", fk.Mark(), m)
+	}
+
+	return fmt.Sprintf("CtMarkFlowKey{mark: %x}", fk.Mark())
+}
+
+var ctMarkFlowKeyParser = blobFlowKeyParser(4,
+	func(fk BlobFlowKey) FlowKey { return CtMarkFlowKey{fk} })
+
+// OVS_KEY_ATTR_CT_LABELS: Connection tracking labels flow key
+
+type CtLabelsFlowKey struct {
+	BlobFlowKey
+}
+
+func NewCtLabelsFlowKey() CtLabelsFlowKey {
+	return CtLabelsFlowKey{newWildcardBlobFlowKey(OVS_KEY_ATTR_CT_LABELS,
+		OVS_CT_LABELS_LEN)}
+}
+
+func (fk CtLabelsFlowKey) Labels() (res [OVS_CT_LABELS_LEN]byte) {
+	copy(res[:], fk.BlobFlowKey.key())
+	return
+}
+
+func (fk CtLabelsFlowKey) Mask() (res [OVS_CT_LABELS_LEN]byte) {
+	copy(res[:], fk.BlobFlowKey.mask())
+	return
+}
+
+func (fk *CtLabelsFlowKey) SetMaskedLabels(labels [OVS_CT_LABELS_LEN]byte,
+	mask [OVS_CT_LABELS_LEN]byte) {
+	copy(fk.BlobFlowKey.key(), labels[:])
+	copy(fk.BlobFlowKey.mask(), mask[:])
+}
+
+func (fk *CtLabelsFlowKey) SetLabels(labels [OVS_CT_LABELS_LEN]byte) {
+	fk.SetMaskedLabels(labels, allOnesCtLabels())
+}
+
+func allOnesCtLabels() (res [OVS_CT_LABELS_LEN]byte) {
+	setAllBytes(res[:], 0xff)
+	return
+}
+
+func (fk CtLabelsFlowKey) String() string {
+	var buf bytes.Buffer
+	var sep string
+	fmt.Fprint(&buf, "CtLabelsFlowKey{")
+	printMaskedBytes(&buf, &sep, "labels", fk.BlobFlowKey.key(),
+		fk.BlobFlowKey.mask(), hex.EncodeToString)
+	fmt.Fprint(&buf, "}")
+	return buf.String()
+}
+
+var ctLabelsFlowKeyParser = blobFlowKeyParser(OVS_CT_LABELS_LEN,
+	func(fk BlobFlowKey) FlowKey { return CtLabelsFlowKey{fk} })
+
+// OVS_KEY_ATTR_CT_ORIG_TUPLE_IPV4, OVS_KEY_ATTR_CT_ORIG_TUPLE_IPV6:
+// The original direction tuple of the tracked connection.  These are
+// set for packets that are not the first of their connection, and so
+// allow flows to match on the addresses and ports before NAT.
+
+type CtOrigTupleIPv4FlowKey struct {
+	BlobFlowKey
+}
+
+func NewCtOrigTupleIPv4FlowKey() CtOrigTupleIPv4FlowKey {
+	return CtOrigTupleIPv4FlowKey{newWildcardBlobFlowKey(
+		OVS_KEY_ATTR_CT_ORIG_TUPLE_IPV4, SizeofOvsKeyCtTupleIpv4)}
+}
+
+func (fk *CtOrigTupleIPv4FlowKey) key() *OvsKeyCtTupleIpv4 {
+	return ovsKeyCtTupleIpv4At(fk.BlobFlowKey.key(), 0)
+}
+
+func (fk *CtOrigTupleIPv4FlowKey) mask() *OvsKeyCtTupleIpv4 {
+	return ovsKeyCtTupleIpv4At(fk.BlobFlowKey.mask(), 0)
+}
+
+func (fk CtOrigTupleIPv4FlowKey) Key() OvsKeyCtTupleIpv4 {
+	return *fk.key()
+}
+
+func (fk CtOrigTupleIPv4FlowKey) Mask() OvsKeyCtTupleIpv4 {
+	return *fk.mask()
+}
+
+func (fk CtOrigTupleIPv4FlowKey) SrcPort() uint16 {
+	return uint16FromBE(fk.key().SrcPort)
+}
+
+func (fk CtOrigTupleIPv4FlowKey) SrcPortMask() uint16 {
+	return uint16FromBE(fk.mask().SrcPort)
+}
+
+func (fk CtOrigTupleIPv4FlowKey) DstPort() uint16 {
+	return uint16FromBE(fk.key().DstPort)
+}
+
+func (fk CtOrigTupleIPv4FlowKey) DstPortMask() uint16 {
+	return uint16FromBE(fk.mask().DstPort)
+}
+
+func (fk *CtOrigTupleIPv4FlowKey) SetMaskedIpv4Src(addr [4]byte, mask [4]byte) {
+	fk.key().Ipv4Src = addr
+	fk.mask().Ipv4Src = mask
+}
+
+func (fk *CtOrigTupleIPv4FlowKey) SetIpv4Src(addr [4]byte) {
+	fk.SetMaskedIpv4Src(addr, [...]byte{0xff, 0xff, 0xff, 0xff})
+}
+
+func (fk *CtOrigTupleIPv4FlowKey) SetMaskedIpv4Dst(addr [4]byte, mask [4]byte) {
+	fk.key().Ipv4Dst = addr
+	fk.mask().Ipv4Dst = mask
+}
+
+func (fk *CtOrigTupleIPv4FlowKey) SetIpv4Dst(addr [4]byte) {
+	fk.SetMaskedIpv4Dst(addr, [...]byte{0xff, 0xff, 0xff, 0xff})
+}
+
+func (fk *CtOrigTupleIPv4FlowKey) SetMaskedSrcPort(port uint16, mask uint16) {
+	fk.key().SrcPort = uint16ToBE(port)
+	fk.mask().SrcPort = uint16ToBE(mask)
+}
+
+func (fk *CtOrigTupleIPv4FlowKey) SetSrcPort(port uint16) {
+	fk.SetMaskedSrcPort(port, 0xffff)
+}
+
+func (fk *CtOrigTupleIPv4FlowKey) SetMaskedDstPort(port uint16, mask uint16) {
+	fk.key().DstPort = uint16ToBE(port)
+	fk.mask().DstPort = uint16ToBE(mask)
+}
+
+func (fk *CtOrigTupleIPv4FlowKey) SetDstPort(port uint16) {
+	fk.SetMaskedDstPort(port, 0xffff)
+}
+
+func (fk *CtOrigTupleIPv4FlowKey) SetMaskedProto(proto uint8, mask uint8) {
+	fk.key().Ipv4Proto = proto
+	fk.mask().Ipv4Proto = mask
+}
+
+func (fk *CtOrigTupleIPv4FlowKey) SetProto(proto uint8) {
+	fk.SetMaskedProto(proto, 0xff)
+}
+
+func (fk CtOrigTupleIPv4FlowKey) String() string {
+	var buf bytes.Buffer
+	var sep string
+	fmt.Fprint(&buf, "CtOrigTupleIPv4FlowKey{")
+
+	k := fk.Key()
+	m := fk.Mask()
+	printMaskedBytes(&buf, &sep, "src", k.Ipv4Src[:], m.Ipv4Src[:], ipv4ToString)
+	printMaskedBytes(&buf, &sep, "dst", k.Ipv4Dst[:], m.Ipv4Dst[:], ipv4ToString)
+	printCtTuplePorts(&buf, &sep, fk.SrcPort(), fk.SrcPortMask(),
+		fk.DstPort(), fk.DstPortMask())
+	printMaskedUint8(&buf, &sep, "proto", k.Ipv4Proto, m.Ipv4Proto)
+	fmt.Fprint(&buf, "}")
+	return buf.String()
+}
+
+func printCtTuplePorts(buf *bytes.Buffer, sep *string, src, srcMask, dst, dstMask uint16) {
+	print := func(n string, k, m uint16) {
+		if m != 0 {
+			fmt.Fprintf(buf, "%s%s: %d", *sep, n, k)
+			if m != 0xffff {
+				fmt.Fprintf(buf, "&%x", m)
+			}
+			*sep = ", "
+		}
+	}
+
+	print("srcport", src, srcMask)
+	print("dstport", dst, dstMask)
+}
+
+var ctOrigTupleIPv4FlowKeyParser = blobFlowKeyParser(SizeofOvsKeyCtTupleIpv4,
+	func(fk BlobFlowKey) FlowKey { return CtOrigTupleIPv4FlowKey{fk} })
+
+type CtOrigTupleIPv6FlowKey struct {
+	BlobFlowKey
+}
+
+func NewCtOrigTupleIPv6FlowKey() CtOrigTupleIPv6FlowKey {
+	return CtOrigTupleIPv6FlowKey{newWildcardBlobFlowKey(
+		OVS_KEY_ATTR_CT_ORIG_TUPLE_IPV6, SizeofOvsKeyCtTupleIpv6)}
+}
+
+func (fk *CtOrigTupleIPv6FlowKey) key() *OvsKeyCtTupleIpv6 {
+	return ovsKeyCtTupleIpv6At(fk.BlobFlowKey.key(), 0)
+}
+
+func (fk *CtOrigTupleIPv6FlowKey) mask() *OvsKeyCtTupleIpv6 {
+	return ovsKeyCtTupleIpv6At(fk.BlobFlowKey.mask(), 0)
+}
+
+func (fk CtOrigTupleIPv6FlowKey) Key() OvsKeyCtTupleIpv6 {
+	return *fk.key()
+}
+
+func (fk CtOrigTupleIPv6FlowKey) Mask() OvsKeyCtTupleIpv6 {
+	return *fk.mask()
+}
+
+func (fk CtOrigTupleIPv6FlowKey) Src() netip.Addr {
+	return netip.AddrFrom16(fk.key().Ipv6Src)
+}
+
+func (fk CtOrigTupleIPv6FlowKey) Dst() netip.Addr {
+	return netip.AddrFrom16(fk.key().Ipv6Dst)
+}
+
+func (fk CtOrigTupleIPv6FlowKey) SrcPort() uint16 {
+	return uint16FromBE(fk.key().SrcPort)
+}
+
+func (fk CtOrigTupleIPv6FlowKey) SrcPortMask() uint16 {
+	return uint16FromBE(fk.mask().SrcPort)
+}
+
+func (fk CtOrigTupleIPv6FlowKey) DstPort() uint16 {
+	return uint16FromBE(fk.key().DstPort)
+}
+
+func (fk CtOrigTupleIPv6FlowKey) DstPortMask() uint16 {
+	return uint16FromBE(fk.mask().DstPort)
+}
+
+func (fk *CtOrigTupleIPv6FlowKey) SetMaskedIpv6Src(addr netip.Addr, mask [16]byte) {
+	fk.key().Ipv6Src = addr.As16()
+	fk.mask().Ipv6Src = mask
+}
+
+func (fk *CtOrigTupleIPv6FlowKey) SetIpv6Src(addr netip.Addr) {
+	var mask [16]byte
+	setAllBytes(mask[:], 0xff)
+	fk.SetMaskedIpv6Src(addr, mask)
+}
+
+func (fk *CtOrigTupleIPv6FlowKey) SetMaskedIpv6Dst(addr netip.Addr, mask [16]byte) {
+	fk.key().Ipv6Dst = addr.As16()
+	fk.mask().Ipv6Dst = mask
+}
+
+func (fk *CtOrigTupleIPv6FlowKey) SetIpv6Dst(addr netip.Addr) {
+	var mask [16]byte
+	setAllBytes(mask[:], 0xff)
+	fk.SetMaskedIpv6Dst(addr, mask)
+}
+
+func (fk *CtOrigTupleIPv6FlowKey) SetMaskedSrcPort(port uint16, mask uint16) {
+	fk.key().SrcPort = uint16ToBE(port)
+	fk.mask().SrcPort = uint16ToBE(mask)
+}
+
+func (fk *CtOrigTupleIPv6FlowKey) SetSrcPort(port uint16) {
+	fk.SetMaskedSrcPort(port, 0xffff)
+}
+
+func (fk *CtOrigTupleIPv6FlowKey) SetMaskedDstPort(port uint16, mask uint16) {
+	fk.key().DstPort = uint16ToBE(port)
+	fk.mask().DstPort = uint16ToBE(mask)
+}
+
+func (fk *CtOrigTupleIPv6FlowKey) SetDstPort(port uint16) {
+	fk.SetMaskedDstPort(port, 0xffff)
+}
+
+func (fk *CtOrigTupleIPv6FlowKey) SetMaskedProto(proto uint8, mask uint8) {
+	fk.key().Ipv6Proto = proto
+	fk.mask().Ipv6Proto = mask
+}
+
+func (fk *CtOrigTupleIPv6FlowKey) SetProto(proto uint8) {
+	fk.SetMaskedProto(proto, 0xff)
+}
+
+func (fk CtOrigTupleIPv6FlowKey) String() string {
+	var buf bytes.Buffer
+	var sep string
+	fmt.Fprint(&buf, "CtOrigTupleIPv6FlowKey{")
+
+	k := fk.Key()
+	m := fk.Mask()
+	if !AllBytes(m.Ipv6Src[:], 0) {
+		fmt.Fprintf(&buf, "src: %s", ipv6MaskedString(k.Ipv6Src, m.Ipv6Src))
+		sep = ", "
+	}
+
+	if !AllBytes(m.Ipv6Dst[:], 0) {
+		fmt.Fprintf(&buf, "%sdst: %s", sep, ipv6MaskedString(k.Ipv6Dst, m.Ipv6Dst))
+		sep = ", "
+	}
+
+	printCtTuplePorts(&buf, &sep, fk.SrcPort(), fk.SrcPortMask(),
+		fk.DstPort(), fk.DstPortMask())
+	printMaskedUint8(&buf, &sep, "proto", k.Ipv6Proto, m.Ipv6Proto)
+	fmt.Fprint(&buf, "}")
+	return buf.String()
+}
+
+var ctOrigTupleIPv6FlowKeyParser = blobFlowKeyParser(SizeofOvsKeyCtTupleIpv6,
+	func(fk BlobFlowKey) FlowKey { return CtOrigTupleIPv6FlowKey{fk} })
+
+// OVS_ACTION_ATTR_CT: Send the packet through connection tracking.
+//
+// Without Commit, the packet is only looked up, so that its ct flow
+// keys are populated for a subsequent recirculation.  Setting Mark or
+// Labels requires Commit.
+
+type CtAction struct {
+	Commit bool
+
+	// Commit, replacing an existing connection whose original
+	// direction differs from the packet's
+	ForceCommit bool
+
+	Zone uint16
+
+	// Set the bits of the connection's mark and labels selected
+	// by the masks.  A zero mask means they are not set.
+	Mark       uint32
+	MarkMask   uint32
+	Labels     [OVS_CT_LABELS_LEN]byte
+	LabelsMask [OVS_CT_LABELS_LEN]byte
+
+	// Name of a conntrack helper (e.g. "ftp"), or empty
+	Helper string
+
+	// The connection tracking events (a bitmask of the kernel's
+	// IPCT_* bits) to report for a committed connection.  If
+	// HasEventMask is false, all events are reported.
+	EventMask    uint32
+	HasEventMask bool
+
+	// Name of a conntrack timeout policy, or empty
+	Timeout string
+
+	// Network address translation, or nil for none
+	Nat *CtNat
+}
+
+// Network address translation for a CtAction.  With neither Src nor
+// Dst set, the NAT already established for the connection is applied.
+type CtNat struct {
+	Src bool
+	Dst bool
+
+	// The address range to translate to; the zero netip.Addr
+	// means unspecified.  IPMax may be omitted for a single
+	// address, and the kernel omits it when it equals IPMin.
+	IPMin netip.Addr
+	IPMax netip.Addr
+
+	// The port range to translate to, or zero if unspecified.
+	// As with the addresses, ProtoMax may be omitted.
+	ProtoMin uint16
+	ProtoMax uint16
+
+	Persistent  bool
+	ProtoHash   bool
+	ProtoRandom bool
+}
+
+func (a CtAction) String() string {
+	var buf bytes.Buffer
+	var sep string
+	fmt.Fprint(&buf, "CtAction{")
+
+	flag := func(n string, f bool) {
+		if f {
+			fmt.Fprintf(&buf, "%s%s", sep, n)
+			sep = ", "
+		}
+	}
+
+	flag("commit", a.Commit)
+	flag("force", a.ForceCommit)
+
+	if a.Zone != 0 {
+		fmt.Fprintf(&buf, "%szone: %d", sep, a.Zone)
+		sep = ", "
+	}
+
+	if a.MarkMask != 0 {
+		fmt.Fprintf(&buf, "%smark: %x&%x", sep, a.Mark, a.MarkMask)
+		sep = ", "
+	}
+
+	printMaskedBytes(&buf, &sep, "labels", a.Labels[:], a.LabelsMask[:],
+		hex.EncodeToString)
+
+	if a.Helper != "" {
+		fmt.Fprintf(&buf, "%shelper: %s", sep, a.Helper)
+		sep = ", "
+	}
+
+	if a.HasEventMask {
+		fmt.Fprintf(&buf, "%seventmask: %x", sep, a.EventMask)
+		sep = ", "
+	}
+
+	if a.Timeout != "" {
+		fmt.Fprintf(&buf, "%stimeout: %s", sep, a.Timeout)
+		sep = ", "
+	}
+
+	if a.Nat != nil {
+		fmt.Fprintf(&buf, "%snat: %v", sep, *a.Nat)
+	}
+
+	fmt.Fprint(&buf, "}")
+	return buf.String()
+}
+
+func (n CtNat) String() string {
+	var buf bytes.Buffer
+	var sep string
+	fmt.Fprint(&buf, "{")
+
+	flag := func(n string, f bool) {
+		if f {
+			fmt.Fprintf(&buf, "%s%s", sep, n)
+			sep = ", "
+		}
+	}
+
+	flag("src", n.Src)
+	flag("dst", n.Dst)
+
+	if n.IPMin.IsValid() {
+		fmt.Fprintf(&buf, "%sip: %s", sep, n.IPMin)
+		if n.IPMax.IsValid() {
+			fmt.Fprintf(&buf, "-%s", n.IPMax)
+		}
+		sep = ", "
+	}
+
+	if n.ProtoMin != 0 {
+		fmt.Fprintf(&buf, "%sport: %d", sep, n.ProtoMin)
+		if n.ProtoMax != 0 {
+			fmt.Fprintf(&buf, "-%d", n.ProtoMax)
+		}
+		sep = ", "
+	}
+
+	flag("persistent", n.Persistent)
+	flag("hash", n.ProtoHash)
+	flag("random", n.ProtoRandom)
+	fmt.Fprint(&buf, "}")
+	return buf.String()
+}
+
+func (CtAction) typeId() uint16 {
+	return OVS_ACTION_ATTR_CT
+}
+
+func (a CtAction) toNlAttr(msg *NlMsgBuilder) {
+	msg.PutNestedAttrs(OVS_ACTION_ATTR_CT, func() {
+		if a.Commit {
+			msg.PutEmptyAttr(OVS_CT_ATTR_COMMIT)
+		}
+
+		if a.ForceCommit {
+			msg.PutEmptyAttr(OVS_CT_ATTR_FORCE_COMMIT)
+		}
+
+		if a.Zone != 0 {
+			msg.PutUint16Attr(OVS_CT_ATTR_ZONE, a.Zone)
+		}
+
+		if a.MarkMask != 0 {
+			data := MakeAlignedByteSlice(8)
+			*uint32At(data, 0) = a.Mark
+			*uint32At(data, 4) = a.MarkMask
+			msg.PutSliceAttr(OVS_CT_ATTR_MARK, data)
+		}
+
+		if !AllBytes(a.LabelsMask[:], 0) {
+			msg.PutSliceAttr(OVS_CT_ATTR_LABELS,
+				append(a.Labels[:], a.LabelsMask[:]...))
+		}
+
+		if a.Helper != "" {
+			msg.PutStringAttr(OVS_CT_ATTR_HELPER, a.Helper)
+		}
+
+		if a.HasEventMask {
+			msg.PutUint32Attr(OVS_CT_ATTR_EVENTMASK, a.EventMask)
+		}
+
+		if a.Timeout != "" {
+			msg.PutStringAttr(OVS_CT_ATTR_TIMEOUT, a.Timeout)
+		}
+
+		if a.Nat != nil {
+			msg.PutNestedAttrs(OVS_CT_ATTR_NAT, func() {
+				a.Nat.toNlAttrs(msg)
+			})
+		}
+	})
+}
+
+func (n CtNat) toNlAttrs(msg *NlMsgBuilder) {
+	if n.Src {
+		msg.PutEmptyAttr(OVS_NAT_ATTR_SRC)
+	}
+
+	if n.Dst {
+		msg.PutEmptyAttr(OVS_NAT_ATTR_DST)
+	}
+
+	if n.IPMin.IsValid() {
+		msg.PutSliceAttr(OVS_NAT_ATTR_IP_MIN, n.IPMin.AsSlice())
+	}
+
+	if n.IPMax.IsValid() && n.IPMax != n.IPMin {
+		msg.PutSliceAttr(OVS_NAT_ATTR_IP_MAX, n.IPMax.AsSlice())
+	}
+
+	if n.ProtoMin != 0 {
+		msg.PutUint16Attr(OVS_NAT_ATTR_PROTO_MIN, n.ProtoMin)
+	}
+
+	if n.ProtoMax != 0 && n.ProtoMax != n.ProtoMin {
+		msg.PutUint16Attr(OVS_NAT_ATTR_PROTO_MAX, n.ProtoMax)
+	}
+
+	if n.Persistent {
+		msg.PutEmptyAttr(OVS_NAT_ATTR_PERSISTENT)
+	}
+
+	if n.ProtoHash {
+		msg.PutEmptyAttr(OVS_NAT_ATTR_PROTO_HASH)
+	}
+
+	if n.ProtoRandom {
+		msg.PutEmptyAttr(OVS_NAT_ATTR_PROTO_RANDOM)
+	}
+}
+
+func (a CtAction) Equals(bx Action) bool {
+	b, ok := bx.(CtAction)
+	if !ok {
+		return false
+	}
+
+	if (a.Nat == nil) != (b.Nat == nil) ||
+		(a.Nat != nil && a.Nat.normalize() != b.Nat.normalize()) {
+		return false
+	}
+
+	a.Nat = nil
+	b.Nat = nil
+	return a == b
+}
+
+// Fill in an omitted maximum of a range from its minimum, so that
+// equivalent CtNats compare equal
+func (n CtNat) normalize() CtNat {
+	if !n.IPMax.IsValid() {
+		n.IPMax = n.IPMin
+	}
+
+	if n.ProtoMax == 0 {
+		n.ProtoMax = n.ProtoMin
+	}
+
+	return n
+}
+
+func parseCtAction(typ uint16, data []byte) (Action, error) {
+	attrs, err := ParseNestedAttrs(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var a CtAction
+	if a.Commit, err = attrs.GetEmpty(OVS_CT_ATTR_COMMIT); err != nil {
+		return nil, err
+	}
+
+	if a.ForceCommit, err = attrs.GetEmpty(OVS_CT_ATTR_FORCE_COMMIT); err != nil {
+		return nil, err
+	}
+
+	if a.Zone, _, err = attrs.GetOptionalUint16(OVS_CT_ATTR_ZONE); err != nil {
+		return nil, err
+	}
+
+	mark, err := attrs.GetFixedBytes(OVS_CT_ATTR_MARK, 8, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if mark != nil {
+		a.Mark = *uint32At(mark, 0)
+		a.MarkMask = *uint32At(mark, 4)
+	}
+
+	labels, err := attrs.GetFixedBytes(OVS_CT_ATTR_LABELS,
+		2*OVS_CT_LABELS_LEN, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if labels != nil {
+		copy(a.Labels[:], labels)
+		copy(a.LabelsMask[:], labels[OVS_CT_LABELS_LEN:])
+	}
+
+	if _, ok := attrs[OVS_CT_ATTR_HELPER]; ok {
+		if a.Helper, err = attrs.GetString(OVS_CT_ATTR_HELPER); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, a.HasEventMask = attrs[OVS_CT_ATTR_EVENTMASK]; a.HasEventMask {
+		if a.EventMask, err = attrs.GetUint32(OVS_CT_ATTR_EVENTMASK); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := attrs[OVS_CT_ATTR_TIMEOUT]; ok {
+		if a.Timeout, err = attrs.GetString(OVS_CT_ATTR_TIMEOUT); err != nil {
+			return nil, err
+		}
+	}
+
+	natAttrs, err := attrs.GetNestedAttrs(OVS_CT_ATTR_NAT, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if natAttrs != nil {
+		nat, err := parseCtNat(natAttrs)
+		if err != nil {
+			return nil, err
+		}
+
+		a.Nat = &nat
+	}
+
+	return a, nil
+}
+
+func parseCtNat(attrs Attrs) (n CtNat, err error) {
+	if n.Src, err = attrs.GetEmpty(OVS_NAT_ATTR_SRC); err != nil {
+		return
+	}
+
+	if n.Dst, err = attrs.GetEmpty(OVS_NAT_ATTR_DST); err != nil {
+		return
+	}
+
+	parseAddr := func(typ uint16) (netip.Addr, error) {
+		data, ok := attrs[typ]
+		if !ok {
+			return netip.Addr{}, nil
+		}
+
+		addr, ok := netip.AddrFromSlice(data)
+		if !ok {
+			return addr, fmt.Errorf("NAT address attribute %d has wrong length (got %d bytes)", typ, len(data))
+		}
+
+		return addr, nil
+	}
+
+	if n.IPMin, err = parseAddr(OVS_NAT_ATTR_IP_MIN); err != nil {
+		return
+	}
+
+	if n.IPMax, err = parseAddr(OVS_NAT_ATTR_IP_MAX); err != nil {
+		return
+	}
+
+	if n.ProtoMin, _, err = attrs.GetOptionalUint16(OVS_NAT_ATTR_PROTO_MIN); err != nil {
+		return
+	}
+
+	if n.ProtoMax, _, err = attrs.GetOptionalUint16(OVS_NAT_ATTR_PROTO_MAX); err != nil {
+		return
+	}
+
+	if n.Persistent, err = attrs.GetEmpty(OVS_NAT_ATTR_PERSISTENT); err != nil {
+		return
+	}
+
+	if n.ProtoHash, err = attrs.GetEmpty(OVS_NAT_ATTR_PROTO_HASH); err != nil {
+		return
+	}
+
+	n.ProtoRandom, err = attrs.GetEmpty(OVS_NAT_ATTR_PROTO_RANDOM)
+	return
+}
@@ -153,20 +153,28 @@ func (dp DatapathHandle) Delete() error {
 		return err
 	}
 
+	forgetRecircIds(dp.ifindex)
 	dp.dpif = nil
 	dp.ifindex = 0
 	return nil
 }
 
 func (dp DatapathHandle) checkNlMsgHeaders(msg *NlMsgParser, family int, cmd int) error {
-	_, ovshdr, err := dp.dpif.checkNlMsgHeaders(msg, family, cmd)
+	_, err := dp.checkGenlMsgHeaders(msg, family, cmd)
+	return err
+}
+
+// Like checkNlMsgHeaders, but also returns the generic netlink
+// header, for callers that accept more than one command
+func (dp DatapathHandle) checkGenlMsgHeaders(msg *NlMsgParser, family int, cmd int) (*GenlMsghdr, error) {
+	genlhdr, ovshdr, err := dp.dpif.checkNlMsgHeaders(msg, family, cmd)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if ovshdr.datapathID() != dp.ifindex {
-		return fmt.Errorf("wrong datapath ifindex received (got %d, expected %d)", ovshdr.datapathID(), dp.ifindex)
+		return nil, fmt.Errorf("wrong datapath ifindex received (got %d, expected %d)", ovshdr.datapathID(), dp.ifindex)
 	}
 
-	return nil
+	return genlhdr, nil
 }
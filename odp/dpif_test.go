@@ -3,6 +3,8 @@ package odp
 import (
 	"fmt"
 	"math/rand"
+	"reflect"
+	"sort"
 	"syscall"
 	"testing"
 	"time"
@@ -160,6 +162,40 @@ func TestCreateVport(t *testing.T) {
 	}
 }
 
+func TestCreateVportWithID(t *testing.T) {
+	dpif, err := NewDpif()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkedCloseDpif(dpif, t)
+
+	dp, err := dpif.CreateDatapath(fmt.Sprintf("test%d", rand.Intn(100000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkedDeleteDatapath(dp, t)
+
+	name := fmt.Sprintf("test%d", rand.Intn(100000))
+	vport, err := dp.CreateVportWithID(NewInternalVportSpec(name), 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vport != 42 {
+		t.Fatalf("wrong vport ID: got %d, expected 42", vport)
+	}
+
+	_, err = dp.CreateVportWithID(NewInternalVportSpec(name+"x"), 42)
+	if !IsVportIDInUseError(err) {
+		t.Fatal(err)
+	}
+
+	err = dp.DeleteVport(vport)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestLookupVport(t *testing.T) {
 	dpif, err := NewDpif()
 	if err != nil {
@@ -320,6 +356,185 @@ func TestCreateFlow(t *testing.T) {
 	}
 }
 
+func TestLookupFlow(t *testing.T) {
+	dpif, err := NewDpif()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkedCloseDpif(dpif, t)
+
+	dp, err := dpif.CreateDatapath(fmt.Sprintf("test%d", rand.Intn(100000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkedDeleteDatapath(dp, t)
+
+	vpname := fmt.Sprintf("test%d", rand.Intn(100000))
+	vport, err := dp.CreateVport(NewInternalVportSpec(vpname))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewFlowSpec()
+	fk := NewEthernetFlowKey()
+	fk.SetEthSrc([...]byte{1, 2, 3, 4, 5, 6})
+	fk.SetEthDst([...]byte{6, 5, 4, 3, 2, 1})
+	f.AddKey(fk)
+	f.AddAction(NewOutputAction(vport))
+
+	_, err = dp.LookupFlow(f.FlowKeys)
+	if !IsNoSuchFlowError(err) {
+		t.Fatal(err)
+	}
+
+	err = dp.CreateFlow(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := dp.LookupFlow(f.FlowKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !f.Equals(fi.FlowSpec) {
+		t.Fatal(fi.FlowSpec)
+	}
+
+	err = dp.DeleteFlow(f.FlowKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetMaskedAction(t *testing.T) {
+	dpif, err := NewDpif()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkedCloseDpif(dpif, t)
+
+	dp, err := dpif.CreateDatapath(fmt.Sprintf("test%d", rand.Intn(100000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkedDeleteDatapath(dp, t)
+
+	vpname := fmt.Sprintf("test%d", rand.Intn(100000))
+	vport, err := dp.CreateVport(NewInternalVportSpec(vpname))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewFlowSpec()
+	f.AddKey(NewEthernetFlowKey())
+	f.AddKey(NewEthertypeFlowKey(0x0800))
+	ipk := NewIPv4FlowKey()
+	ipk.SetTtl(64)
+	f.AddKey(ipk)
+
+	// Rewrite the network part of the source address, giving
+	// host bits that the kernel would reject if they were kept
+	rewrite := NewIPv4FlowKey()
+	rewrite.SetMaskedIpv4Src([4]byte{10, 0, 0, 5}, [4]byte{255, 255, 255, 0})
+	a := NewSetMaskedAction(rewrite)
+	if k := a.Key().(IPv4FlowKey).Key(); k.Ipv4Src != [4]byte{10, 0, 0, 0} {
+		t.Fatal(k)
+	}
+
+	f.AddAction(a)
+	f.AddAction(NewOutputAction(vport))
+
+	err = dp.CreateFlow(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := dp.LookupFlow(f.FlowKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !f.Equals(fi.FlowSpec) {
+		t.Fatal(fi.FlowSpec)
+	}
+
+	err = dp.DeleteFlow(f.FlowKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModifyFlow(t *testing.T) {
+	dpif, err := NewDpif()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkedCloseDpif(dpif, t)
+
+	dp, err := dpif.CreateDatapath(fmt.Sprintf("test%d", rand.Intn(100000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkedDeleteDatapath(dp, t)
+
+	var vports [2]VportID
+	for i := range vports {
+		vpname := fmt.Sprintf("test%d", rand.Intn(100000))
+		vports[i], err = dp.CreateVport(NewInternalVportSpec(vpname))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := NewFlowSpec()
+	fk := NewEthernetFlowKey()
+	fk.SetEthSrc([...]byte{1, 2, 3, 4, 5, 6})
+	fk.SetEthDst([...]byte{6, 5, 4, 3, 2, 1})
+	f.AddKey(fk)
+	f.AddAction(NewOutputAction(vports[0]))
+
+	err = dp.ModifyFlow(f, ModifyOptions{})
+	if !IsNoSuchFlowError(err) {
+		t.Fatal(err)
+	}
+
+	err = dp.ModifyFlow(f, ModifyOptions{CreateIfMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = dp.CreateFlowExclusive(f)
+	if !IsFlowAlreadyExistsError(err) {
+		t.Fatal(err)
+	}
+
+	f.Actions = []Action{NewOutputAction(vports[1])}
+	err = dp.ModifyFlow(f, ModifyOptions{ClearStats: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := dp.LookupFlow(f.FlowKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !f.Equals(fi.FlowSpec) {
+		t.Fatal(fi.FlowSpec)
+	}
+
+	err = dp.DeleteFlow(f.FlowKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = dp.CreateFlowExclusive(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestEnumerateFlows(t *testing.T) {
 	dpif, err := NewDpif()
 	if err != nil {
@@ -397,6 +612,102 @@ func TestEnumerateFlows(t *testing.T) {
 	}
 }
 
+func TestAllocateRecircId(t *testing.T) {
+	dpif, err := NewDpif()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkedCloseDpif(dpif, t)
+
+	dp, err := dpif.CreateDatapath(fmt.Sprintf("test%d", rand.Intn(100000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkedDeleteDatapath(dp, t)
+
+	id1, err := dp.AllocateRecircId()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id2, err := dp.AllocateRecircId()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id1 == 0 || id2 == 0 || id1 == id2 {
+		t.Fatal(id1, id2)
+	}
+
+	f := NewFlowSpec()
+	f.AddKey(NewEthernetFlowKey())
+	rfk := NewRecircIdFlowKey()
+	rfk.SetRecircId(id1)
+	f.AddKey(rfk)
+	f.AddAction(NewHashAction(OVS_HASH_ALG_L4, 0))
+	f.AddAction(NewRecircAction(id2))
+
+	err = dp.CreateFlow(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = dp.DeleteFlow(f.FlowKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dp.FreeRecircId(id1)
+	dp.FreeRecircId(id2)
+}
+
+func TestFlushFlows(t *testing.T) {
+	dpif, err := NewDpif()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkedCloseDpif(dpif, t)
+
+	dp, err := dpif.CreateDatapath(fmt.Sprintf("test%d", rand.Intn(100000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkedDeleteDatapath(dp, t)
+
+	vpname := fmt.Sprintf("test%d", rand.Intn(100000))
+	vport, err := dp.CreateVport(NewInternalVportSpec(vpname))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		flow := NewFlowSpec()
+		fk := NewEthernetFlowKey()
+		fk.SetEthSrc([...]byte{1, 2, 3, 4, 5, byte(i)})
+		fk.SetEthDst([...]byte{6, 5, 4, 3, 2, 1})
+		flow.AddKey(fk)
+		flow.AddAction(NewOutputAction(vport))
+		err = dp.CreateFlow(flow)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = dp.FlushFlows()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eflows, err := dp.EnumerateFlows()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(eflows) != 0 {
+		t.Fatal(eflows)
+	}
+}
+
 func TestConsumeVportEvents(t *testing.T) {
 	dpif, err := NewDpif()
 	if err != nil {
@@ -428,6 +739,10 @@ func (vportTestConsumer) VportCreated(ifindex DatapathID, vport Vport) error {
 	return nil
 }
 
+func (vportTestConsumer) VportChanged(ifindex DatapathID, vport Vport) error {
+	return nil
+}
+
 func (vportTestConsumer) VportDeleted(ifindex DatapathID, vport Vport) error {
 	return nil
 }
@@ -435,3 +750,230 @@ func (vportTestConsumer) VportDeleted(ifindex DatapathID, vport Vport) error {
 func (consumer vportTestConsumer) Error(err error, stopped bool) {
 	consumer.ch <- err
 }
+
+func TestCreateVethVport(t *testing.T) {
+	dpif, err := NewDpif()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkedCloseDpif(dpif, t)
+
+	dp, err := dpif.CreateDatapath(fmt.Sprintf("test%d", rand.Intn(100000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkedDeleteDatapath(dp, t)
+
+	rtnl, err := NewRtnl()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rtnl.Close()
+
+	name := fmt.Sprintf("test%d", rand.Intn(100000))
+	peer := name + "p"
+	vport, err := dp.CreateVethVport(name, peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = rtnl.SetLinkMtu(peer, 1400)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = dp.DeleteVport(vport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = rtnl.DeleteLink(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = rtnl.DeleteLink(peer)
+	if !IsNoSuchLinkError(err) {
+		t.Fatal(err)
+	}
+}
+
+func TestVportCache(t *testing.T) {
+	dpif, err := NewDpif()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkedCloseDpif(dpif, t)
+
+	dpname := fmt.Sprintf("test%d", rand.Intn(100000))
+	dp, err := dpif.CreateDatapath(dpname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkedDeleteDatapath(dp, t)
+
+	name := fmt.Sprintf("test%d", rand.Intn(100000))
+	vport, err := dp.CreateVport(NewInternalVportSpec(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := dp.NewVportCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	gotName, err := cache.LookupVportName(dp.ID(), vport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotName != name {
+		t.Fatalf("wrong vport name: got %s, expected %s", gotName, name)
+	}
+
+	gotID, err := cache.LookupVportID(dp.ID(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotID != vport {
+		t.Fatalf("wrong vport ID: got %d, expected %d", gotID, vport)
+	}
+
+	gotName, err = cache.LookupDatapathName(dp.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotName != dpname {
+		t.Fatalf("wrong datapath name: got %s, expected %s", gotName, dpname)
+	}
+}
+
+type vportEventRecorder struct {
+	events []string
+}
+
+func (r *vportEventRecorder) VportCreated(ifindex DatapathID, vport Vport) error {
+	r.events = append(r.events, fmt.Sprintf("created %d %s", vport.ID, vport.Spec.Name()))
+	return nil
+}
+
+func (r *vportEventRecorder) VportChanged(ifindex DatapathID, vport Vport) error {
+	r.events = append(r.events, fmt.Sprintf("changed %d %s", vport.ID, vport.Spec.Name()))
+	return nil
+}
+
+func (r *vportEventRecorder) VportDeleted(ifindex DatapathID, vport Vport) error {
+	r.events = append(r.events, fmt.Sprintf("deleted %d %s", vport.ID, vport.Spec.Name()))
+	return nil
+}
+
+func (r *vportEventRecorder) Error(err error, stopped bool) {
+	r.events = append(r.events, err.Error())
+}
+
+// After an overrun, the consumer should be told about the
+// differences between the vports it knew about and the current ones.
+// This doesn't need the kernel module.
+func TestVportTrackerResync(t *testing.T) {
+	recorder := &vportEventRecorder{}
+	tracker := &vportTracker{
+		ifindex:  -1,
+		consumer: recorder,
+		vports: map[DatapathID]map[VportID]Vport{
+			1: {
+				1: {1, NewInternalVportSpec("same")},
+				2: {2, NewInternalVportSpec("gone")},
+				3: {3, NewVxlanVportSpec("vxlan", 4789)},
+				4: {4, NewInternalVportSpec("old")},
+			},
+		},
+		complete: true,
+	}
+
+	current := map[DatapathID]map[VportID]Vport{
+		1: {
+			1: {1, NewInternalVportSpec("same")},
+			3: {3, NewVxlanVportSpec("vxlan", 8472)},
+			4: {4, NewInternalVportSpec("new")},
+			5: {5, NewInternalVportSpec("added")},
+		},
+	}
+
+	tracker.update(current)
+	sort.Strings(recorder.events)
+	expected := []string{
+		"changed 3 vxlan",
+		"created 4 new",
+		"created 5 added",
+		"deleted 2 gone",
+		"deleted 4 old",
+	}
+
+	if !reflect.DeepEqual(recorder.events, expected) {
+		t.Fatal(recorder.events)
+	}
+
+	// A further update with no differences delivers no events
+	recorder.events = nil
+	tracker.update(current)
+	if len(recorder.events) != 0 {
+		t.Fatal(recorder.events)
+	}
+
+	// Events that were queued before the snapshot was taken
+	// duplicate what the consumer already knows
+	events := []struct {
+		cmd   uint8
+		vport Vport
+	}{
+		{OVS_VPORT_CMD_NEW, Vport{1, NewInternalVportSpec("same")}},
+		{OVS_VPORT_CMD_SET, Vport{3, NewVxlanVportSpec("vxlan", 8472)}},
+		{OVS_VPORT_CMD_DEL, Vport{2, NewInternalVportSpec("gone")}},
+		{OVS_VPORT_CMD_DEL, Vport{4, NewInternalVportSpec("old")}},
+		{OVS_VPORT_CMD_NEW, Vport{6, NewInternalVportSpec("later")}},
+		{OVS_VPORT_CMD_DEL, Vport{5, NewInternalVportSpec("added")}},
+	}
+	for _, ev := range events {
+		if err := tracker.event(ev.cmd, 1, ev.vport); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected = []string{"created 6 later", "deleted 5 added"}
+	if !reflect.DeepEqual(recorder.events, expected) {
+		t.Fatal(recorder.events)
+	}
+}
+
+// Without a snapshot, events are passed through, and changes are only
+// reported to a VportChangeConsumer
+func TestVportTrackerEvents(t *testing.T) {
+	recorder := &vportEventRecorder{}
+	tracker := &vportTracker{
+		ifindex:  -1,
+		consumer: struct{ VportEventsConsumer }{recorder},
+		vports:   make(map[DatapathID]map[VportID]Vport),
+	}
+
+	events := []struct {
+		cmd   uint8
+		vport Vport
+	}{
+		{OVS_VPORT_CMD_DEL, Vport{1, NewInternalVportSpec("before")}},
+		{OVS_VPORT_CMD_SET, Vport{2, NewVxlanVportSpec("vxlan", 8472)}},
+		{OVS_VPORT_CMD_NEW, Vport{3, NewInternalVportSpec("new")}},
+		{OVS_VPORT_CMD_NEW, Vport{3, NewInternalVportSpec("new")}},
+		{OVS_VPORT_CMD_DEL, Vport{3, NewInternalVportSpec("new")}},
+	}
+	for _, ev := range events {
+		if err := tracker.event(ev.cmd, 1, ev.vport); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{"deleted 1 before", "created 3 new", "deleted 3 new"}
+	if !reflect.DeepEqual(recorder.events, expected) {
+		t.Fatal(recorder.events)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net"
+	"net/netip"
 	"syscall"
 )
 
@@ -438,6 +439,13 @@ func NewEthernetFlowKey() EthernetFlowKey {
 		SizeofOvsKeyEthernet)}
 }
 
+// Produce an ethernet flow key with all fields wildcarded, e.g. for
+// use with NewSetMaskedAction
+func NewWildcardEthernetFlowKey() EthernetFlowKey {
+	return EthernetFlowKey{newWildcardBlobFlowKey(OVS_KEY_ATTR_ETHERNET,
+		SizeofOvsKeyEthernet)}
+}
+
 func (fk *EthernetFlowKey) key() *OvsKeyEthernet {
 	return ovsKeyEthernetAt(fk.BlobFlowKey.key(), 0)
 }
@@ -515,41 +523,191 @@ type TunnelAttrs struct {
 	TunnelId [8]byte
 	Ipv4Src  [4]byte
 	Ipv4Dst  [4]byte
+	Ipv6Src  [16]byte
+	Ipv6Dst  [16]byte
 	Tos      uint8
 	Ttl      uint8
 	Df       bool
 	Csum     bool
 	TpSrc    uint16
 	TpDst    uint16
+
+	// In a mask, each option gives the masks for the fields of
+	// the corresponding option of the key
+	GeneveOpts []GeneveOption
 }
 
 type TunnelAttrsPresence struct {
 	TunnelId bool
 	Ipv4Src  bool
 	Ipv4Dst  bool
+	Ipv6Src  bool
+	Ipv6Dst  bool
 	Tos      bool
 	Ttl      bool
 	Df       bool
 	Csum     bool
 	TpSrc    bool
 	TpDst    bool
+
+	GeneveOpts bool
+}
+
+func (a TunnelAttrs) Equals(b TunnelAttrs) bool {
+	if len(a.GeneveOpts) != len(b.GeneveOpts) {
+		return false
+	}
+
+	for i := range a.GeneveOpts {
+		if !a.GeneveOpts[i].Equals(b.GeneveOpts[i]) {
+			return false
+		}
+	}
+
+	return a.TunnelId == b.TunnelId &&
+		a.Ipv4Src == b.Ipv4Src && a.Ipv4Dst == b.Ipv4Dst &&
+		a.Ipv6Src == b.Ipv6Src && a.Ipv6Dst == b.Ipv6Dst &&
+		a.Tos == b.Tos && a.Ttl == b.Ttl &&
+		a.Df == b.Df && a.Csum == b.Csum &&
+		a.TpSrc == b.TpSrc && a.TpDst == b.TpDst
+}
+
+// A Geneve TLV option, carried in OVS_TUNNEL_KEY_ATTR_GENEVE_OPTS.
+// The data is padded with zeros to a multiple of 4 bytes, and can be
+// at most 124 bytes long.
+type GeneveOption struct {
+	Class uint16
+	Type  uint8
+	Data  []byte
+}
+
+const maxGeneveOptionData = 124
+
+func (a GeneveOption) Equals(b GeneveOption) bool {
+	return a.Class == b.Class && a.Type == b.Type &&
+		bytes.Equal(a.paddedData(), b.paddedData())
+}
+
+func (o GeneveOption) String() string {
+	return fmt.Sprintf("{class: %04x, type: %02x, data: %s}", o.Class,
+		o.Type, hex.EncodeToString(o.Data))
+}
+
+func (o GeneveOption) paddedData() []byte {
+	if len(o.Data)%4 == 0 {
+		return o.Data
+	}
+
+	return append(append([]byte(nil), o.Data...), make([]byte, 4-len(o.Data)%4)...)
+}
+
+// Encode Geneve options in the kernel's format (struct geneve_opt).
+// In a mask, the length field is always an exact match.
+func encodeGeneveOptions(opts []GeneveOption, isMask bool) []byte {
+	var res []byte
+	for _, o := range opts {
+		data := o.paddedData()
+		l := byte(len(data) / 4)
+		if isMask {
+			l = 0x1f
+		}
+
+		hdr := MakeAlignedByteSlice(4)
+		*uint16At(hdr, 0) = uint16ToBE(o.Class)
+		hdr[2] = o.Type
+		hdr[3] = l
+		res = append(append(res, hdr...), data...)
+	}
+
+	return res
+}
+
+// Decode Geneve options.  Mask options are decoded with the lengths
+// of the corresponding key options, since the length fields of the
+// mask are themselves masks.
+func decodeGeneveOptions(data []byte, keyOpts []GeneveOption) ([]GeneveOption, error) {
+	var res []GeneveOption
+	for i := 0; len(data) > 0; i++ {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("truncated Geneve option header")
+		}
+
+		l := int(data[3]&0x1f) * 4
+		if keyOpts != nil {
+			if i >= len(keyOpts) {
+				return nil, fmt.Errorf("Geneve option mask without corresponding key")
+			}
+
+			l = len(keyOpts[i].paddedData())
+		}
+
+		if len(data) < 4+l {
+			return nil, fmt.Errorf("truncated Geneve option data")
+		}
+
+		res = append(res, GeneveOption{
+			Class: uint16FromBE(*uint16At(data, 0)),
+			Type:  data[2],
+			Data:  append([]byte(nil), data[4:4+l]...),
+		})
+		data = data[4+l:]
+	}
+
+	return res, nil
+}
+
+// Produce exact match masks for Geneve options
+func exactGeneveMasks(opts []GeneveOption) []GeneveOption {
+	res := make([]GeneveOption, len(opts))
+	for i, o := range opts {
+		data := make([]byte, len(o.paddedData()))
+		setAllBytes(data, 0xff)
+		res[i] = GeneveOption{Class: 0xffff, Type: 0xff, Data: data}
+	}
+
+	return res
+}
+
+func checkGeneveMasks(opts []GeneveOption, masks []GeneveOption) error {
+	if len(opts) != len(masks) {
+		return fmt.Errorf("Geneve options and masks differ in number (%d vs. %d)", len(opts), len(masks))
+	}
+
+	for i := range opts {
+		l := len(opts[i].paddedData())
+		if l > maxGeneveOptionData {
+			return fmt.Errorf("Geneve option data too long (%d bytes)", l)
+		}
+
+		if len(masks[i].paddedData()) != l {
+			return fmt.Errorf("Geneve option mask length differs from data length")
+		}
+	}
+
+	return nil
 }
 
 // Extract presence information from a TunnelAttrs mask
 func (ta TunnelAttrs) present() TunnelAttrsPresence {
-	// The kernel requires Ipv4Dst and Ttl to be present, so we
+	// The kernel requires a destination address (Ipv4Dst, or
+	// Ipv6Dst for an IPv6 tunnel) and Ttl to be present, so we
 	// always mark those as present, even if we end up wildcarding
-	// them.
+	// them.  IPv4 and IPv6 attributes cannot be mixed.
+	ipv6 := !AllBytes(ta.Ipv6Src[:], 0) || !AllBytes(ta.Ipv6Dst[:], 0)
 	return TunnelAttrsPresence{
 		TunnelId: !AllBytes(ta.TunnelId[:], 0),
-		Ipv4Src:  !AllBytes(ta.Ipv4Src[:], 0),
-		Ipv4Dst:  true,
+		Ipv4Src:  !ipv6 && !AllBytes(ta.Ipv4Src[:], 0),
+		Ipv4Dst:  !ipv6,
+		Ipv6Src:  !AllBytes(ta.Ipv6Src[:], 0),
+		Ipv6Dst:  ipv6,
 		Tos:      ta.Tos != 0,
 		Ttl:      true,
 		Df:       ta.Df,
 		Csum:     ta.Csum,
 		TpSrc:    ta.TpSrc != 0,
 		TpDst:    ta.TpDst != 0,
+
+		GeneveOpts: len(ta.GeneveOpts) != 0,
 	}
 }
 
@@ -570,6 +728,14 @@ func (tap TunnelAttrsPresence) mask() (res TunnelAttrs) {
 		res.Ipv4Dst = [4]byte{0xff, 0xff, 0xff, 0xff}
 	}
 
+	if tap.Ipv6Src {
+		setAllBytes(res.Ipv6Src[:], 0xff)
+	}
+
+	if tap.Ipv6Dst {
+		setAllBytes(res.Ipv6Dst[:], 0xff)
+	}
+
 	if tap.Tos {
 		res.Tos = 0xff
 	}
@@ -592,7 +758,7 @@ func (tap TunnelAttrsPresence) mask() (res TunnelAttrs) {
 	return
 }
 
-func (ta TunnelAttrs) toNlAttrs(msg *NlMsgBuilder, present TunnelAttrsPresence) {
+func (ta TunnelAttrs) toNlAttrs(msg *NlMsgBuilder, present TunnelAttrsPresence, isMask bool) {
 	if present.TunnelId {
 		msg.PutSliceAttr(OVS_TUNNEL_KEY_ATTR_ID, ta.TunnelId[:])
 	}
@@ -605,6 +771,14 @@ func (ta TunnelAttrs) toNlAttrs(msg *NlMsgBuilder, present TunnelAttrsPresence)
 		msg.PutSliceAttr(OVS_TUNNEL_KEY_ATTR_IPV4_DST, ta.Ipv4Dst[:])
 	}
 
+	if present.Ipv6Src {
+		msg.PutSliceAttr(OVS_TUNNEL_KEY_ATTR_IPV6_SRC, ta.Ipv6Src[:])
+	}
+
+	if present.Ipv6Dst {
+		msg.PutSliceAttr(OVS_TUNNEL_KEY_ATTR_IPV6_DST, ta.Ipv6Dst[:])
+	}
+
 	if present.Tos {
 		msg.PutUint8Attr(OVS_TUNNEL_KEY_ATTR_TOS, ta.Tos)
 	}
@@ -630,18 +804,33 @@ func (ta TunnelAttrs) toNlAttrs(msg *NlMsgBuilder, present TunnelAttrsPresence)
 		msg.PutUint16Attr(OVS_TUNNEL_KEY_ATTR_TP_DST,
 			uint16ToBE(ta.TpDst))
 	}
+
+	if present.GeneveOpts {
+		msg.PutSliceAttr(OVS_TUNNEL_KEY_ATTR_GENEVE_OPTS,
+			encodeGeneveOptions(ta.GeneveOpts, isMask))
+	}
 }
 
 func parseTunnelAttrsData(data []byte) (ta TunnelAttrs, present TunnelAttrsPresence, err error) {
+	return parseTunnelMaskData(data, nil)
+}
+
+// Parse tunnel attributes, which are a mask of the given key
+// attributes if key is non-nil
+func parseTunnelMaskData(data []byte, key *TunnelAttrs) (ta TunnelAttrs, present TunnelAttrsPresence, err error) {
 	attrs, err := ParseNestedAttrs(data)
 	if err != nil {
 		return
 	}
 
-	return parseTunnelAttrs(attrs)
+	return parseTunnelMask(attrs, key)
 }
 
 func parseTunnelAttrs(attrs Attrs) (ta TunnelAttrs, present TunnelAttrsPresence, err error) {
+	return parseTunnelMask(attrs, nil)
+}
+
+func parseTunnelMask(attrs Attrs, key *TunnelAttrs) (ta TunnelAttrs, present TunnelAttrsPresence, err error) {
 	present.TunnelId, err = attrs.GetOptionalBytes(OVS_TUNNEL_KEY_ATTR_ID, ta.TunnelId[:])
 	if err != nil {
 		return
@@ -653,6 +842,19 @@ func parseTunnelAttrs(attrs Attrs) (ta TunnelAttrs, present TunnelAttrsPresence,
 	}
 
 	present.Ipv4Dst, err = attrs.GetOptionalBytes(OVS_TUNNEL_KEY_ATTR_IPV4_DST, ta.Ipv4Dst[:])
+	if err != nil {
+		return
+	}
+
+	present.Ipv6Src, err = attrs.GetOptionalBytes(OVS_TUNNEL_KEY_ATTR_IPV6_SRC, ta.Ipv6Src[:])
+	if err != nil {
+		return
+	}
+
+	present.Ipv6Dst, err = attrs.GetOptionalBytes(OVS_TUNNEL_KEY_ATTR_IPV6_DST, ta.Ipv6Dst[:])
+	if err != nil {
+		return
+	}
 
 	ta.Tos, present.Tos, err = attrs.GetOptionalUint8(OVS_TUNNEL_KEY_ATTR_TOS)
 	if err != nil {
@@ -688,6 +890,19 @@ func parseTunnelAttrs(attrs Attrs) (ta TunnelAttrs, present TunnelAttrsPresence,
 	}
 	ta.TpDst = uint16FromBE(ta.TpDst)
 
+	if data, ok := attrs[OVS_TUNNEL_KEY_ATTR_GENEVE_OPTS]; ok {
+		var keyOpts []GeneveOption
+		if key != nil {
+			keyOpts = key.GeneveOpts
+			if keyOpts == nil {
+				keyOpts = []GeneveOption{}
+			}
+		}
+
+		ta.GeneveOpts, err = decodeGeneveOptions(data, keyOpts)
+		present.GeneveOpts = true
+	}
+
 	return
 }
 
@@ -708,6 +923,18 @@ func (fk TunnelFlowKey) String() string {
 	printMaskedBytes(&buf, &sep, "ipv4dst", fk.key.Ipv4Dst[:],
 		fk.mask.Ipv4Dst[:], ipv4ToString)
 
+	if !AllBytes(fk.mask.Ipv6Src[:], 0) {
+		fmt.Fprintf(&buf, "%sipv6src: %s", sep,
+			ipv6MaskedString(fk.key.Ipv6Src, fk.mask.Ipv6Src))
+		sep = ", "
+	}
+
+	if !AllBytes(fk.mask.Ipv6Dst[:], 0) {
+		fmt.Fprintf(&buf, "%sipv6dst: %s", sep,
+			ipv6MaskedString(fk.key.Ipv6Dst, fk.mask.Ipv6Dst))
+		sep = ", "
+	}
+
 	printByte := func(n string, k, m byte) {
 		if m != 0 {
 			fmt.Fprintf(&buf, "%s%s: %d", sep, n, k)
@@ -744,6 +971,14 @@ func (fk TunnelFlowKey) String() string {
 	printUint16("tpsrc", fk.key.TpSrc, fk.mask.TpSrc)
 	printUint16("tpdst", fk.key.TpDst, fk.mask.TpDst)
 
+	for i, o := range fk.key.GeneveOpts {
+		fmt.Fprintf(&buf, "%sgeneve: %s", sep, o)
+		if i < len(fk.mask.GeneveOpts) {
+			fmt.Fprintf(&buf, "&%s", fk.mask.GeneveOpts[i])
+		}
+		sep = ", "
+	}
+
 	fmt.Fprint(&buf, "}")
 	return buf.String()
 }
@@ -782,6 +1017,16 @@ func (fk *TunnelFlowKey) SetIpv4Dst(addr [4]byte) {
 	fk.mask.Ipv4Dst = [...]byte{0xff, 0xff, 0xff, 0xff}
 }
 
+func (fk *TunnelFlowKey) SetIpv6Src(addr [16]byte) {
+	fk.key.Ipv6Src = addr
+	setAllBytes(fk.mask.Ipv6Src[:], 0xff)
+}
+
+func (fk *TunnelFlowKey) SetIpv6Dst(addr [16]byte) {
+	fk.key.Ipv6Dst = addr
+	setAllBytes(fk.mask.Ipv6Dst[:], 0xff)
+}
+
 func (fk *TunnelFlowKey) SetTos(tos uint8) {
 	fk.key.Tos = tos
 	fk.mask.Tos = 0xff
@@ -812,15 +1057,31 @@ func (fk *TunnelFlowKey) SetTpDst(port uint16) {
 	fk.mask.TpDst = 0xffff
 }
 
+func (fk *TunnelFlowKey) SetGeneveOptions(opts []GeneveOption) {
+	fk.SetMaskedGeneveOptions(opts, exactGeneveMasks(opts))
+}
+
+// Each mask option gives the masks for the class, type and data of
+// the corresponding option.  The data masks must be the same length
+// as the option data.
+func (fk *TunnelFlowKey) SetMaskedGeneveOptions(opts []GeneveOption, masks []GeneveOption) {
+	fk.key.GeneveOpts = opts
+	fk.mask.GeneveOpts = masks
+}
+
 func (key TunnelFlowKey) putKeyNlAttr(msg *NlMsgBuilder) {
 	msg.PutNestedAttrs(OVS_KEY_ATTR_TUNNEL, func() {
-		key.key.toNlAttrs(msg, key.mask.present())
+		key.key.toNlAttrs(msg, key.mask.present(), false)
 	})
 }
 
 func (key TunnelFlowKey) putMaskNlAttr(msg *NlMsgBuilder) error {
+	if err := checkGeneveMasks(key.key.GeneveOpts, key.mask.GeneveOpts); err != nil {
+		return err
+	}
+
 	msg.PutNestedAttrs(OVS_KEY_ATTR_TUNNEL, func() {
-		key.mask.toNlAttrs(msg, key.mask.present())
+		key.mask.toNlAttrs(msg, key.mask.present(), true)
 	})
 	return nil
 }
@@ -830,7 +1091,7 @@ func (a TunnelFlowKey) Equals(gb FlowKey) bool {
 	if !ok {
 		return false
 	}
-	return a.key == b.key && a.mask == b.mask
+	return a.key.Equals(b.key) && a.mask.Equals(b.mask)
 }
 
 func (key TunnelFlowKey) Ignored() bool {
@@ -838,10 +1099,13 @@ func (key TunnelFlowKey) Ignored() bool {
 	return AllBytes(m.TunnelId[:], 0) &&
 		AllBytes(m.Ipv4Src[:], 0) &&
 		AllBytes(m.Ipv4Dst[:], 0) &&
+		AllBytes(m.Ipv6Src[:], 0) &&
+		AllBytes(m.Ipv6Dst[:], 0) &&
 		m.Tos == 0 &&
 		m.Ttl == 0 &&
 		!m.Csum &&
-		m.TpSrc == 0 && m.TpDst == 0
+		m.TpSrc == 0 && m.TpDst == 0 &&
+		len(m.GeneveOpts) == 0
 }
 
 func parseTunnelFlowKey(typ uint16, key []byte, mask []byte, exact bool) (FlowKey, error) {
@@ -860,7 +1124,7 @@ func parseTunnelFlowKey(typ uint16, key []byte, mask []byte, exact bool) (FlowKe
 		// We don't care about mask presence information,
 		// because a missing mask attribute means the field is
 		// wildcarded
-		m, _, err = parseTunnelAttrsData(mask)
+		m, _, err = parseTunnelMaskData(mask, &k)
 		if err != nil {
 			return nil, err
 		}
@@ -869,6 +1133,7 @@ func parseTunnelFlowKey(typ uint16, key []byte, mask []byte, exact bool) (FlowKe
 		// provided, which means the mask is implicit in the
 		// key attributes provided
 		m = kp.mask()
+		m.GeneveOpts = exactGeneveMasks(k.GeneveOpts)
 	}
 
 	return TunnelFlowKey{key: k, mask: m}, err
@@ -885,19 +1150,34 @@ var flowKeyParsers = FlowKeyParsers{
 	},
 
 	OVS_KEY_ATTR_ETHERNET:  ethernetFlowKeyParser,
-	OVS_KEY_ATTR_ETHERTYPE: blobFlowKeyParser(2, nil),
-	OVS_KEY_ATTR_IPV4:      blobFlowKeyParser(12, nil),
-	OVS_KEY_ATTR_IPV6:      blobFlowKeyParser(40, nil),
-	OVS_KEY_ATTR_TCP:       blobFlowKeyParser(4, nil),
-	OVS_KEY_ATTR_UDP:       blobFlowKeyParser(4, nil),
-	OVS_KEY_ATTR_ICMP:      blobFlowKeyParser(2, nil),
-	OVS_KEY_ATTR_ICMPV6:    blobFlowKeyParser(2, nil),
-	OVS_KEY_ATTR_ARP:       blobFlowKeyParser(24, nil),
-	OVS_KEY_ATTR_ND:        blobFlowKeyParser(28, nil),
+	OVS_KEY_ATTR_VLAN:      vlanFlowKeyParser,
+	OVS_KEY_ATTR_ETHERTYPE: ethertypeFlowKeyParser,
+	OVS_KEY_ATTR_IPV4:      ipv4FlowKeyParser,
+	OVS_KEY_ATTR_IPV6:      ipv6FlowKeyParser,
+	OVS_KEY_ATTR_TCP:       tcpFlowKeyParser,
+	OVS_KEY_ATTR_UDP:       udpFlowKeyParser,
+	OVS_KEY_ATTR_ICMP:      icmpFlowKeyParser,
+	OVS_KEY_ATTR_ICMPV6:    icmpv6FlowKeyParser,
+	OVS_KEY_ATTR_ARP:       arpFlowKeyParser,
+	OVS_KEY_ATTR_ND:        ndFlowKeyParser,
 	OVS_KEY_ATTR_SKB_MARK:  blobFlowKeyParser(4, nil),
-	OVS_KEY_ATTR_DP_HASH:   blobFlowKeyParser(4, nil),
-	OVS_KEY_ATTR_TCP_FLAGS: blobFlowKeyParser(2, nil),
-	OVS_KEY_ATTR_RECIRC_ID: blobFlowKeyParser(4, nil),
+	OVS_KEY_ATTR_SCTP:      sctpFlowKeyParser,
+	OVS_KEY_ATTR_DP_HASH:   dpHashFlowKeyParser,
+	OVS_KEY_ATTR_TCP_FLAGS: tcpFlagsFlowKeyParser,
+	OVS_KEY_ATTR_RECIRC_ID: recircIdFlowKeyParser,
+	OVS_KEY_ATTR_CT_STATE:  ctStateFlowKeyParser,
+	OVS_KEY_ATTR_CT_ZONE:   ctZoneFlowKeyParser,
+	OVS_KEY_ATTR_CT_MARK:   ctMarkFlowKeyParser,
+	OVS_KEY_ATTR_CT_LABELS: ctLabelsFlowKeyParser,
+
+	OVS_KEY_ATTR_CT_ORIG_TUPLE_IPV4: ctOrigTupleIPv4FlowKeyParser,
+	OVS_KEY_ATTR_CT_ORIG_TUPLE_IPV6: ctOrigTupleIPv6FlowKeyParser,
+
+	OVS_KEY_ATTR_MPLS: FlowKeyParser{
+		parse:      parseMplsFlowKey,
+		exactMask:  nil,
+		ignoreMask: []byte{},
+	},
 
 	OVS_KEY_ATTR_TUNNEL: FlowKeyParser{
 		parse:      parseTunnelFlowKey,
@@ -961,215 +1241,1276 @@ func parseOutputAction(typ uint16, data []byte) (Action, error) {
 	return OutputAction(*uint32At(data, 0)), nil
 }
 
-type SetTunnelAction struct {
-	TunnelAttrs
-	Present TunnelAttrsPresence
+// OVS_ACTION_ATTR_USERSPACE: Send the packet to userspace, as an
+// OVS_PACKET_CMD_ACTION upcall on the given netlink port ID.  The
+// userdata is passed back with the upcall.
+
+type UserspaceAction struct {
+	Pid      uint32
+	Userdata []byte
+
+	// If set, the tunnel vport that the packet would be output
+	// on, so that the upcall carries the egress tunnel key
+	EgressTunPort *VportID
 }
 
-func (ta SetTunnelAction) String() string {
+func NewUserspaceAction(pid uint32, userdata []byte) UserspaceAction {
+	return UserspaceAction{Pid: pid, Userdata: userdata}
+}
+
+func (a UserspaceAction) String() string {
 	var buf bytes.Buffer
-	var sep string
-	fmt.Fprint(&buf, "SetTunnelAction{")
+	fmt.Fprintf(&buf, "UserspaceAction{pid: %d", a.Pid)
 
-	if ta.Present.TunnelId {
-		fmt.Fprintf(&buf, "%sid: %s", sep,
-			hex.EncodeToString(ta.TunnelId[:]))
-		sep = ", "
+	if a.Userdata != nil {
+		fmt.Fprintf(&buf, ", userdata: %s", hex.EncodeToString(a.Userdata))
 	}
 
-	if ta.Present.Ipv4Src {
-		fmt.Fprintf(&buf, "%sipv4src: %s", sep,
-			ipv4ToString(ta.Ipv4Src[:]))
-		sep = ", "
+	if a.EgressTunPort != nil {
+		fmt.Fprintf(&buf, ", egress tunnel port: %d", *a.EgressTunPort)
 	}
 
-	if ta.Present.Ipv4Dst {
-		fmt.Fprintf(&buf, "%sipv4dst: %s", sep,
-			ipv4ToString(ta.Ipv4Dst[:]))
-		sep = ", "
+	fmt.Fprint(&buf, "}")
+	return buf.String()
+}
+
+func (UserspaceAction) typeId() uint16 {
+	return OVS_ACTION_ATTR_USERSPACE
+}
+
+func (a UserspaceAction) toNlAttr(msg *NlMsgBuilder) {
+	msg.PutNestedAttrs(OVS_ACTION_ATTR_USERSPACE, func() {
+		msg.PutUint32Attr(OVS_USERSPACE_ATTR_PID, a.Pid)
+
+		if a.Userdata != nil {
+			msg.PutSliceAttr(OVS_USERSPACE_ATTR_USERDATA, a.Userdata)
+		}
+
+		if a.EgressTunPort != nil {
+			msg.PutUint32Attr(OVS_USERSPACE_ATTR_EGRESS_TUN_PORT,
+				uint32(*a.EgressTunPort))
+		}
+	})
+}
+
+func (a UserspaceAction) Equals(bx Action) bool {
+	b, ok := bx.(UserspaceAction)
+	if !ok {
+		return false
 	}
 
-	if ta.Present.Tos {
-		fmt.Fprintf(&buf, "%stos: %d", sep, ta.Tos)
-		sep = ", "
+	if (a.EgressTunPort == nil) != (b.EgressTunPort == nil) ||
+		(a.EgressTunPort != nil && *a.EgressTunPort != *b.EgressTunPort) {
+		return false
 	}
 
-	if ta.Present.Ttl {
-		fmt.Fprintf(&buf, "%sttl: %d", sep, ta.Ttl)
-		sep = ", "
+	return a.Pid == b.Pid && bytes.Equal(a.Userdata, b.Userdata)
+}
+
+func parseUserspaceAction(typ uint16, data []byte) (Action, error) {
+	attrs, err := ParseNestedAttrs(data)
+	if err != nil {
+		return nil, err
 	}
 
-	if ta.Present.Df {
-		fmt.Fprintf(&buf, "%sdf: %t", sep, ta.Df)
-		sep = ", "
+	var a UserspaceAction
+	if a.Pid, err = attrs.GetUint32(OVS_USERSPACE_ATTR_PID); err != nil {
+		return nil, err
 	}
 
-	if ta.Present.Csum {
-		fmt.Fprintf(&buf, "%scsum: %t", sep, ta.Csum)
-		sep = ", "
+	if userdata, ok := attrs[OVS_USERSPACE_ATTR_USERDATA]; ok {
+		a.Userdata = append([]byte{}, userdata...)
 	}
 
-	if ta.Present.TpSrc {
-		fmt.Fprintf(&buf, "%stpsrc: %d", sep, ta.TpSrc)
-		sep = ", "
+	port, err := attrs.GetFixedBytes(OVS_USERSPACE_ATTR_EGRESS_TUN_PORT, 4, true)
+	if err != nil {
+		return nil, err
 	}
 
-	if ta.Present.TpDst {
-		fmt.Fprintf(&buf, "%stpdst: %d", sep, ta.TpDst)
-		sep = ", "
+	if port != nil {
+		vport := VportID(*uint32At(port, 0))
+		a.EgressTunPort = &vport
 	}
 
-	fmt.Fprint(&buf, "}")
-	return buf.String()
+	return a, nil
 }
 
-func (SetTunnelAction) typeId() uint16 {
-	return OVS_ACTION_ATTR_SET
+// OVS_ACTION_ATTR_PUSH_VLAN: Push a VLAN tag onto the packet
+
+type PushVlanAction struct {
+	Tpid uint16
+	Vid  uint16
+	Pcp  uint8
 }
 
-func (ta SetTunnelAction) toNlAttr(msg *NlMsgBuilder) {
-	msg.PutNestedAttrs(OVS_ACTION_ATTR_SET, func() {
-		msg.PutNestedAttrs(OVS_KEY_ATTR_TUNNEL, func() {
-			ta.Present.Df = ta.Df
-			ta.Present.Csum = ta.Csum
-			ta.TunnelAttrs.toNlAttrs(msg, ta.Present)
-		})
-	})
+func NewPushVlanAction(tpid uint16, vid uint16, pcp uint8) PushVlanAction {
+	return PushVlanAction{Tpid: tpid, Vid: vid, Pcp: pcp}
 }
 
-func (a SetTunnelAction) Equals(bx Action) bool {
-	b, ok := bx.(SetTunnelAction)
+func (a PushVlanAction) String() string {
+	return fmt.Sprintf("PushVlanAction{tpid: %04x, vid: %d, pcp: %d}",
+		a.Tpid, a.Vid, a.Pcp)
+}
+
+func (PushVlanAction) typeId() uint16 {
+	return OVS_ACTION_ATTR_PUSH_VLAN
+}
+
+func (a PushVlanAction) toNlAttr(msg *NlMsgBuilder) {
+	// The kernel requires the CFI bit to be set in the TCI
+	data := MakeAlignedByteSlice(4)
+	*uint16At(data, 0) = uint16ToBE(a.Tpid)
+	*uint16At(data, 2) = uint16ToBE(a.Vid&VLAN_VID_MASK |
+		uint16(a.Pcp)<<VLAN_PCP_SHIFT | VLAN_CFI_MASK)
+	msg.PutSliceAttr(OVS_ACTION_ATTR_PUSH_VLAN, data)
+}
+
+func (a PushVlanAction) Equals(bx Action) bool {
+	b, ok := bx.(PushVlanAction)
 	if !ok {
 		return false
 	}
-	return a.TunnelAttrs == b.TunnelAttrs
+	return a == b
 }
 
-func (a *SetTunnelAction) SetTunnelId(id [8]byte) {
-	a.TunnelId = id
-	a.Present.TunnelId = true
+func parsePushVlanAction(typ uint16, data []byte) (Action, error) {
+	if len(data) != 4 {
+		return nil, fmt.Errorf("flow action type %d has wrong length (expects 4 bytes, got %d)", typ, len(data))
+	}
+
+	tci := uint16FromBE(*uint16At(data, 2))
+	return PushVlanAction{
+		Tpid: uint16FromBE(*uint16At(data, 0)),
+		Vid:  tci & VLAN_VID_MASK,
+		Pcp:  uint8(tci >> VLAN_PCP_SHIFT),
+	}, nil
 }
 
-func (a *SetTunnelAction) SetIpv4Src(addr [4]byte) {
-	a.Ipv4Src = addr
-	a.Present.Ipv4Src = true
+// OVS_ACTION_ATTR_POP_VLAN: Pop the outermost VLAN tag from the packet
+
+type PopVlanAction struct{}
+
+func NewPopVlanAction() PopVlanAction {
+	return PopVlanAction{}
 }
 
-func (a *SetTunnelAction) SetIpv4Dst(addr [4]byte) {
-	a.Ipv4Dst = addr
-	a.Present.Ipv4Dst = true
+func (PopVlanAction) String() string {
+	return "PopVlanAction{}"
 }
 
-func (a *SetTunnelAction) SetTos(tos uint8) {
-	a.Tos = tos
-	a.Present.Tos = true
+func (PopVlanAction) typeId() uint16 {
+	return OVS_ACTION_ATTR_POP_VLAN
 }
 
-func (a *SetTunnelAction) SetTtl(ttl uint8) {
-	a.Ttl = ttl
-	a.Present.Ttl = true
+func (PopVlanAction) toNlAttr(msg *NlMsgBuilder) {
+	msg.PutEmptyAttr(OVS_ACTION_ATTR_POP_VLAN)
 }
 
-func (a *SetTunnelAction) SetDf(df bool) {
-	a.Df = df
-	a.Present.Df = true
+func (PopVlanAction) Equals(bx Action) bool {
+	_, ok := bx.(PopVlanAction)
+	return ok
 }
 
-func (a *SetTunnelAction) SetCsum(csum bool) {
-	a.Csum = csum
-	a.Present.Csum = true
+func parsePopVlanAction(typ uint16, data []byte) (Action, error) {
+	return PopVlanAction{}, nil
 }
 
-func (a *SetTunnelAction) SetTpSrc(port uint16) {
-	a.TpSrc = port
-	a.Present.TpSrc = true
+// OVS_ACTION_ATTR_RECIRC: Pass the packet through the datapath again,
+// with the given recirculation id.  This is usually preceded by an
+// action that changes the packet's flow keys, such as a CtAction or
+// HashAction.
+
+type RecircAction uint32
+
+func NewRecircAction(id uint32) RecircAction {
+	return RecircAction(id)
 }
 
-func (a *SetTunnelAction) SetTpDst(port uint16) {
-	a.TpDst = port
-	a.Present.TpDst = true
+func (a RecircAction) String() string {
+	return fmt.Sprintf("RecircAction{id: %d}", a)
 }
 
-type SetUnknownAction struct {
-	typ  uint16
-	data []byte
+func (a RecircAction) RecircId() uint32 {
+	return uint32(a)
 }
 
-func (a SetUnknownAction) String() string {
-	return fmt.Sprintf("SetUnknownAction{type: %d, data: %s}",
-		a.typ, hex.EncodeToString(a.data))
+func (RecircAction) typeId() uint16 {
+	return OVS_ACTION_ATTR_RECIRC
 }
 
-func (a SetUnknownAction) Equals(bx Action) bool {
-	b, ok := bx.(SetUnknownAction)
+func (a RecircAction) toNlAttr(msg *NlMsgBuilder) {
+	msg.PutUint32Attr(OVS_ACTION_ATTR_RECIRC, uint32(a))
+}
+
+func (a RecircAction) Equals(bx Action) bool {
+	b, ok := bx.(RecircAction)
 	if !ok {
 		return false
 	}
-	return a.typ == b.typ && bytes.Equal(a.data, b.data)
+	return a == b
 }
 
-func (a SetUnknownAction) typeId() uint16 {
-	return a.typ
+func parseRecircAction(typ uint16, data []byte) (Action, error) {
+	if len(data) != 4 {
+		return nil, fmt.Errorf("flow action type %d has wrong length (expects 4 bytes, got %d)", typ, len(data))
+	}
+
+	return RecircAction(*uint32At(data, 0)), nil
 }
 
-func (a SetUnknownAction) toNlAttr(msg *NlMsgBuilder) {
-	msg.PutSliceAttr(a.typ, a.data)
+// OVS_ACTION_ATTR_HASH: Compute a hash of the packet, which can then
+// be matched with a DpHashFlowKey after recirculation
+
+type HashAction struct {
+	Alg   uint32
+	Basis uint32
 }
 
-func parseSetAction(typ uint16, data []byte) (Action, error) {
-	attrs, err := ParseNestedAttrs(data)
-	if err != nil {
+func NewHashAction(alg uint32, basis uint32) HashAction {
+	return HashAction{Alg: alg, Basis: basis}
+}
+
+func (a HashAction) String() string {
+	return fmt.Sprintf("HashAction{alg: %d, basis: %d}", a.Alg, a.Basis)
+}
+
+func (HashAction) typeId() uint16 {
+	return OVS_ACTION_ATTR_HASH
+}
+
+func (a HashAction) toNlAttr(msg *NlMsgBuilder) {
+	data := MakeAlignedByteSlice(8)
+	*uint32At(data, 0) = a.Alg
+	*uint32At(data, 4) = a.Basis
+	msg.PutSliceAttr(OVS_ACTION_ATTR_HASH, data)
+}
+
+func (a HashAction) Equals(bx Action) bool {
+	b, ok := bx.(HashAction)
+	if !ok {
+		return false
+	}
+	return a == b
+}
+
+func parseHashAction(typ uint16, data []byte) (Action, error) {
+	if len(data) != 8 {
+		return nil, fmt.Errorf("flow action type %d has wrong length (expects 8 bytes, got %d)", typ, len(data))
+	}
+
+	return HashAction{
+		Alg:   *uint32At(data, 0),
+		Basis: *uint32At(data, 4),
+	}, nil
+}
+
+// OVS_ACTION_ATTR_PUSH_MPLS: Push an MPLS label stack entry onto the
+// packet.  The ethertype must be one of the MPLS ethertypes.
+
+type PushMplsAction struct {
+	Ethertype uint16
+	Label     uint32
+	TC        uint8
+	BOS       bool
+	TTL       uint8
+}
+
+func NewPushMplsAction(ethertype uint16, label uint32, tc uint8, bos bool, ttl uint8) PushMplsAction {
+	return PushMplsAction{Ethertype: ethertype, Label: label, TC: tc,
+		BOS: bos, TTL: ttl}
+}
+
+func (a PushMplsAction) String() string {
+	return fmt.Sprintf("PushMplsAction{ethertype: %04x, label: %d, tc: %d, bos: %t, ttl: %d}",
+		a.Ethertype, a.Label, a.TC, a.BOS, a.TTL)
+}
+
+func (PushMplsAction) typeId() uint16 {
+	return OVS_ACTION_ATTR_PUSH_MPLS
+}
+
+func (a PushMplsAction) lse() uint32 {
+	lse := a.Label<<MPLS_LS_LABEL_SHIFT&MPLS_LS_LABEL_MASK |
+		uint32(a.TC)<<MPLS_LS_TC_SHIFT&MPLS_LS_TC_MASK |
+		uint32(a.TTL)
+	if a.BOS {
+		lse |= MPLS_LS_S_MASK
+	}
+	return lse
+}
+
+func (a PushMplsAction) toNlAttr(msg *NlMsgBuilder) {
+	// struct ovs_action_push_mpls is padded to 8 bytes
+	data := MakeAlignedByteSlice(8)
+	*uint32At(data, 0) = uint32ToBE(a.lse())
+	*uint16At(data, 4) = uint16ToBE(a.Ethertype)
+	msg.PutSliceAttr(OVS_ACTION_ATTR_PUSH_MPLS, data)
+}
+
+func (a PushMplsAction) Equals(bx Action) bool {
+	b, ok := bx.(PushMplsAction)
+	if !ok {
+		return false
+	}
+	return a.Ethertype == b.Ethertype && a.lse() == b.lse()
+}
+
+func parsePushMplsAction(typ uint16, data []byte) (Action, error) {
+	if len(data) != 8 {
+		return nil, fmt.Errorf("flow action type %d has wrong length (expects 8 bytes, got %d)", typ, len(data))
+	}
+
+	lse := uint32FromBE(*uint32At(data, 0))
+	return PushMplsAction{
+		Ethertype: uint16FromBE(*uint16At(data, 4)),
+		Label:     lse >> MPLS_LS_LABEL_SHIFT,
+		TC:        uint8((lse & MPLS_LS_TC_MASK) >> MPLS_LS_TC_SHIFT),
+		BOS:       lse&MPLS_LS_S_MASK != 0,
+		TTL:       uint8(lse & MPLS_LS_TTL_MASK),
+	}, nil
+}
+
+// OVS_ACTION_ATTR_POP_MPLS: Pop the outermost MPLS label stack entry
+// from the packet.  The ethertype is that of the packet after the
+// pop.
+
+type PopMplsAction struct {
+	Ethertype uint16
+}
+
+func NewPopMplsAction(ethertype uint16) PopMplsAction {
+	return PopMplsAction{Ethertype: ethertype}
+}
+
+func (a PopMplsAction) String() string {
+	return fmt.Sprintf("PopMplsAction{ethertype: %04x}", a.Ethertype)
+}
+
+func (PopMplsAction) typeId() uint16 {
+	return OVS_ACTION_ATTR_POP_MPLS
+}
+
+func (a PopMplsAction) toNlAttr(msg *NlMsgBuilder) {
+	msg.PutUint16Attr(OVS_ACTION_ATTR_POP_MPLS, uint16ToBE(a.Ethertype))
+}
+
+func (a PopMplsAction) Equals(bx Action) bool {
+	b, ok := bx.(PopMplsAction)
+	if !ok {
+		return false
+	}
+	return a == b
+}
+
+func parsePopMplsAction(typ uint16, data []byte) (Action, error) {
+	if len(data) != 2 {
+		return nil, fmt.Errorf("flow action type %d has wrong length (expects 2 bytes, got %d)", typ, len(data))
+	}
+
+	return PopMplsAction{Ethertype: uint16FromBE(*uint16At(data, 0))}, nil
+}
+
+type SetTunnelAction struct {
+	TunnelAttrs
+	Present TunnelAttrsPresence
+}
+
+func (ta SetTunnelAction) String() string {
+	var buf bytes.Buffer
+	var sep string
+	fmt.Fprint(&buf, "SetTunnelAction{")
+
+	if ta.Present.TunnelId {
+		fmt.Fprintf(&buf, "%sid: %s", sep,
+			hex.EncodeToString(ta.TunnelId[:]))
+		sep = ", "
+	}
+
+	if ta.Present.Ipv4Src {
+		fmt.Fprintf(&buf, "%sipv4src: %s", sep,
+			ipv4ToString(ta.Ipv4Src[:]))
+		sep = ", "
+	}
+
+	if ta.Present.Ipv4Dst {
+		fmt.Fprintf(&buf, "%sipv4dst: %s", sep,
+			ipv4ToString(ta.Ipv4Dst[:]))
+		sep = ", "
+	}
+
+	if ta.Present.Ipv6Src {
+		fmt.Fprintf(&buf, "%sipv6src: %s", sep,
+			netip.AddrFrom16(ta.Ipv6Src))
+		sep = ", "
+	}
+
+	if ta.Present.Ipv6Dst {
+		fmt.Fprintf(&buf, "%sipv6dst: %s", sep,
+			netip.AddrFrom16(ta.Ipv6Dst))
+		sep = ", "
+	}
+
+	if ta.Present.Tos {
+		fmt.Fprintf(&buf, "%stos: %d", sep, ta.Tos)
+		sep = ", "
+	}
+
+	if ta.Present.Ttl {
+		fmt.Fprintf(&buf, "%sttl: %d", sep, ta.Ttl)
+		sep = ", "
+	}
+
+	if ta.Present.Df {
+		fmt.Fprintf(&buf, "%sdf: %t", sep, ta.Df)
+		sep = ", "
+	}
+
+	if ta.Present.Csum {
+		fmt.Fprintf(&buf, "%scsum: %t", sep, ta.Csum)
+		sep = ", "
+	}
+
+	if ta.Present.TpSrc {
+		fmt.Fprintf(&buf, "%stpsrc: %d", sep, ta.TpSrc)
+		sep = ", "
+	}
+
+	if ta.Present.TpDst {
+		fmt.Fprintf(&buf, "%stpdst: %d", sep, ta.TpDst)
+		sep = ", "
+	}
+
+	if ta.Present.GeneveOpts {
+		for _, o := range ta.GeneveOpts {
+			fmt.Fprintf(&buf, "%sgeneve: %s", sep, o)
+			sep = ", "
+		}
+	}
+
+	fmt.Fprint(&buf, "}")
+	return buf.String()
+}
+
+func (SetTunnelAction) typeId() uint16 {
+	return OVS_ACTION_ATTR_SET
+}
+
+func (ta SetTunnelAction) toNlAttr(msg *NlMsgBuilder) {
+	msg.PutNestedAttrs(OVS_ACTION_ATTR_SET, func() {
+		msg.PutNestedAttrs(OVS_KEY_ATTR_TUNNEL, func() {
+			ta.Present.Df = ta.Df
+			ta.Present.Csum = ta.Csum
+			ta.TunnelAttrs.toNlAttrs(msg, ta.Present, false)
+		})
+	})
+}
+
+func (a SetTunnelAction) Equals(bx Action) bool {
+	b, ok := bx.(SetTunnelAction)
+	if !ok {
+		return false
+	}
+	return a.TunnelAttrs.Equals(b.TunnelAttrs)
+}
+
+func (a *SetTunnelAction) SetTunnelId(id [8]byte) {
+	a.TunnelId = id
+	a.Present.TunnelId = true
+}
+
+func (a *SetTunnelAction) SetIpv4Src(addr [4]byte) {
+	a.Ipv4Src = addr
+	a.Present.Ipv4Src = true
+}
+
+func (a *SetTunnelAction) SetIpv4Dst(addr [4]byte) {
+	a.Ipv4Dst = addr
+	a.Present.Ipv4Dst = true
+}
+
+func (a *SetTunnelAction) SetIpv6Src(addr [16]byte) {
+	a.Ipv6Src = addr
+	a.Present.Ipv6Src = true
+}
+
+func (a *SetTunnelAction) SetIpv6Dst(addr [16]byte) {
+	a.Ipv6Dst = addr
+	a.Present.Ipv6Dst = true
+}
+
+func (a *SetTunnelAction) SetTos(tos uint8) {
+	a.Tos = tos
+	a.Present.Tos = true
+}
+
+func (a *SetTunnelAction) SetTtl(ttl uint8) {
+	a.Ttl = ttl
+	a.Present.Ttl = true
+}
+
+func (a *SetTunnelAction) SetDf(df bool) {
+	a.Df = df
+	a.Present.Df = true
+}
+
+func (a *SetTunnelAction) SetCsum(csum bool) {
+	a.Csum = csum
+	a.Present.Csum = true
+}
+
+func (a *SetTunnelAction) SetTpSrc(port uint16) {
+	a.TpSrc = port
+	a.Present.TpSrc = true
+}
+
+func (a *SetTunnelAction) SetTpDst(port uint16) {
+	a.TpDst = port
+	a.Present.TpDst = true
+}
+
+func (a *SetTunnelAction) SetGeneveOptions(opts []GeneveOption) {
+	a.GeneveOpts = opts
+	a.Present.GeneveOpts = true
+}
+
+type SetUnknownAction struct {
+	typ  uint16
+	data []byte
+}
+
+func (a SetUnknownAction) String() string {
+	return fmt.Sprintf("SetUnknownAction{type: %d, data: %s}",
+		a.typ, hex.EncodeToString(a.data))
+}
+
+func (a SetUnknownAction) Equals(bx Action) bool {
+	b, ok := bx.(SetUnknownAction)
+	if !ok {
+		return false
+	}
+	return a.typ == b.typ && bytes.Equal(a.data, b.data)
+}
+
+func (a SetUnknownAction) typeId() uint16 {
+	return a.typ
+}
+
+func (a SetUnknownAction) toNlAttr(msg *NlMsgBuilder) {
+	msg.PutSliceAttr(a.typ, a.data)
+}
+
+func parseSetAction(typ uint16, data []byte) (Action, error) {
+	attrs, err := ParseNestedAttrs(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// openvswitch.h says "OVS_ACTION_ATTR_SET: Replaces the
+	// contents of an existing header.  The single nested
+	// OVS_KEY_ATTR_* attribute specifies a header to modify and
+	// its value.".  So we only expect single nested attr.
+	//
+	// But, a kernel bug in 4.3 (fixed by kernel commit
+	// e905eabc90a5b7) means that OVS_KEY_ATTR_TUNNEL gets
+	// incorrectly encoded, so that the nested attributes directly
+	// contain the OVS_TUNNEL_KEY_ATTR attributes.  But we can
+	// detect the consequences of that bug: tunnel attributes must
+	// contain either OVS_TUNNEL_KEY_ATTR_IPV4_DST or
+	// OVS_TUNNEL_KEY_ATTR_IPV4_SRC, and the sizes of those
+	// attributes differ from the corresponding OVS_KEY_ATTR
+	// attributes.
+
+	if adata := attrs[OVS_TUNNEL_KEY_ATTR_IPV4_DST]; len(adata) == 4 {
+		// Not an OVS_KEY_ATTR_PRIORITY, this is the 4.3 bug.
+		return makeSetTunnelAction(parseTunnelAttrs(attrs))
+	}
+
+	if adata := attrs[OVS_TUNNEL_KEY_ATTR_IPV6_DST]; len(adata) == 16 {
+		// Not an OVS_KEY_ATTR_ARP, this is the 4.3 bug.
+		return makeSetTunnelAction(parseTunnelAttrs(attrs))
+	}
+
+	if adata := attrs[OVS_KEY_ATTR_TUNNEL]; adata != nil {
+		return makeSetTunnelAction(parseTunnelAttrsData(adata))
+	}
+
+	for atyp, adata := range attrs {
+		return SetUnknownAction{typ: atyp, data: adata}, nil
+	}
+
+	// Shouldn't happen, but just in case
+	return SetUnknownAction{typ: 0}, nil
+}
+
+func makeSetTunnelAction(ta TunnelAttrs, present TunnelAttrsPresence, err error) (Action, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return SetTunnelAction{TunnelAttrs: ta, Present: present}, nil
+}
+
+// OVS_ACTION_ATTR_SET_MASKED: Rewrite the packet header fields
+// selected by the mask of a flow key to the values in the key.  The
+// kernel supports ethernet, IPv4, IPv6, TCP, UDP and SCTP flow keys
+// here, but not the IP protocol or fragment fields.  The flow must
+// match the corresponding ethertype and IP protocol.
+
+type SetMaskedAction struct {
+	key BlobFlowKey
+}
+
+func NewSetMaskedAction(key BlobFlowKeyish) SetMaskedAction {
+	// The kernel rejects keys with bits set outside the mask, so
+	// clear them in a copy of the key
+	bk := key.toBlobFlowKey()
+	km := MakeAlignedByteSlice(len(bk.keyMask))
+	copy(km, bk.keyMask)
+	bk.keyMask = km
+	k := bk.key()
+	m := bk.mask()
+	for i := range k {
+		k[i] &= m[i]
+	}
+
+	return SetMaskedAction{key: bk}
+}
+
+// The rewrite as a flow key of the appropriate type,
+// e.g. IPv4FlowKey
+func (a SetMaskedAction) Key() FlowKey {
+	bk := a.key
+	parser, ok := flowKeyParsers[bk.typ]
+	if !ok {
+		return bk
+	}
+
+	// Some parsers modify the mask, so pass copies
+	fk, err := parser.parse(bk.typ, append([]byte(nil), bk.key()...),
+		append([]byte(nil), bk.mask()...), false)
+	if err != nil {
+		return bk
+	}
+
+	return fk
+}
+
+func (a SetMaskedAction) String() string {
+	return fmt.Sprintf("SetMaskedAction{%v}", a.Key())
+}
+
+func (SetMaskedAction) typeId() uint16 {
+	return OVS_ACTION_ATTR_SET_MASKED
+}
+
+func (a SetMaskedAction) toNlAttr(msg *NlMsgBuilder) {
+	msg.PutNestedAttrs(OVS_ACTION_ATTR_SET_MASKED, func() {
+		// The attribute value is the key followed by the mask
+		msg.PutSliceAttr(a.key.typ, a.key.keyMask)
+	})
+}
+
+func (a SetMaskedAction) Equals(bx Action) bool {
+	b, ok := bx.(SetMaskedAction)
+	if !ok {
+		return false
+	}
+	return a.key.Equals(b.key)
+}
+
+func parseSetMaskedAction(typ uint16, data []byte) (Action, error) {
+	attrs, err := ParseNestedAttrs(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(attrs) > 1 {
+		return nil, fmt.Errorf("set masked action has %d attributes (expected 1)", len(attrs))
+	}
+
+	for ktyp, kdata := range attrs {
+		if len(kdata)%2 != 0 {
+			return nil, fmt.Errorf("set masked action for flow key type %d has odd length %d", ktyp, len(kdata))
+		}
+
+		size := len(kdata) / 2
+		bk, err := parseBlobFlowKey(ktyp, kdata[:size], kdata[size:], size)
+		if err != nil {
+			return nil, err
+		}
+
+		return SetMaskedAction{key: bk}, nil
+	}
+
+	return nil, fmt.Errorf("set masked action has no attributes")
+}
+
+var actionParsers = map[uint16](func(uint16, []byte) (Action, error)){
+	OVS_ACTION_ATTR_OUTPUT:    parseOutputAction,
+	OVS_ACTION_ATTR_USERSPACE: parseUserspaceAction,
+	OVS_ACTION_ATTR_SET:       parseSetAction,
+	OVS_ACTION_ATTR_PUSH_VLAN: parsePushVlanAction,
+	OVS_ACTION_ATTR_POP_VLAN:  parsePopVlanAction,
+	OVS_ACTION_ATTR_RECIRC:    parseRecircAction,
+	OVS_ACTION_ATTR_HASH:      parseHashAction,
+	OVS_ACTION_ATTR_PUSH_MPLS: parsePushMplsAction,
+	OVS_ACTION_ATTR_POP_MPLS:  parsePopMplsAction,
+	OVS_ACTION_ATTR_CT:        parseCtAction,
+	OVS_ACTION_ATTR_TRUNC:     parseTruncAction,
+	OVS_ACTION_ATTR_PUSH_ETH:  parsePushEthAction,
+	OVS_ACTION_ATTR_POP_ETH:   parsePopEthAction,
+	OVS_ACTION_ATTR_METER:     parseMeterAction,
+	OVS_ACTION_ATTR_DROP:      parseDropAction,
+
+	OVS_ACTION_ATTR_SET_MASKED: parseSetMaskedAction,
+}
+
+func init() {
+	// The parsers for actions with nested action lists refer to
+	// actionParsers, so they have to be registered here to avoid
+	// an initialization loop
+	actionParsers[OVS_ACTION_ATTR_SAMPLE] = parseSampleAction
+	actionParsers[OVS_ACTION_ATTR_CLONE] = parseCloneAction
+	actionParsers[OVS_ACTION_ATTR_CHECK_PKT_LEN] = parseCheckPktLenAction
+	actionParsers[OVS_ACTION_ATTR_DEC_TTL] = parseDecTtlAction
+}
+
+func parseActions(actattrs []Attr) ([]Action, error) {
+	actions := make([]Action, 0)
+	for _, actattr := range actattrs {
+		parser, ok := actionParsers[actattr.typ]
+		if !ok {
+			parser = parseUnknownAction
+		}
+
+		action, err := parser(actattr.typ, actattr.val)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, action)
+	}
+
+	return actions, nil
+}
+
+// Parse an attribute whose value is itself a list of actions
+func parseNestedActions(data []byte) ([]Action, error) {
+	parser := NlMsgParser{data: data, pos: 0}
+	actattrs := make([]Attr, 0)
+	err := parser.parseAttrs(func(typ uint16, val []byte) {
+		actattrs = append(actattrs, Attr{typ, val})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return parseActions(actattrs)
+}
+
+func putActionsNlAttrs(msg *NlMsgBuilder, actions []Action) {
+	for _, a := range actions {
+		a.toNlAttr(msg)
+	}
+}
+
+func actionsEqual(a []Action, b []Action) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if !a[i].Equals(b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// An action of a type we don't know about, e.g. from a flow installed
+// by another controller.  It is kept as the raw attribute, so that it
+// can be written back unchanged.
+
+type UnknownAction struct {
+	Type uint16
+	Data []byte
+}
+
+func NewUnknownAction(typ uint16, data []byte) UnknownAction {
+	return UnknownAction{Type: typ, Data: data}
+}
+
+func (a UnknownAction) String() string {
+	return fmt.Sprintf("UnknownAction{type: %d, data: %s}", a.Type,
+		hex.EncodeToString(a.Data))
+}
+
+func (a UnknownAction) typeId() uint16 {
+	return a.Type
+}
+
+func (a UnknownAction) toNlAttr(msg *NlMsgBuilder) {
+	msg.PutSliceAttr(a.Type, a.Data)
+}
+
+func (a UnknownAction) Equals(bx Action) bool {
+	b, ok := bx.(UnknownAction)
+	if !ok {
+		return false
+	}
+	return a.Type == b.Type && bytes.Equal(a.Data, b.Data)
+}
+
+func parseUnknownAction(typ uint16, data []byte) (Action, error) {
+	return UnknownAction{Type: typ, Data: append([]byte(nil), data...)}, nil
+}
+
+// OVS_ACTION_ATTR_SAMPLE: Perform the nested actions on a random
+// sample of packets.  The probability is a fraction of 2^32-1, so
+// SampleProbabilityAll means every packet.
+
+type SampleAction struct {
+	Probability uint32
+	Actions     []Action
+}
+
+const SampleProbabilityAll = 0xffffffff
+
+func NewSampleAction(probability uint32, actions []Action) SampleAction {
+	return SampleAction{Probability: probability, Actions: actions}
+}
+
+// The probability for sampling one in n packets
+func SampleOneIn(n uint32) uint32 {
+	if n == 0 {
+		return 0
+	}
+
+	return uint32((uint64(SampleProbabilityAll) + uint64(n)/2) / uint64(n))
+}
+
+func (a SampleAction) String() string {
+	return fmt.Sprintf("SampleAction{probability: %d, actions: %v}",
+		a.Probability, a.Actions)
+}
+
+func (SampleAction) typeId() uint16 {
+	return OVS_ACTION_ATTR_SAMPLE
+}
+
+func (a SampleAction) toNlAttr(msg *NlMsgBuilder) {
+	msg.PutNestedAttrs(OVS_ACTION_ATTR_SAMPLE, func() {
+		msg.PutUint32Attr(OVS_SAMPLE_ATTR_PROBABILITY, a.Probability)
+		msg.PutNestedAttrs(OVS_SAMPLE_ATTR_ACTIONS, func() {
+			putActionsNlAttrs(msg, a.Actions)
+		})
+	})
+}
+
+func (a SampleAction) Equals(bx Action) bool {
+	b, ok := bx.(SampleAction)
+	if !ok {
+		return false
+	}
+	return a.Probability == b.Probability && actionsEqual(a.Actions, b.Actions)
+}
+
+func parseSampleAction(typ uint16, data []byte) (Action, error) {
+	attrs, err := ParseNestedAttrs(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var a SampleAction
+	if a.Probability, err = attrs.GetUint32(OVS_SAMPLE_ATTR_PROBABILITY); err != nil {
+		return nil, err
+	}
+
+	actattrs, err := attrs.GetOrderedAttrs(OVS_SAMPLE_ATTR_ACTIONS)
+	if err != nil {
+		return nil, err
+	}
+
+	a.Actions, err = parseActions(actattrs)
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// OVS_ACTION_ATTR_CLONE: Perform the nested actions on a copy of the
+// packet, so that they do not affect the following actions
+
+type CloneAction struct {
+	Actions []Action
+}
+
+func NewCloneAction(actions []Action) CloneAction {
+	return CloneAction{Actions: actions}
+}
+
+func (a CloneAction) String() string {
+	return fmt.Sprintf("CloneAction{actions: %v}", a.Actions)
+}
+
+func (CloneAction) typeId() uint16 {
+	return OVS_ACTION_ATTR_CLONE
+}
+
+func (a CloneAction) toNlAttr(msg *NlMsgBuilder) {
+	msg.PutNestedAttrs(OVS_ACTION_ATTR_CLONE, func() {
+		putActionsNlAttrs(msg, a.Actions)
+	})
+}
+
+func (a CloneAction) Equals(bx Action) bool {
+	b, ok := bx.(CloneAction)
+	if !ok {
+		return false
+	}
+	return actionsEqual(a.Actions, b.Actions)
+}
+
+func parseCloneAction(typ uint16, data []byte) (Action, error) {
+	actions, err := parseNestedActions(data)
+	if err != nil {
 		return nil, err
 	}
 
-	// openvswitch.h says "OVS_ACTION_ATTR_SET: Replaces the
-	// contents of an existing header.  The single nested
-	// OVS_KEY_ATTR_* attribute specifies a header to modify and
-	// its value.".  So we only expect single nested attr.
-	//
-	// But, a kernel bug in 4.3 (fixed by kernel commit
-	// e905eabc90a5b7) means that OVS_KEY_ATTR_TUNNEL gets
-	// incorrectly encoded, so that the nested attributes directly
-	// contain the OVS_TUNNEL_KEY_ATTR attributes.  But we can
-	// detect the consequences of that bug: tunnel attributes must
-	// contain either OVS_TUNNEL_KEY_ATTR_IPV4_DST or
-	// OVS_TUNNEL_KEY_ATTR_IPV4_SRC, and the sizes of those
-	// attributes differ from the corresponding OVS_KEY_ATTR
-	// attributes.
+	return CloneAction{Actions: actions}, nil
+}
 
-	if adata := attrs[OVS_TUNNEL_KEY_ATTR_IPV4_DST]; len(adata) == 4 {
-		// Not an OVS_KEY_ATTR_PRIORITY, this is the 4.3 bug.
-		return makeSetTunnelAction(parseTunnelAttrs(attrs))
+// OVS_ACTION_ATTR_CHECK_PKT_LEN: Perform one of two nested action
+// lists, depending on whether the packet is longer than PktLen
+
+type CheckPktLenAction struct {
+	PktLen      uint16
+	IfGreater   []Action
+	IfLessEqual []Action
+}
+
+func NewCheckPktLenAction(pktLen uint16, ifGreater []Action, ifLessEqual []Action) CheckPktLenAction {
+	return CheckPktLenAction{
+		PktLen:      pktLen,
+		IfGreater:   ifGreater,
+		IfLessEqual: ifLessEqual,
 	}
+}
 
-	if adata := attrs[OVS_TUNNEL_KEY_ATTR_IPV6_DST]; len(adata) == 16 {
-		// Not an OVS_KEY_ATTR_ARP, this is the 4.3 bug.
-		return makeSetTunnelAction(parseTunnelAttrs(attrs))
+func (a CheckPktLenAction) String() string {
+	return fmt.Sprintf("CheckPktLenAction{pkt_len: %d, if_greater: %v, if_less_equal: %v}",
+		a.PktLen, a.IfGreater, a.IfLessEqual)
+}
+
+func (CheckPktLenAction) typeId() uint16 {
+	return OVS_ACTION_ATTR_CHECK_PKT_LEN
+}
+
+func (a CheckPktLenAction) toNlAttr(msg *NlMsgBuilder) {
+	msg.PutNestedAttrs(OVS_ACTION_ATTR_CHECK_PKT_LEN, func() {
+		msg.PutUint16Attr(OVS_CHECK_PKT_LEN_ATTR_PKT_LEN, a.PktLen)
+		msg.PutNestedAttrs(OVS_CHECK_PKT_LEN_ATTR_ACTIONS_IF_GREATER, func() {
+			putActionsNlAttrs(msg, a.IfGreater)
+		})
+		msg.PutNestedAttrs(OVS_CHECK_PKT_LEN_ATTR_ACTIONS_IF_LESS_EQUAL, func() {
+			putActionsNlAttrs(msg, a.IfLessEqual)
+		})
+	})
+}
+
+func (a CheckPktLenAction) Equals(bx Action) bool {
+	b, ok := bx.(CheckPktLenAction)
+	if !ok {
+		return false
 	}
+	return a.PktLen == b.PktLen &&
+		actionsEqual(a.IfGreater, b.IfGreater) &&
+		actionsEqual(a.IfLessEqual, b.IfLessEqual)
+}
 
-	if adata := attrs[OVS_KEY_ATTR_TUNNEL]; adata != nil {
-		return makeSetTunnelAction(parseTunnelAttrsData(adata))
+func parseCheckPktLenAction(typ uint16, data []byte) (Action, error) {
+	attrs, err := ParseNestedAttrs(data)
+	if err != nil {
+		return nil, err
 	}
 
-	for atyp, adata := range attrs {
-		return SetUnknownAction{typ: atyp, data: adata}, nil
+	var a CheckPktLenAction
+	if a.PktLen, err = attrs.GetUint16(OVS_CHECK_PKT_LEN_ATTR_PKT_LEN); err != nil {
+		return nil, err
 	}
 
-	// Shouldn't happen, but just in case
-	return SetUnknownAction{typ: 0}, nil
+	for _, branch := range []struct {
+		typ     uint16
+		actions *[]Action
+	}{
+		{OVS_CHECK_PKT_LEN_ATTR_ACTIONS_IF_GREATER, &a.IfGreater},
+		{OVS_CHECK_PKT_LEN_ATTR_ACTIONS_IF_LESS_EQUAL, &a.IfLessEqual},
+	} {
+		actattrs, err := attrs.GetOrderedAttrs(branch.typ)
+		if err != nil {
+			return nil, err
+		}
+
+		*branch.actions, err = parseActions(actattrs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
 }
 
-func makeSetTunnelAction(ta TunnelAttrs, present TunnelAttrsPresence, err error) (Action, error) {
+// OVS_ACTION_ATTR_DEC_TTL: Decrement the IP TTL or hop limit.  If it
+// has expired, the nested actions are performed instead (and the
+// packet is otherwise dropped).
+
+type DecTtlAction struct {
+	Actions []Action
+}
+
+func NewDecTtlAction(actions []Action) DecTtlAction {
+	return DecTtlAction{Actions: actions}
+}
+
+func (a DecTtlAction) String() string {
+	return fmt.Sprintf("DecTtlAction{actions: %v}", a.Actions)
+}
+
+func (DecTtlAction) typeId() uint16 {
+	return OVS_ACTION_ATTR_DEC_TTL
+}
+
+func (a DecTtlAction) toNlAttr(msg *NlMsgBuilder) {
+	msg.PutNestedAttrs(OVS_ACTION_ATTR_DEC_TTL, func() {
+		msg.PutNestedAttrs(OVS_DEC_TTL_ATTR_ACTION, func() {
+			putActionsNlAttrs(msg, a.Actions)
+		})
+	})
+}
+
+func (a DecTtlAction) Equals(bx Action) bool {
+	b, ok := bx.(DecTtlAction)
+	if !ok {
+		return false
+	}
+	return actionsEqual(a.Actions, b.Actions)
+}
+
+func parseDecTtlAction(typ uint16, data []byte) (Action, error) {
+	attrs, err := ParseNestedAttrs(data)
 	if err != nil {
 		return nil, err
 	}
 
-	return SetTunnelAction{TunnelAttrs: ta, Present: present}, nil
+	actattrs, err := attrs.GetOrderedAttrs(OVS_DEC_TTL_ATTR_ACTION)
+	if err != nil {
+		return nil, err
+	}
+
+	actions, err := parseActions(actattrs)
+	if err != nil {
+		return nil, err
+	}
+
+	return DecTtlAction{Actions: actions}, nil
 }
 
-var actionParsers = map[uint16](func(uint16, []byte) (Action, error)){
-	OVS_ACTION_ATTR_OUTPUT: parseOutputAction,
-	OVS_ACTION_ATTR_SET:    parseSetAction,
+// OVS_ACTION_ATTR_TRUNC: Truncate the packet to at most MaxLen bytes
+// when it is next output
+
+type TruncAction struct {
+	MaxLen uint32
+}
+
+func NewTruncAction(maxLen uint32) TruncAction {
+	return TruncAction{MaxLen: maxLen}
+}
+
+func (a TruncAction) String() string {
+	return fmt.Sprintf("TruncAction{max_len: %d}", a.MaxLen)
+}
+
+func (TruncAction) typeId() uint16 {
+	return OVS_ACTION_ATTR_TRUNC
+}
+
+func (a TruncAction) toNlAttr(msg *NlMsgBuilder) {
+	msg.PutUint32Attr(OVS_ACTION_ATTR_TRUNC, a.MaxLen)
+}
+
+func (a TruncAction) Equals(bx Action) bool {
+	b, ok := bx.(TruncAction)
+	if !ok {
+		return false
+	}
+	return a == b
+}
+
+func parseTruncAction(typ uint16, data []byte) (Action, error) {
+	if len(data) != SizeofOvsActionTrunc {
+		return nil, fmt.Errorf("flow action type %d has wrong length (expects %d bytes, got %d)", typ, SizeofOvsActionTrunc, len(data))
+	}
+
+	return TruncAction{MaxLen: *uint32At(data, 0)}, nil
+}
+
+// OVS_ACTION_ATTR_PUSH_ETH: Push an ethernet header onto a layer 3
+// packet
+
+type PushEthAction struct {
+	EthSrc [ETH_ALEN]byte
+	EthDst [ETH_ALEN]byte
+}
+
+func NewPushEthAction(src [ETH_ALEN]byte, dst [ETH_ALEN]byte) PushEthAction {
+	return PushEthAction{EthSrc: src, EthDst: dst}
+}
+
+func (a PushEthAction) String() string {
+	return fmt.Sprintf("PushEthAction{src: %s, dst: %s}",
+		net.HardwareAddr(a.EthSrc[:]), net.HardwareAddr(a.EthDst[:]))
+}
+
+func (PushEthAction) typeId() uint16 {
+	return OVS_ACTION_ATTR_PUSH_ETH
+}
+
+func (a PushEthAction) toNlAttr(msg *NlMsgBuilder) {
+	data := MakeAlignedByteSlice(SizeofOvsActionPushEth)
+	copy(data[:ETH_ALEN], a.EthSrc[:])
+	copy(data[ETH_ALEN:], a.EthDst[:])
+	msg.PutSliceAttr(OVS_ACTION_ATTR_PUSH_ETH, data)
+}
+
+func (a PushEthAction) Equals(bx Action) bool {
+	b, ok := bx.(PushEthAction)
+	if !ok {
+		return false
+	}
+	return a == b
+}
+
+func parsePushEthAction(typ uint16, data []byte) (Action, error) {
+	if len(data) != SizeofOvsActionPushEth {
+		return nil, fmt.Errorf("flow action type %d has wrong length (expects %d bytes, got %d)", typ, SizeofOvsActionPushEth, len(data))
+	}
+
+	var a PushEthAction
+	copy(a.EthSrc[:], data[:ETH_ALEN])
+	copy(a.EthDst[:], data[ETH_ALEN:])
+	return a, nil
+}
+
+// OVS_ACTION_ATTR_POP_ETH: Pop the ethernet header, leaving a layer 3
+// packet
+
+type PopEthAction struct{}
+
+func NewPopEthAction() PopEthAction {
+	return PopEthAction{}
+}
+
+func (PopEthAction) String() string {
+	return "PopEthAction{}"
+}
+
+func (PopEthAction) typeId() uint16 {
+	return OVS_ACTION_ATTR_POP_ETH
+}
+
+func (PopEthAction) toNlAttr(msg *NlMsgBuilder) {
+	msg.PutEmptyAttr(OVS_ACTION_ATTR_POP_ETH)
+}
+
+func (PopEthAction) Equals(bx Action) bool {
+	_, ok := bx.(PopEthAction)
+	return ok
+}
+
+func parsePopEthAction(typ uint16, data []byte) (Action, error) {
+	return PopEthAction{}, nil
+}
+
+// OVS_ACTION_ATTR_METER: Apply the meter with the given id, dropping
+// the packet if it exceeds the meter's rate
+
+type MeterAction uint32
+
+func NewMeterAction(id uint32) MeterAction {
+	return MeterAction(id)
+}
+
+func (a MeterAction) String() string {
+	return fmt.Sprintf("MeterAction{id: %d}", a)
+}
+
+func (a MeterAction) MeterId() uint32 {
+	return uint32(a)
+}
+
+func (MeterAction) typeId() uint16 {
+	return OVS_ACTION_ATTR_METER
+}
+
+func (a MeterAction) toNlAttr(msg *NlMsgBuilder) {
+	msg.PutUint32Attr(OVS_ACTION_ATTR_METER, uint32(a))
+}
+
+func (a MeterAction) Equals(bx Action) bool {
+	b, ok := bx.(MeterAction)
+	if !ok {
+		return false
+	}
+	return a == b
+}
+
+func parseMeterAction(typ uint16, data []byte) (Action, error) {
+	if len(data) != 4 {
+		return nil, fmt.Errorf("flow action type %d has wrong length (expects 4 bytes, got %d)", typ, len(data))
+	}
+
+	return MeterAction(*uint32At(data, 0)), nil
+}
+
+// OVS_ACTION_ATTR_DROP: Explicitly drop the packet, recording the
+// reason in the datapath's drop statistics.  It must be the last
+// action.
+
+type DropAction struct {
+	Reason uint32
+}
+
+func NewDropAction(reason uint32) DropAction {
+	return DropAction{Reason: reason}
+}
+
+func (a DropAction) String() string {
+	return fmt.Sprintf("DropAction{reason: %d}", a.Reason)
+}
+
+func (DropAction) typeId() uint16 {
+	return OVS_ACTION_ATTR_DROP
+}
+
+func (a DropAction) toNlAttr(msg *NlMsgBuilder) {
+	msg.PutUint32Attr(OVS_ACTION_ATTR_DROP, a.Reason)
+}
+
+func (a DropAction) Equals(bx Action) bool {
+	b, ok := bx.(DropAction)
+	if !ok {
+		return false
+	}
+	return a == b
+}
+
+func parseDropAction(typ uint16, data []byte) (Action, error) {
+	if len(data) != 4 {
+		return nil, fmt.Errorf("flow action type %d has wrong length (expects 4 bytes, got %d)", typ, len(data))
+	}
+
+	return DropAction{Reason: *uint32At(data, 0)}, nil
 }
 
 // Complete flows
@@ -1211,29 +2552,14 @@ func (f FlowSpec) toNlAttrs(msg *NlMsgBuilder) error {
 	}
 
 	msg.PutNestedAttrs(OVS_FLOW_ATTR_ACTIONS, func() {
-		for _, a := range f.Actions {
-			a.toNlAttr(msg)
-		}
+		putActionsNlAttrs(msg, f.Actions)
 	})
 
 	return nil
 }
 
 func (a FlowSpec) Equals(b FlowSpec) bool {
-	if !a.FlowKeys.Equals(b.FlowKeys) {
-		return false
-	}
-	if len(a.Actions) != len(b.Actions) {
-		return false
-	}
-
-	for i := range a.Actions {
-		if !a.Actions[i].Equals(b.Actions[i]) {
-			return false
-		}
-	}
-
-	return true
+	return a.FlowKeys.Equals(b.FlowKeys) && actionsEqual(a.Actions, b.Actions)
 }
 
 func (dp DatapathHandle) parseFlowMsg(msg *NlMsgParser, cmd int) (Attrs, error) {
@@ -1265,28 +2591,26 @@ func parseFlowSpec(attrs Attrs) (f FlowSpec, err error) {
 		return f, err
 	}
 
-	actions := make([]Action, 0)
-	for _, actattr := range actattrs {
-		parser, ok := actionParsers[actattr.typ]
-		if !ok {
-			return f, fmt.Errorf("unknown action type %d (value %v)", actattr.typ, actattr.val)
-		}
+	f.Actions, err = parseActions(actattrs)
+	return f, err
+}
 
-		action, err := parser(actattr.typ, actattr.val)
-		if err != nil {
-			return f, err
-		}
-		actions = append(actions, action)
-	}
+// Create a flow, or replace the actions of an existing flow with the
+// same key
+func (dp DatapathHandle) CreateFlow(f FlowSpec) error {
+	return dp.createFlow(f, RequestFlags)
+}
 
-	f.Actions = actions
-	return f, nil
+// Create a flow, failing with an IsFlowAlreadyExistsError error if a
+// flow with the same key exists
+func (dp DatapathHandle) CreateFlowExclusive(f FlowSpec) error {
+	return dp.createFlow(f, RequestFlags|syscall.NLM_F_CREATE|syscall.NLM_F_EXCL)
 }
 
-func (dp DatapathHandle) CreateFlow(f FlowSpec) error {
+func (dp DatapathHandle) createFlow(f FlowSpec, flags uint16) error {
 	dpif := dp.dpif
 
-	req := NewNlMsgBuilder(RequestFlags, dpif.families[FLOW].id)
+	req := NewNlMsgBuilder(flags, dpif.families[FLOW].id)
 	req.PutGenlMsghdr(OVS_FLOW_CMD_NEW, OVS_FLOW_VERSION)
 	req.putOvsHeader(dp.ifindex)
 	if err := f.toNlAttrs(req); err != nil {
@@ -1311,7 +2635,34 @@ func (dp DatapathHandle) DeleteFlow(fks FlowKeys) error {
 	return err
 }
 
+// Delete all flows.  The kernel does this atomically, and doesn't send
+// a reply message, so ask for an ack instead.
+func (dp DatapathHandle) FlushFlows() error {
+	dpif := dp.dpif
+
+	req := NewNlMsgBuilder(syscall.NLM_F_REQUEST|syscall.NLM_F_ACK, dpif.families[FLOW].id)
+	req.PutGenlMsghdr(OVS_FLOW_CMD_DEL, OVS_FLOW_VERSION)
+	req.putOvsHeader(dp.ifindex)
+
+	_, err := dpif.sock.Request(req)
+	return err
+}
+
 func (dp DatapathHandle) ClearFlow(f FlowSpec) error {
+	return dp.ModifyFlow(f, ModifyOptions{ClearStats: true})
+}
+
+type ModifyOptions struct {
+	// Reset the flow's statistics, rather than keeping them
+	ClearStats bool
+
+	// Create the flow if it does not exist, rather than failing
+	// with an IsNoSuchFlowError error
+	CreateIfMissing bool
+}
+
+// Replace the actions of the existing flow with the same key as f
+func (dp DatapathHandle) ModifyFlow(f FlowSpec, opts ModifyOptions) error {
 	dpif := dp.dpif
 
 	req := NewNlMsgBuilder(RequestFlags, dpif.families[FLOW].id)
@@ -1321,9 +2672,16 @@ func (dp DatapathHandle) ClearFlow(f FlowSpec) error {
 		return err
 	}
 
-	req.PutEmptyAttr(OVS_FLOW_ATTR_CLEAR)
+	if opts.ClearStats {
+		req.PutEmptyAttr(OVS_FLOW_ATTR_CLEAR)
+	}
 
 	_, err := dpif.sock.Request(req)
+	if IsNoSuchFlowError(err) && opts.CreateIfMissing {
+		// A new flow starts with clear stats anyway
+		err = dp.CreateFlow(f)
+	}
+
 	return err
 }
 
@@ -1331,6 +2689,10 @@ func IsNoSuchFlowError(err error) bool {
 	return err == NetlinkError(syscall.ENOENT)
 }
 
+func IsFlowAlreadyExistsError(err error) bool {
+	return err == NetlinkError(syscall.EEXIST)
+}
+
 type FlowInfo struct {
 	FlowSpec
 	Packets uint64
@@ -1366,6 +2728,29 @@ func parseFlowInfo(attrs Attrs) (fi FlowInfo, err error) {
 	return
 }
 
+func (dp DatapathHandle) LookupFlow(fks FlowKeys) (FlowInfo, error) {
+	dpif := dp.dpif
+
+	req := NewNlMsgBuilder(RequestFlags, dpif.families[FLOW].id)
+	req.PutGenlMsghdr(OVS_FLOW_CMD_GET, OVS_FLOW_VERSION)
+	req.putOvsHeader(dp.ifindex)
+	if err := fks.toNlAttrs(req); err != nil {
+		return FlowInfo{}, err
+	}
+
+	resp, err := dpif.sock.Request(req)
+	if err != nil {
+		return FlowInfo{}, err
+	}
+
+	attrs, err := dp.parseFlowMsg(resp, OVS_FLOW_CMD_GET)
+	if err != nil {
+		return FlowInfo{}, err
+	}
+
+	return parseFlowInfo(attrs)
+}
+
 func (dp DatapathHandle) EnumerateFlows() ([]FlowInfo, error) {
 	dpif := dp.dpif
 	res := make([]FlowInfo, 0)
@@ -0,0 +1,1270 @@
+package odp
+
+import (
+	"bytes"
+	"net/netip"
+	"syscall"
+	"testing"
+)
+
+// Encode the flow as it would be sent to the kernel, and parse it
+// back as if it came from a flow dump.  These tests don't need the
+// openvswitch kernel module.
+func flowRoundTrip(t *testing.T, f FlowSpec) FlowSpec {
+	req := NewNlMsgBuilder(RequestFlags, 0)
+	if err := f.toNlAttrs(req); err != nil {
+		t.Fatal(err)
+	}
+
+	attrs, err := ParseNestedAttrs(req.buf[syscall.NLMSG_HDRLEN:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := parseFlowSpec(attrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !f.Equals(g) || !g.Equals(f) {
+		t.Fatalf("expected %v, got %v", f, g)
+	}
+
+	return g
+}
+
+// Parse flow key attributes laid out as the kernel sends them in a
+// flow dump.  If masks is nil, the mask attribute is omitted, which
+// means an exact match.
+func kernelFlowKeys(keys func(*NlMsgBuilder), masks func(*NlMsgBuilder)) (FlowKeys, error) {
+	msg := NewNlMsgBuilder(RequestFlags, 0)
+	msg.PutNestedAttrs(OVS_FLOW_ATTR_KEY, func() { keys(msg) })
+	if masks != nil {
+		msg.PutNestedAttrs(OVS_FLOW_ATTR_MASK, func() { masks(msg) })
+	}
+
+	attrs, err := ParseNestedAttrs(msg.buf[syscall.NLMSG_HDRLEN:])
+	if err != nil {
+		return nil, err
+	}
+
+	keyAttrs, err := attrs.GetNestedAttrs(OVS_FLOW_ATTR_KEY, false)
+	if err != nil {
+		return nil, err
+	}
+
+	maskAttrs, err := attrs.GetNestedAttrs(OVS_FLOW_ATTR_MASK, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseFlowKeys(keyAttrs, maskAttrs)
+}
+
+// Parse action attributes laid out as the kernel sends them in a
+// flow dump
+func kernelActions(actions func(*NlMsgBuilder)) ([]Action, error) {
+	msg := NewNlMsgBuilder(RequestFlags, 0)
+	msg.PutNestedAttrs(OVS_FLOW_ATTR_KEY, func() {})
+	msg.PutNestedAttrs(OVS_FLOW_ATTR_ACTIONS, func() { actions(msg) })
+
+	attrs, err := ParseNestedAttrs(msg.buf[syscall.NLMSG_HDRLEN:])
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := parseFlowSpec(attrs)
+	return f.Actions, err
+}
+
+func TestIPv4FlowKeyRoundTrip(t *testing.T) {
+	f := NewFlowSpec()
+	f.AddKey(NewEthernetFlowKey())
+	f.AddKey(NewEthertypeFlowKey(0x0800))
+	k := NewIPv4FlowKey()
+	k.SetIpv4SrcPrefix([4]byte{10, 1, 2, 3}, 20)
+	k.SetIpv4Dst([4]byte{10, 0, 0, 1})
+	k.SetProto(6)
+	k.SetMaskedTos(0x10, 0xfc)
+	k.SetFrag(OVS_FRAG_TYPE_NONE)
+	f.AddKey(k)
+	f.AddAction(NewOutputAction(3))
+
+	g := flowRoundTrip(t, f)
+	gk := g.FlowKeys[OVS_KEY_ATTR_IPV4].(IPv4FlowKey)
+	if gk.Key().Ipv4Src != [4]byte{10, 1, 0, 0} ||
+		gk.Mask().Ipv4Src != [4]byte{255, 255, 240, 0} {
+		t.Fatal(gk)
+	}
+}
+
+func TestParseKernelIPv4FlowKey(t *testing.T) {
+	keys := func(msg *NlMsgBuilder) {
+		msg.PutSliceAttr(OVS_KEY_ATTR_ETHERTYPE, []byte{0x08, 0x00})
+		msg.PutSliceAttr(OVS_KEY_ATTR_IPV4, []byte{
+			192, 168, 1, 0, // src
+			10, 0, 0, 1, // dst
+			17, 0, 64, OVS_FRAG_TYPE_NONE, // proto, tos, ttl, frag
+		})
+	}
+
+	fks, err := kernelFlowKeys(keys, func(msg *NlMsgBuilder) {
+		msg.PutSliceAttr(OVS_KEY_ATTR_ETHERTYPE, []byte{0xff, 0xff})
+		msg.PutSliceAttr(OVS_KEY_ATTR_IPV4, []byte{
+			255, 255, 255, 0,
+			255, 255, 255, 255,
+			0xff, 0, 0, 0xff,
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if et := fks[OVS_KEY_ATTR_ETHERTYPE].(EthertypeFlowKey); et.Ethertype() != 0x0800 || et.Mask() != 0xffff {
+		t.Fatal(et)
+	}
+
+	k := fks[OVS_KEY_ATTR_IPV4].(IPv4FlowKey)
+	if k.Key().Ipv4Src != [4]byte{192, 168, 1, 0} ||
+		k.Mask().Ipv4Src != [4]byte{255, 255, 255, 0} ||
+		k.Key().Ipv4Proto != 17 || k.Mask().Ipv4Ttl != 0 {
+		t.Fatal(k)
+	}
+
+	// Without a mask attribute, every field is an exact match
+	fks, err = kernelFlowKeys(keys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k = fks[OVS_KEY_ATTR_IPV4].(IPv4FlowKey)
+	if k.Key().Ipv4Ttl != 64 || k.Mask().Ipv4Ttl != 0xff {
+		t.Fatal(k)
+	}
+}
+
+func TestIPv6FlowKeyRoundTrip(t *testing.T) {
+	f := NewFlowSpec()
+	f.AddKey(NewEthernetFlowKey())
+	f.AddKey(NewEthertypeFlowKey(0x86dd))
+	k := NewIPv6FlowKey()
+	k.SetIpv6SrcPrefix(netip.MustParsePrefix("2001:db8::1/62"))
+	k.SetIpv6Dst(netip.MustParseAddr("fe80::1"))
+	k.SetMaskedLabel(0x12345, 0xff00f)
+	k.SetHlimit(64)
+	f.AddKey(k)
+
+	g := flowRoundTrip(t, f)
+	gk := g.FlowKeys[OVS_KEY_ATTR_IPV6].(IPv6FlowKey)
+	if gk.Src() != netip.MustParseAddr("2001:db8::") ||
+		gk.Label() != 0x12345 || gk.LabelMask() != 0xff00f {
+		t.Fatal(gk)
+	}
+}
+
+func TestIPv6FlowKeyBadPrefix(t *testing.T) {
+	bad := []netip.Prefix{
+		{},
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.PrefixFrom(netip.MustParseAddr("2001:db8::"), 129),
+	}
+
+	expectPanic := func(set func(*IPv6FlowKey, netip.Prefix), p netip.Prefix) {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expected panic for prefix %s", p)
+			}
+		}()
+
+		k := NewIPv6FlowKey()
+		set(&k, p)
+	}
+
+	for _, p := range bad {
+		expectPanic((*IPv6FlowKey).SetIpv6SrcPrefix, p)
+		expectPanic((*IPv6FlowKey).SetIpv6DstPrefix, p)
+	}
+}
+
+func TestParseKernelIPv6FlowKey(t *testing.T) {
+	key := make([]byte, SizeofOvsKeyIpv6)
+	copy(key[0:], netip.MustParseAddr("fd00::1").AsSlice())
+	copy(key[16:], netip.MustParseAddr("fd00::2").AsSlice())
+	// The flow label is big-endian, in the low 20 bits
+	copy(key[32:], []byte{0x00, 0x0a, 0xbc, 0xde})
+	key[36] = 58  // proto
+	key[38] = 255 // hlimit
+
+	mask := make([]byte, SizeofOvsKeyIpv6)
+	copy(mask[0:], bytes.Repeat([]byte{0xff}, 8))
+	copy(mask[32:], []byte{0x00, 0x0f, 0xff, 0xff, 0xff})
+
+	fks, err := kernelFlowKeys(func(msg *NlMsgBuilder) {
+		msg.PutSliceAttr(OVS_KEY_ATTR_ETHERTYPE, []byte{0x86, 0xdd})
+		msg.PutSliceAttr(OVS_KEY_ATTR_IPV6, key)
+	}, func(msg *NlMsgBuilder) {
+		msg.PutSliceAttr(OVS_KEY_ATTR_ETHERTYPE, []byte{0xff, 0xff})
+		msg.PutSliceAttr(OVS_KEY_ATTR_IPV6, mask)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := fks[OVS_KEY_ATTR_IPV6].(IPv6FlowKey)
+	if k.Src() != netip.MustParseAddr("fd00::1") ||
+		k.Dst() != netip.MustParseAddr("fd00::2") ||
+		k.DstMask() != [16]byte{} ||
+		k.Label() != 0xabcde || k.LabelMask() != 0xfffff ||
+		k.Key().Ipv6Proto != 58 || k.Mask().Ipv6Hlimit != 0 {
+		t.Fatal(k)
+	}
+}
+
+func TestL4FlowKeysRoundTrip(t *testing.T) {
+	f := NewFlowSpec()
+	f.AddKey(NewEthernetFlowKey())
+	k := NewTcpFlowKey()
+	k.SetDst(80)
+	k.SetMaskedSrc(1024, 0xfc00)
+	f.AddKey(k)
+	fl := NewTcpFlagsFlowKey()
+	fl.SetMaskedFlags(TCP_FLAG_SYN, TCP_FLAG_SYN|TCP_FLAG_ACK)
+	f.AddKey(fl)
+
+	g := flowRoundTrip(t, f)
+	gk := g.FlowKeys[OVS_KEY_ATTR_TCP].(TcpFlowKey)
+	if gk.Dst() != 80 || gk.Src() != 1024 || gk.SrcMask() != 0xfc00 {
+		t.Fatal(gk)
+	}
+
+	gfl := g.FlowKeys[OVS_KEY_ATTR_TCP_FLAGS].(TcpFlagsFlowKey)
+	if gfl.Flags() != TCP_FLAG_SYN || gfl.Mask() != TCP_FLAG_SYN|TCP_FLAG_ACK {
+		t.Fatal(gfl)
+	}
+
+	f = NewFlowSpec()
+	f.AddKey(NewEthernetFlowKey())
+	u := NewUdpFlowKey()
+	u.SetSrc(53)
+	f.AddKey(u)
+	flowRoundTrip(t, f)
+
+	f = NewFlowSpec()
+	f.AddKey(NewEthernetFlowKey())
+	sk := NewSctpFlowKey()
+	sk.SetMaskedDst(0x1000, 0xf000)
+	f.AddKey(sk)
+	flowRoundTrip(t, f)
+}
+
+// The masks should cover exactly the ports in the range, without
+// overlapping
+func TestPortRangeMasks(t *testing.T) {
+	masks := PortRangeMasks(1000, 1999)
+	for p := 0; p < 0x10000; p++ {
+		matches := 0
+		for _, m := range masks {
+			if uint16(p)&m.Mask == m.Port {
+				matches++
+			}
+		}
+
+		expected := 0
+		if p >= 1000 && p <= 1999 {
+			expected = 1
+		}
+
+		if matches != expected {
+			t.Fatalf("port %d matched %d times by %v", p, matches, masks)
+		}
+	}
+
+	if m := PortRangeMasks(0, 0xffff); len(m) != 1 || m[0].Mask != 0 {
+		t.Fatal(m)
+	}
+}
+
+func TestParseKernelL4FlowKeys(t *testing.T) {
+	// Ports and flags are big-endian
+	fks, err := kernelFlowKeys(func(msg *NlMsgBuilder) {
+		msg.PutSliceAttr(OVS_KEY_ATTR_TCP, []byte{0x04, 0x00, 0x00, 0x50})
+		msg.PutSliceAttr(OVS_KEY_ATTR_TCP_FLAGS, []byte{0x00, 0x02})
+		msg.PutSliceAttr(OVS_KEY_ATTR_UDP, []byte{0x00, 0x35, 0x00, 0x35})
+	}, func(msg *NlMsgBuilder) {
+		msg.PutSliceAttr(OVS_KEY_ATTR_TCP, []byte{0xfc, 0x00, 0xff, 0xff})
+		msg.PutSliceAttr(OVS_KEY_ATTR_TCP_FLAGS, []byte{0x00, 0x12})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := fks[OVS_KEY_ATTR_TCP].(TcpFlowKey)
+	if k.Src() != 1024 || k.SrcMask() != 0xfc00 || k.Dst() != 80 || k.DstMask() != 0xffff {
+		t.Fatal(k)
+	}
+
+	fl := fks[OVS_KEY_ATTR_TCP_FLAGS].(TcpFlagsFlowKey)
+	if fl.Flags() != TCP_FLAG_SYN || fl.Mask() != TCP_FLAG_SYN|TCP_FLAG_ACK {
+		t.Fatal(fl)
+	}
+
+	// A key without a mask attribute in a masked dump is
+	// wildcarded
+	if u := fks[OVS_KEY_ATTR_UDP]; !u.Ignored() {
+		t.Fatal(u)
+	}
+
+	if m := PortRangeMasks(7, 7); len(m) != 1 || m[0] != (PortMask{7, 0xffff}) {
+		t.Fatal(m)
+	}
+}
+
+func TestIcmpArpNdFlowKeysRoundTrip(t *testing.T) {
+	f := NewFlowSpec()
+	f.AddKey(NewEthernetFlowKey())
+	ic := NewIcmpFlowKey()
+	ic.SetType(8)
+	ic.SetMaskedCode(0, 0xf0)
+	f.AddKey(ic)
+	g := flowRoundTrip(t, f)
+	if gk := g.FlowKeys[OVS_KEY_ATTR_ICMP].(IcmpFlowKey); gk.Key().IcmpType != 8 {
+		t.Fatal(gk)
+	}
+
+	f = NewFlowSpec()
+	f.AddKey(NewEthernetFlowKey())
+	arp := NewArpFlowKey()
+	arp.SetOp(1)
+	arp.SetMaskedSip([4]byte{10, 0, 0, 0}, [4]byte{255, 0, 0, 0})
+	arp.SetTha([ETH_ALEN]byte{1, 2, 3, 4, 5, 6})
+	f.AddKey(arp)
+	g = flowRoundTrip(t, f)
+	if gk := g.FlowKeys[OVS_KEY_ATTR_ARP].(ArpFlowKey); gk.Op() != 1 {
+		t.Fatal(gk)
+	}
+
+	f = NewFlowSpec()
+	f.AddKey(NewEthernetFlowKey())
+	ic6 := NewIcmpv6FlowKey()
+	ic6.SetType(135)
+	f.AddKey(ic6)
+	nd := NewNdFlowKey()
+	nd.SetTarget(netip.MustParseAddr("fe80::2"))
+	nd.SetSll([ETH_ALEN]byte{1, 2, 3, 4, 5, 6})
+	f.AddKey(nd)
+	g = flowRoundTrip(t, f)
+	if gk := g.FlowKeys[OVS_KEY_ATTR_ND].(NdFlowKey); gk.Target() != netip.MustParseAddr("fe80::2") {
+		t.Fatal(gk)
+	}
+}
+
+func TestParseKernelArpNdFlowKeys(t *testing.T) {
+	nd := make([]byte, SizeofOvsKeyNd)
+	copy(nd, netip.MustParseAddr("fe80::2").AsSlice())
+	copy(nd[16:], []byte{1, 2, 3, 4, 5, 6})
+
+	fks, err := kernelFlowKeys(func(msg *NlMsgBuilder) {
+		msg.PutSliceAttr(OVS_KEY_ATTR_ARP, []byte{
+			10, 0, 0, 1, // sip
+			10, 0, 0, 2, // tip
+			0x00, 0x02, // op
+			1, 2, 3, 4, 5, 6, // sha
+			0, 0, 0, 0, 0, 0, // tha
+			0, 0, // padding
+		})
+		msg.PutSliceAttr(OVS_KEY_ATTR_ICMPV6, []byte{136, 0})
+		msg.PutSliceAttr(OVS_KEY_ATTR_ND, nd)
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	arp := fks[OVS_KEY_ATTR_ARP].(ArpFlowKey)
+	if arp.Op() != 2 || arp.OpMask() != 0xffff ||
+		arp.Key().ArpTip != [4]byte{10, 0, 0, 2} ||
+		arp.Key().ArpSha != [ETH_ALEN]byte{1, 2, 3, 4, 5, 6} {
+		t.Fatal(arp)
+	}
+
+	if ic := fks[OVS_KEY_ATTR_ICMPV6].(Icmpv6FlowKey); ic.Key().IcmpType != 136 {
+		t.Fatal(ic)
+	}
+
+	if k := fks[OVS_KEY_ATTR_ND].(NdFlowKey); k.Target() != netip.MustParseAddr("fe80::2") ||
+		k.Key().NdSll != [ETH_ALEN]byte{1, 2, 3, 4, 5, 6} {
+		t.Fatal(k)
+	}
+
+	// struct ovs_key_arp includes trailing padding
+	_, err = kernelFlowKeys(func(msg *NlMsgBuilder) {
+		msg.PutSliceAttr(OVS_KEY_ATTR_ARP, make([]byte, SizeofOvsKeyArp-2))
+	}, nil)
+	if err == nil {
+		t.Fatal("expected error for short ARP flow key")
+	}
+}
+
+func TestQinQFlowKeysRoundTrip(t *testing.T) {
+	f := NewFlowSpec()
+	f.AddKey(NewEthernetFlowKey())
+	f.AddKey(NewEthertypeFlowKey(0x0800))
+	k := NewIPv4FlowKey()
+	k.SetProto(6)
+	f.AddKey(k)
+	inner := NewVlanFlowKey()
+	inner.SetVid(100)
+	f.AddVlan(ETH_P_8021Q, inner)
+	outer := NewVlanFlowKey()
+	outer.SetVid(7)
+	outer.SetPcp(5)
+	f.AddVlan(ETH_P_8021AD, outer)
+
+	g := flowRoundTrip(t, f)
+	if v := g.FlowKeys[OVS_KEY_ATTR_VLAN].(VlanFlowKey); v.Vid() != 7 || v.Pcp() != 5 {
+		t.Fatal(v)
+	}
+
+	enc := g.FlowKeys[OVS_KEY_ATTR_ENCAP].(EncapFlowKey).Keys()
+	if v := enc[OVS_KEY_ATTR_VLAN].(VlanFlowKey); v.Vid() != 100 || v.VidMask() != 0xfff {
+		t.Fatal(v)
+	}
+
+	enc = enc[OVS_KEY_ATTR_ENCAP].(EncapFlowKey).Keys()
+	if _, ok := enc[OVS_KEY_ATTR_IPV4]; !ok {
+		t.Fatal(enc)
+	}
+}
+
+func TestParseKernelVlanFlowKeys(t *testing.T) {
+	// A packet tagged with VID 100.  The TCI includes the CFI
+	// bit, meaning a tag is present.
+	fks, err := kernelFlowKeys(func(msg *NlMsgBuilder) {
+		msg.PutSliceAttr(OVS_KEY_ATTR_ETHERTYPE, []byte{0x81, 0x00})
+		msg.PutSliceAttr(OVS_KEY_ATTR_VLAN, []byte{0x10, 0x64})
+		msg.PutNestedAttrs(OVS_KEY_ATTR_ENCAP, func() {
+			msg.PutSliceAttr(OVS_KEY_ATTR_ETHERTYPE, []byte{0x08, 0x00})
+		})
+	}, func(msg *NlMsgBuilder) {
+		msg.PutSliceAttr(OVS_KEY_ATTR_ETHERTYPE, []byte{0xff, 0xff})
+		msg.PutSliceAttr(OVS_KEY_ATTR_VLAN, []byte{0x1f, 0xff})
+		msg.PutNestedAttrs(OVS_KEY_ATTR_ENCAP, func() {
+			msg.PutSliceAttr(OVS_KEY_ATTR_ETHERTYPE, []byte{0xff, 0xff})
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := fks[OVS_KEY_ATTR_VLAN].(VlanFlowKey); v.Vid() != 100 || v.VidMask() != 0xfff || v.PcpMask() != 0 {
+		t.Fatal(v)
+	}
+
+	enc := fks[OVS_KEY_ATTR_ENCAP].(EncapFlowKey).Keys()
+	if et := enc[OVS_KEY_ATTR_ETHERTYPE].(EthertypeFlowKey); et.Ethertype() != 0x0800 {
+		t.Fatal(et)
+	}
+
+	// Any tagged packet: only the CFI bit is matched, and the
+	// encap key is empty
+	fks, err = kernelFlowKeys(func(msg *NlMsgBuilder) {
+		msg.PutSliceAttr(OVS_KEY_ATTR_ETHERTYPE, []byte{0x81, 0x00})
+		msg.PutSliceAttr(OVS_KEY_ATTR_VLAN, []byte{0x10, 0x00})
+		msg.PutNestedAttrs(OVS_KEY_ATTR_ENCAP, func() {})
+	}, func(msg *NlMsgBuilder) {
+		msg.PutSliceAttr(OVS_KEY_ATTR_ETHERTYPE, []byte{0xff, 0xff})
+		msg.PutSliceAttr(OVS_KEY_ATTR_VLAN, []byte{0x10, 0x00})
+		msg.PutNestedAttrs(OVS_KEY_ATTR_ENCAP, func() {})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := fks[OVS_KEY_ATTR_VLAN].(VlanFlowKey); !v.Equals(NewVlanFlowKey()) || v.VidMask() != 0 {
+		t.Fatal(v)
+	}
+
+	if enc := fks[OVS_KEY_ATTR_ENCAP].(EncapFlowKey).Keys(); len(enc) != 0 {
+		t.Fatal(enc)
+	}
+}
+
+func TestVlanActionsRoundTrip(t *testing.T) {
+	f := NewFlowSpec()
+	f.AddKey(NewEthernetFlowKey())
+	f.AddAction(NewPopVlanAction())
+	f.AddAction(NewPushVlanAction(ETH_P_8021AD, 10, 3))
+	f.AddAction(NewOutputAction(2))
+
+	g := flowRoundTrip(t, f)
+	if a := g.Actions[1].(PushVlanAction); a.Tpid != ETH_P_8021AD || a.Vid != 10 || a.Pcp != 3 {
+		t.Fatal(a)
+	}
+}
+
+func TestParseKernelVlanActions(t *testing.T) {
+	// The pushed TCI has PCP 3, the CFI bit, and VID 10
+	actions, err := kernelActions(func(msg *NlMsgBuilder) {
+		msg.PutEmptyAttr(OVS_ACTION_ATTR_POP_VLAN)
+		msg.PutSliceAttr(OVS_ACTION_ATTR_PUSH_VLAN, []byte{0x88, 0xa8, 0x70, 0x0a})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(actions) != 2 || !actions[0].Equals(NewPopVlanAction()) ||
+		!actions[1].Equals(NewPushVlanAction(ETH_P_8021AD, 10, 3)) {
+		t.Fatal(actions)
+	}
+
+	_, err = kernelActions(func(msg *NlMsgBuilder) {
+		msg.PutSliceAttr(OVS_ACTION_ATTR_PUSH_VLAN, []byte{0x81, 0x00})
+	})
+	if err == nil {
+		t.Fatal("expected error for short push VLAN action")
+	}
+}
+
+func TestCtFlowKeysRoundTrip(t *testing.T) {
+	f := NewFlowSpec()
+	f.AddKey(NewEthernetFlowKey())
+	st := NewCtStateFlowKey()
+	st.SetMaskedState(OVS_CS_F_TRACKED|OVS_CS_F_NEW,
+		OVS_CS_F_TRACKED|OVS_CS_F_NEW|OVS_CS_F_INVALID)
+	f.AddKey(st)
+	z := NewCtZoneFlowKey()
+	z.SetZone(5)
+	f.AddKey(z)
+	m := NewCtMarkFlowKey()
+	m.SetMaskedMark(0x100, 0xff00)
+	f.AddKey(m)
+	l := NewCtLabelsFlowKey()
+	l.SetLabels([OVS_CT_LABELS_LEN]byte{1, 2, 3})
+	f.AddKey(l)
+	o4 := NewCtOrigTupleIPv4FlowKey()
+	o4.SetIpv4Dst([4]byte{10, 0, 0, 1})
+	o4.SetDstPort(80)
+	o4.SetProto(6)
+	f.AddKey(o4)
+	o6 := NewCtOrigTupleIPv6FlowKey()
+	o6.SetIpv6Src(netip.MustParseAddr("fd00::1"))
+	f.AddKey(o6)
+
+	g := flowRoundTrip(t, f)
+	if gk := g.FlowKeys[OVS_KEY_ATTR_CT_ORIG_TUPLE_IPV4].(CtOrigTupleIPv4FlowKey); gk.DstPort() != 80 {
+		t.Fatal(gk)
+	}
+}
+
+func TestParseKernelCtFlowKeys(t *testing.T) {
+	fks, err := kernelFlowKeys(func(msg *NlMsgBuilder) {
+		msg.PutUint32Attr(OVS_KEY_ATTR_CT_STATE, OVS_CS_F_TRACKED|OVS_CS_F_ESTABLISHED)
+		msg.PutUint16Attr(OVS_KEY_ATTR_CT_ZONE, 5)
+		msg.PutSliceAttr(OVS_KEY_ATTR_CT_ORIG_TUPLE_IPV4, []byte{
+			10, 0, 0, 1, // src
+			10, 0, 0, 2, // dst
+			0x30, 0x39, // src port
+			0x00, 0x50, // dst port
+			6, 0, 0, 0, // proto and padding
+		})
+	}, func(msg *NlMsgBuilder) {
+		msg.PutUint32Attr(OVS_KEY_ATTR_CT_STATE,
+			OVS_CS_F_TRACKED|OVS_CS_F_ESTABLISHED|OVS_CS_F_INVALID)
+		msg.PutUint16Attr(OVS_KEY_ATTR_CT_ZONE, 0xffff)
+		msg.PutSliceAttr(OVS_KEY_ATTR_CT_ORIG_TUPLE_IPV4, []byte{
+			0, 0, 0, 0,
+			255, 255, 255, 255,
+			0, 0,
+			0xff, 0xff,
+			0xff, 0, 0, 0,
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := fks[OVS_KEY_ATTR_CT_STATE].(CtStateFlowKey)
+	if st.State() != OVS_CS_F_TRACKED|OVS_CS_F_ESTABLISHED ||
+		st.Mask() != OVS_CS_F_TRACKED|OVS_CS_F_ESTABLISHED|OVS_CS_F_INVALID {
+		t.Fatal(st)
+	}
+
+	if z := fks[OVS_KEY_ATTR_CT_ZONE].(CtZoneFlowKey); z.Zone() != 5 || z.Mask() != 0xffff {
+		t.Fatal(z)
+	}
+
+	o4 := fks[OVS_KEY_ATTR_CT_ORIG_TUPLE_IPV4].(CtOrigTupleIPv4FlowKey)
+	if o4.Key().Ipv4Dst != [4]byte{10, 0, 0, 2} || o4.DstPort() != 80 ||
+		o4.SrcPort() != 12345 || o4.SrcPortMask() != 0 {
+		t.Fatal(o4)
+	}
+}
+
+func TestCtActionRoundTrip(t *testing.T) {
+	f := NewFlowSpec()
+	f.AddKey(NewEthernetFlowKey())
+	f.AddAction(CtAction{
+		Commit:       true,
+		Zone:         5,
+		Mark:         1,
+		MarkMask:     0xff,
+		Helper:       "ftp",
+		EventMask:    0x5,
+		HasEventMask: true,
+		Timeout:      "tp1",
+		Nat: &CtNat{
+			Src:         true,
+			IPMin:       netip.MustParseAddr("10.0.0.1"),
+			IPMax:       netip.MustParseAddr("10.0.0.9"),
+			ProtoMin:    1000,
+			ProtoMax:    2000,
+			ProtoRandom: true,
+		},
+	})
+	f.AddAction(CtAction{Commit: true, Nat: &CtNat{Dst: true, ProtoMin: 1024, ProtoMax: 2047}})
+	// Single-valued ranges are encoded with only the minimum
+	f.AddAction(CtAction{Commit: true, Nat: &CtNat{
+		Dst:      true,
+		IPMin:    netip.MustParseAddr("fd00::1"),
+		IPMax:    netip.MustParseAddr("fd00::1"),
+		ProtoMin: 80,
+		ProtoMax: 80,
+	}})
+	f.AddAction(CtAction{Nat: &CtNat{}})
+	f.AddAction(CtAction{Labels: [OVS_CT_LABELS_LEN]byte{7}, LabelsMask: [OVS_CT_LABELS_LEN]byte{0xff}})
+
+	g := flowRoundTrip(t, f)
+	if a := g.Actions[0].(CtAction); !a.HasEventMask || a.EventMask != 0x5 || a.Timeout != "tp1" {
+		t.Fatal(a)
+	}
+}
+
+func TestParseKernelCtAction(t *testing.T) {
+	// The mark is a value and mask, in host byte order
+	mark := MakeAlignedByteSlice(8)
+	*uint32At(mark, 0) = 1
+	*uint32At(mark, 4) = 0xff
+
+	actions, err := kernelActions(func(msg *NlMsgBuilder) {
+		msg.PutNestedAttrs(OVS_ACTION_ATTR_CT, func() {
+			msg.PutEmptyAttr(OVS_CT_ATTR_COMMIT)
+			msg.PutUint16Attr(OVS_CT_ATTR_ZONE, 5)
+			msg.PutSliceAttr(OVS_CT_ATTR_MARK, mark)
+			msg.PutNestedAttrs(OVS_CT_ATTR_NAT, func() {
+				msg.PutEmptyAttr(OVS_NAT_ATTR_SRC)
+				msg.PutSliceAttr(OVS_NAT_ATTR_IP_MIN, []byte{10, 0, 0, 1})
+				msg.PutSliceAttr(OVS_NAT_ATTR_IP_MAX, []byte{10, 0, 0, 9})
+				msg.PutUint16Attr(OVS_NAT_ATTR_PROTO_MIN, 1000)
+				msg.PutUint16Attr(OVS_NAT_ATTR_PROTO_MAX, 2000)
+			})
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := CtAction{
+		Commit:   true,
+		Zone:     5,
+		Mark:     1,
+		MarkMask: 0xff,
+		Nat: &CtNat{
+			Src:      true,
+			IPMin:    netip.MustParseAddr("10.0.0.1"),
+			IPMax:    netip.MustParseAddr("10.0.0.9"),
+			ProtoMin: 1000,
+			ProtoMax: 2000,
+		},
+	}
+	if len(actions) != 1 || !actions[0].Equals(expected) {
+		t.Fatal(actions)
+	}
+
+	// The kernel omits the maximum of a single-valued range
+	actions, err = kernelActions(func(msg *NlMsgBuilder) {
+		msg.PutNestedAttrs(OVS_ACTION_ATTR_CT, func() {
+			msg.PutNestedAttrs(OVS_CT_ATTR_NAT, func() {
+				msg.PutEmptyAttr(OVS_NAT_ATTR_SRC)
+				msg.PutSliceAttr(OVS_NAT_ATTR_IP_MIN, []byte{10, 0, 0, 1})
+				msg.PutUint16Attr(OVS_NAT_ATTR_PROTO_MIN, 1000)
+			})
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected = CtAction{
+		Nat: &CtNat{
+			Src:      true,
+			IPMin:    netip.MustParseAddr("10.0.0.1"),
+			IPMax:    netip.MustParseAddr("10.0.0.1"),
+			ProtoMin: 1000,
+			ProtoMax: 1000,
+		},
+	}
+	if len(actions) != 1 || !actions[0].Equals(expected) || !expected.Equals(actions[0]) {
+		t.Fatal(actions)
+	}
+
+	// But a different range is not equal
+	expected.Nat.ProtoMax = 2000
+	if actions[0].Equals(expected) {
+		t.Fatal(actions)
+	}
+}
+
+func TestTunnelIPv6RoundTrip(t *testing.T) {
+	f := NewFlowSpec()
+	f.AddKey(NewEthernetFlowKey())
+	var tk TunnelFlowKey
+	tk.SetIpv6Src(netip.MustParseAddr("fd00::1").As16())
+	tk.SetTunnelId([8]byte{0, 0, 0, 0, 0, 0, 0, 5})
+	f.AddKey(tk)
+	var a SetTunnelAction
+	a.SetIpv6Dst(netip.MustParseAddr("fd00::2").As16())
+	a.SetTtl(64)
+	f.AddAction(a)
+
+	g := flowRoundTrip(t, f)
+	if k := g.FlowKeys[OVS_KEY_ATTR_TUNNEL].(TunnelFlowKey).Key(); k.Ipv6Src != netip.MustParseAddr("fd00::1").As16() {
+		t.Fatal(k)
+	}
+}
+
+func TestParseKernelTunnelIPv6(t *testing.T) {
+	src := netip.MustParseAddr("fd00::1").As16()
+	dst := netip.MustParseAddr("fd00::2").As16()
+
+	// Fields whose mask attribute is missing are wildcarded
+	fks, err := kernelFlowKeys(func(msg *NlMsgBuilder) {
+		msg.PutNestedAttrs(OVS_KEY_ATTR_TUNNEL, func() {
+			msg.PutSliceAttr(OVS_TUNNEL_KEY_ATTR_IPV6_SRC, src[:])
+			msg.PutSliceAttr(OVS_TUNNEL_KEY_ATTR_IPV6_DST, dst[:])
+			msg.PutUint8Attr(OVS_TUNNEL_KEY_ATTR_TTL, 64)
+			msg.PutSliceAttr(OVS_TUNNEL_KEY_ATTR_TP_DST, []byte{0x12, 0xb5})
+		})
+	}, func(msg *NlMsgBuilder) {
+		msg.PutNestedAttrs(OVS_KEY_ATTR_TUNNEL, func() {
+			msg.PutSliceAttr(OVS_TUNNEL_KEY_ATTR_IPV6_SRC, bytes.Repeat([]byte{0xff}, 16))
+			msg.PutSliceAttr(OVS_TUNNEL_KEY_ATTR_TP_DST, []byte{0xff, 0xff})
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tk := fks[OVS_KEY_ATTR_TUNNEL].(TunnelFlowKey)
+	if tk.Key().Ipv6Src != src || tk.Key().TpDst != 4789 ||
+		tk.Mask().TpDst != 0xffff || tk.Mask().Ipv6Dst != [16]byte{} ||
+		tk.Mask().Ttl != 0 {
+		t.Fatal(tk)
+	}
+
+	actions, err := kernelActions(func(msg *NlMsgBuilder) {
+		msg.PutNestedAttrs(OVS_ACTION_ATTR_SET, func() {
+			msg.PutNestedAttrs(OVS_KEY_ATTR_TUNNEL, func() {
+				msg.PutSliceAttr(OVS_TUNNEL_KEY_ATTR_IPV6_DST, dst[:])
+			})
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expected SetTunnelAction
+	expected.SetIpv6Dst(dst)
+	if len(actions) != 1 || !actions[0].Equals(expected) {
+		t.Fatal(actions)
+	}
+}
+
+func TestGeneveOptionsRoundTrip(t *testing.T) {
+	f := NewFlowSpec()
+	f.AddKey(NewEthernetFlowKey())
+	var tk TunnelFlowKey
+	tk.SetIpv4Dst([4]byte{10, 0, 0, 1})
+	tk.SetMaskedGeneveOptions(
+		[]GeneveOption{
+			{Class: 0x102, Type: 0x80, Data: []byte{1, 2, 3, 4}},
+			{Class: 5, Type: 6, Data: []byte{9, 9, 9, 9, 8, 8, 8, 8}},
+		},
+		[]GeneveOption{
+			{Class: 0xffff, Type: 0xff, Data: []byte{0xff, 0, 0xff, 0}},
+			{Class: 0xffff, Type: 0, Data: make([]byte, 8)},
+		})
+	f.AddKey(tk)
+	var a SetTunnelAction
+	a.SetIpv4Dst([4]byte{10, 0, 0, 2})
+	a.SetGeneveOptions([]GeneveOption{{Class: 1, Type: 2, Data: []byte{1, 2, 3, 4}}})
+	f.AddAction(a)
+
+	g := flowRoundTrip(t, f)
+	gk := g.FlowKeys[OVS_KEY_ATTR_TUNNEL].(TunnelFlowKey)
+	if len(gk.Key().GeneveOpts) != 2 || len(gk.Mask().GeneveOpts) != 2 {
+		t.Fatal(gk)
+	}
+
+	// A mask must cover its option's data
+	var bad TunnelFlowKey
+	bad.SetMaskedGeneveOptions([]GeneveOption{{Data: []byte{1, 2, 3, 4}}},
+		[]GeneveOption{{}})
+	f = NewFlowSpec()
+	f.AddKey(bad)
+	if err := f.toNlAttrs(NewNlMsgBuilder(RequestFlags, 0)); err == nil {
+		t.Fatal("expected error for mismatched Geneve mask")
+	}
+}
+
+func TestParseKernelGeneveOptions(t *testing.T) {
+	// Each option has a class, type and length in 4 byte units.
+	// In the mask, the length field is all ones.
+	fks, err := kernelFlowKeys(func(msg *NlMsgBuilder) {
+		msg.PutNestedAttrs(OVS_KEY_ATTR_TUNNEL, func() {
+			msg.PutSliceAttr(OVS_TUNNEL_KEY_ATTR_GENEVE_OPTS, []byte{
+				0x01, 0x02, 0x80, 1, 1, 2, 3, 4,
+				0xff, 0xff, 0x01, 2, 5, 6, 7, 8, 9, 10, 11, 12,
+			})
+		})
+	}, func(msg *NlMsgBuilder) {
+		msg.PutNestedAttrs(OVS_KEY_ATTR_TUNNEL, func() {
+			msg.PutSliceAttr(OVS_TUNNEL_KEY_ATTR_GENEVE_OPTS, []byte{
+				0xff, 0xff, 0xff, 0x1f, 0xff, 0xff, 0, 0,
+				0xff, 0xff, 0xff, 0x1f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+			})
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tk := fks[OVS_KEY_ATTR_TUNNEL].(TunnelFlowKey)
+	k := tk.Key().GeneveOpts
+	m := tk.Mask().GeneveOpts
+	if len(k) != 2 || len(m) != 2 ||
+		!k[0].Equals(GeneveOption{Class: 0x102, Type: 0x80, Data: []byte{1, 2, 3, 4}}) ||
+		!k[1].Equals(GeneveOption{Class: 0xffff, Type: 1, Data: []byte{5, 6, 7, 8, 9, 10, 11, 12}}) ||
+		!bytes.Equal(m[0].Data, []byte{0xff, 0xff, 0, 0}) || len(m[1].Data) != 8 {
+		t.Fatal(tk)
+	}
+
+	// Option data is padded to a multiple of 4 bytes
+	var a SetTunnelAction
+	a.SetGeneveOptions([]GeneveOption{{Class: 1, Type: 2, Data: []byte{1, 2, 3}}})
+	actions, err := kernelActions(a.toNlAttr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := actions[0].(SetTunnelAction).GeneveOpts
+	if len(opts) != 1 || !bytes.Equal(opts[0].Data, []byte{1, 2, 3, 0}) || !opts[0].Equals(a.GeneveOpts[0]) {
+		t.Fatal(opts)
+	}
+
+	// The length field says there is more data than present
+	_, err = kernelFlowKeys(func(msg *NlMsgBuilder) {
+		msg.PutNestedAttrs(OVS_KEY_ATTR_TUNNEL, func() {
+			msg.PutSliceAttr(OVS_TUNNEL_KEY_ATTR_GENEVE_OPTS, []byte{
+				0x01, 0x02, 0x80, 2, 1, 2, 3, 4,
+			})
+		})
+	}, nil)
+	if err == nil {
+		t.Fatal("expected error for truncated Geneve option")
+	}
+}
+
+func TestMplsRoundTrip(t *testing.T) {
+	f := NewFlowSpec()
+	f.AddKey(NewEthernetFlowKey())
+	f.AddKey(NewEthertypeFlowKey(ETH_P_MPLS_UC))
+	k := NewMplsFlowKey(2)
+	k.SetLabel(0, 1000)
+	k.SetMaskedTC(0, 5, 4)
+	k.SetBOS(1, true)
+	k.SetTTL(1, 64)
+	f.AddKey(k)
+	f.AddAction(NewPopMplsAction(ETH_P_MPLS_UC))
+	f.AddAction(NewPushMplsAction(ETH_P_MPLS_UC, 0xfffff, 7, true, 255))
+
+	g := flowRoundTrip(t, f)
+	gk := g.FlowKeys[OVS_KEY_ATTR_MPLS].(MplsFlowKey)
+	if gk.Depth() != 2 || gk.Label(0) != 1000 || gk.TCMask(0) != 4 ||
+		!gk.BOS(1) || gk.TTL(1) != 64 {
+		t.Fatal(gk)
+	}
+
+	pa := g.Actions[1].(PushMplsAction)
+	if pa.Label != 0xfffff || pa.TC != 7 || !pa.BOS || pa.TTL != 255 {
+		t.Fatal(pa)
+	}
+
+	// MPLS inside a VLAN tag belongs in the encap key
+	vlan := NewVlanFlowKey()
+	vlan.SetVid(10)
+	f.AddVlan(ETH_P_8021Q, vlan)
+	g = flowRoundTrip(t, f)
+	enc := g.FlowKeys[OVS_KEY_ATTR_ENCAP].(EncapFlowKey).Keys()
+	if _, ok := enc[OVS_KEY_ATTR_MPLS]; !ok {
+		t.Fatal(g)
+	}
+}
+
+func TestParseKernelMpls(t *testing.T) {
+	// A label stack of three entries, each holding a big-endian
+	// label, TC, BOS bit and TTL
+	lses := []byte{
+		0x00, 0x3e, 0x80, 0x40, // label 1000, ttl 64
+		0x00, 0x07, 0xd0, 0x40, // label 125, ttl 64
+		0x00, 0x00, 0x11, 0xff, // label 1, bos, ttl 255
+	}
+	fks, err := kernelFlowKeys(func(msg *NlMsgBuilder) {
+		msg.PutSliceAttr(OVS_KEY_ATTR_ETHERTYPE, []byte{0x88, 0x47})
+		msg.PutSliceAttr(OVS_KEY_ATTR_MPLS, lses)
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := fks[OVS_KEY_ATTR_MPLS].(MplsFlowKey)
+	if k.Depth() != 3 || k.Label(0) != 1000 || k.Label(1) != 125 ||
+		k.Label(2) != 1 || k.BOS(0) || !k.BOS(2) || k.TTL(2) != 255 ||
+		k.LSEMask(1) != 0xffffffff {
+		t.Fatal(k)
+	}
+
+	// The mask must be a whole number of label stack entries
+	_, err = kernelFlowKeys(func(msg *NlMsgBuilder) {
+		msg.PutSliceAttr(OVS_KEY_ATTR_MPLS, lses[:8])
+	}, func(msg *NlMsgBuilder) {
+		msg.PutSliceAttr(OVS_KEY_ATTR_MPLS, lses[:6])
+	})
+	if err == nil {
+		t.Fatal("expected error for bad MPLS mask length")
+	}
+}
+
+func TestUserspaceActionRoundTrip(t *testing.T) {
+	f := NewFlowSpec()
+	f.AddKey(NewEthernetFlowKey())
+	port := VportID(7)
+	f.AddAction(UserspaceAction{Pid: 123, Userdata: []byte{1, 2, 3}, EgressTunPort: &port})
+	f.AddAction(NewUserspaceAction(5, nil))
+
+	g := flowRoundTrip(t, f)
+	if a := g.Actions[0].(UserspaceAction); a.EgressTunPort == nil || *a.EgressTunPort != 7 {
+		t.Fatal(a)
+	}
+}
+
+func TestParseKernelUserspaceAction(t *testing.T) {
+	// The attribute padding after the userdata is not part of it
+	actions, err := kernelActions(func(msg *NlMsgBuilder) {
+		msg.PutNestedAttrs(OVS_ACTION_ATTR_USERSPACE, func() {
+			msg.PutUint32Attr(OVS_USERSPACE_ATTR_PID, 42)
+			msg.PutSliceAttr(OVS_USERSPACE_ATTR_USERDATA, []byte{1, 2, 3})
+			msg.PutUint32Attr(OVS_USERSPACE_ATTR_EGRESS_TUN_PORT, 7)
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := actions[0].(UserspaceAction)
+	if a.Pid != 42 || !bytes.Equal(a.Userdata, []byte{1, 2, 3}) ||
+		a.EgressTunPort == nil || *a.EgressTunPort != 7 {
+		t.Fatal(a)
+	}
+
+	_, err = kernelActions(func(msg *NlMsgBuilder) {
+		msg.PutNestedAttrs(OVS_ACTION_ATTR_USERSPACE, func() {
+			msg.PutSliceAttr(OVS_USERSPACE_ATTR_USERDATA, []byte{1})
+		})
+	})
+	if err == nil {
+		t.Fatal("expected error for userspace action without pid")
+	}
+}
+
+func TestSampleActionRoundTrip(t *testing.T) {
+	f := NewFlowSpec()
+	f.AddKey(NewEthernetFlowKey())
+	f.AddAction(NewSampleAction(SampleOneIn(100), []Action{
+		NewUserspaceAction(5, []byte{1}),
+		NewSampleAction(SampleProbabilityAll, []Action{NewOutputAction(2)}),
+	}))
+	f.AddAction(NewSampleAction(SampleOneIn(1), nil))
+	f.AddAction(NewOutputAction(1))
+
+	g := flowRoundTrip(t, f)
+	inner := g.Actions[0].(SampleAction).Actions
+	if len(inner) != 2 || inner[1].(SampleAction).Probability != SampleProbabilityAll {
+		t.Fatal(g)
+	}
+}
+
+func TestParseKernelSampleAction(t *testing.T) {
+	actions, err := kernelActions(func(msg *NlMsgBuilder) {
+		msg.PutNestedAttrs(OVS_ACTION_ATTR_SAMPLE, func() {
+			msg.PutUint32Attr(OVS_SAMPLE_ATTR_PROBABILITY, 0x80000000)
+			msg.PutNestedAttrs(OVS_SAMPLE_ATTR_ACTIONS, func() {
+				msg.PutUint32Attr(OVS_ACTION_ATTR_OUTPUT, 2)
+				msg.PutNestedAttrs(OVS_ACTION_ATTR_SAMPLE, func() {
+					msg.PutUint32Attr(OVS_SAMPLE_ATTR_PROBABILITY, 1)
+					msg.PutNestedAttrs(OVS_SAMPLE_ATTR_ACTIONS, func() {})
+				})
+			})
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := NewSampleAction(0x80000000, []Action{
+		NewOutputAction(2),
+		NewSampleAction(1, nil),
+	})
+	if len(actions) != 1 || !actions[0].Equals(expected) {
+		t.Fatal(actions)
+	}
+
+	_, err = kernelActions(func(msg *NlMsgBuilder) {
+		msg.PutNestedAttrs(OVS_ACTION_ATTR_SAMPLE, func() {
+			msg.PutNestedAttrs(OVS_SAMPLE_ATTR_ACTIONS, func() {})
+		})
+	})
+	if err == nil {
+		t.Fatal("expected error for sample action without probability")
+	}
+}
+
+func TestSetMaskedActionRoundTrip(t *testing.T) {
+	f := NewFlowSpec()
+	f.AddKey(NewEthernetFlowKey())
+	e := NewWildcardEthernetFlowKey()
+	e.SetEthDst([6]byte{1, 2, 3, 4, 5, 6})
+	f.AddAction(NewSetMaskedAction(e))
+	i4 := NewIPv4FlowKey()
+	i4.SetMaskedIpv4Dst([4]byte{10, 1, 2, 3}, [4]byte{255, 255, 0, 0})
+	i4.SetTtl(9)
+	f.AddAction(NewSetMaskedAction(i4))
+	i6 := NewIPv6FlowKey()
+	i6.SetIpv6Src(netip.MustParseAddr("fd00::5"))
+	f.AddAction(NewSetMaskedAction(i6))
+	tp := NewTcpFlowKey()
+	tp.SetDst(8080)
+	f.AddAction(NewSetMaskedAction(tp))
+
+	g := flowRoundTrip(t, f)
+	if k := g.Actions[0].(SetMaskedAction).Key().(EthernetFlowKey); k.Mask().EthSrc != [6]byte{} {
+		t.Fatal(k)
+	}
+
+	// Key bits outside the mask are cleared
+	if k := g.Actions[1].(SetMaskedAction).Key().(IPv4FlowKey); k.Key().Ipv4Dst != [4]byte{10, 1, 0, 0} {
+		t.Fatal(k)
+	}
+
+	if k := g.Actions[3].(SetMaskedAction).Key().(TcpFlowKey); k.Dst() != 8080 {
+		t.Fatal(k)
+	}
+}
+
+func TestParseKernelSetMaskedAction(t *testing.T) {
+	// The attribute holds the key followed by the mask
+	actions, err := kernelActions(func(msg *NlMsgBuilder) {
+		msg.PutNestedAttrs(OVS_ACTION_ATTR_SET_MASKED, func() {
+			msg.PutSliceAttr(OVS_KEY_ATTR_TCP, []byte{
+				0, 0, 0x1f, 0x90,
+				0, 0, 0xff, 0xff,
+			})
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := actions[0].(SetMaskedAction).Key().(TcpFlowKey)
+	if k.Dst() != 8080 || k.DstMask() != 0xffff || k.SrcMask() != 0 {
+		t.Fatal(k)
+	}
+
+	_, err = kernelActions(func(msg *NlMsgBuilder) {
+		msg.PutNestedAttrs(OVS_ACTION_ATTR_SET_MASKED, func() {
+			msg.PutSliceAttr(OVS_KEY_ATTR_TCP, []byte{0, 0, 0x1f})
+		})
+	})
+	if err == nil {
+		t.Fatal("expected error for odd length set masked action")
+	}
+}
+
+func TestRecircRoundTrip(t *testing.T) {
+	f := NewFlowSpec()
+	f.AddKey(NewEthernetFlowKey())
+	r := NewRecircIdFlowKey()
+	r.SetRecircId(7)
+	f.AddKey(r)
+	h := NewDpHashFlowKey()
+	h.SetMaskedDpHash(1, 3)
+	f.AddKey(h)
+	f.AddAction(NewHashAction(OVS_HASH_ALG_SYM_L4, 42))
+	f.AddAction(NewRecircAction(9))
+
+	g := flowRoundTrip(t, f)
+	if k := g.FlowKeys[OVS_KEY_ATTR_RECIRC_ID].(RecircIdFlowKey); k.RecircId() != 7 {
+		t.Fatal(k)
+	}
+}
+
+func TestParseKernelRecirc(t *testing.T) {
+	// Values are in host byte order
+	fks, err := kernelFlowKeys(func(msg *NlMsgBuilder) {
+		msg.PutUint32Attr(OVS_KEY_ATTR_RECIRC_ID, 7)
+		msg.PutUint32Attr(OVS_KEY_ATTR_DP_HASH, 0x12345)
+	}, func(msg *NlMsgBuilder) {
+		msg.PutUint32Attr(OVS_KEY_ATTR_RECIRC_ID, 0xffffffff)
+		msg.PutUint32Attr(OVS_KEY_ATTR_DP_HASH, 0xff)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r := fks[OVS_KEY_ATTR_RECIRC_ID].(RecircIdFlowKey); r.RecircId() != 7 || r.Mask() != 0xffffffff {
+		t.Fatal(r)
+	}
+
+	if h := fks[OVS_KEY_ATTR_DP_HASH].(DpHashFlowKey); h.DpHash() != 0x12345 || h.Mask() != 0xff {
+		t.Fatal(h)
+	}
+
+	hash := MakeAlignedByteSlice(8)
+	*uint32At(hash, 0) = OVS_HASH_ALG_L4
+	*uint32At(hash, 4) = 42
+	actions, err := kernelActions(func(msg *NlMsgBuilder) {
+		msg.PutSliceAttr(OVS_ACTION_ATTR_HASH, hash)
+		msg.PutUint32Attr(OVS_ACTION_ATTR_RECIRC, 9)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(actions) != 2 || !actions[0].Equals(NewHashAction(OVS_HASH_ALG_L4, 42)) ||
+		!actions[1].Equals(NewRecircAction(9)) {
+		t.Fatal(actions)
+	}
+}
+
+func TestModernActionsRoundTrip(t *testing.T) {
+	f := NewFlowSpec()
+	f.AddKey(NewEthernetFlowKey())
+	f.AddAction(NewTruncAction(100))
+	f.AddAction(NewCloneAction([]Action{NewPopEthAction(), NewOutputAction(3)}))
+	f.AddAction(NewCloneAction(nil))
+	f.AddAction(NewCheckPktLenAction(1500,
+		[]Action{NewUserspaceAction(1, nil)},
+		[]Action{NewCloneAction([]Action{NewOutputAction(2)})}))
+	f.AddAction(NewDecTtlAction(nil))
+	f.AddAction(NewDecTtlAction([]Action{NewOutputAction(1)}))
+	f.AddAction(NewPushEthAction([6]byte{1}, [6]byte{2}))
+	f.AddAction(NewMeterAction(5))
+	f.AddAction(NewDropAction(0))
+
+	flowRoundTrip(t, f)
+}
+
+func TestParseKernelModernActions(t *testing.T) {
+	actions, err := kernelActions(func(msg *NlMsgBuilder) {
+		msg.PutUint32Attr(OVS_ACTION_ATTR_TRUNC, 100)
+		msg.PutNestedAttrs(OVS_ACTION_ATTR_CHECK_PKT_LEN, func() {
+			msg.PutUint16Attr(OVS_CHECK_PKT_LEN_ATTR_PKT_LEN, 1500)
+			msg.PutNestedAttrs(OVS_CHECK_PKT_LEN_ATTR_ACTIONS_IF_GREATER, func() {
+				msg.PutUint32Attr(OVS_ACTION_ATTR_OUTPUT, 1)
+			})
+			msg.PutNestedAttrs(OVS_CHECK_PKT_LEN_ATTR_ACTIONS_IF_LESS_EQUAL, func() {})
+		})
+		msg.PutNestedAttrs(OVS_ACTION_ATTR_DEC_TTL, func() {
+			msg.PutNestedAttrs(OVS_DEC_TTL_ATTR_ACTION, func() {})
+		})
+		msg.PutSliceAttr(OVS_ACTION_ATTR_PUSH_ETH, []byte{
+			1, 0, 0, 0, 0, 0, // src
+			2, 0, 0, 0, 0, 0, // dst
+		})
+		msg.PutEmptyAttr(OVS_ACTION_ATTR_POP_ETH)
+		msg.PutUint32Attr(OVS_ACTION_ATTR_METER, 5)
+		msg.PutUint32Attr(OVS_ACTION_ATTR_DROP, 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Action{
+		NewTruncAction(100),
+		NewCheckPktLenAction(1500, []Action{NewOutputAction(1)}, nil),
+		NewDecTtlAction(nil),
+		NewPushEthAction([6]byte{1}, [6]byte{2}),
+		NewPopEthAction(),
+		NewMeterAction(5),
+		NewDropAction(0),
+	}
+	if !actionsEqual(actions, expected) {
+		t.Fatal(actions)
+	}
+
+	// check_pkt_len requires the packet length
+	_, err = kernelActions(func(msg *NlMsgBuilder) {
+		msg.PutNestedAttrs(OVS_ACTION_ATTR_CHECK_PKT_LEN, func() {
+			msg.PutNestedAttrs(OVS_CHECK_PKT_LEN_ATTR_ACTIONS_IF_GREATER, func() {})
+			msg.PutNestedAttrs(OVS_CHECK_PKT_LEN_ATTR_ACTIONS_IF_LESS_EQUAL, func() {})
+		})
+	})
+	if err == nil {
+		t.Fatal("expected error for check_pkt_len without a length")
+	}
+}
+
+func TestUnknownActionRoundTrip(t *testing.T) {
+	f := NewFlowSpec()
+	f.AddKey(NewEthernetFlowKey())
+	f.AddAction(NewUnknownAction(99, []byte{1, 2, 3}))
+	f.AddAction(NewCloneAction([]Action{NewUnknownAction(98, nil)}))
+	f.AddAction(NewOutputAction(1))
+
+	g := flowRoundTrip(t, f)
+	if a := g.Actions[0].(UnknownAction); a.Type != 99 || len(a.Data) != 3 {
+		t.Fatal(a)
+	}
+}
+
+// Actions from newer kernels are kept, and written back unchanged
+func TestParseKernelUnknownAction(t *testing.T) {
+	build := func(msg *NlMsgBuilder) {
+		msg.PutSliceAttr(200, []byte{9, 8, 7, 6, 5})
+		msg.PutNestedAttrs(OVS_ACTION_ATTR_SAMPLE, func() {
+			msg.PutUint32Attr(OVS_SAMPLE_ATTR_PROBABILITY, 1)
+			msg.PutNestedAttrs(OVS_SAMPLE_ATTR_ACTIONS, func() {
+				msg.PutEmptyAttr(201)
+			})
+		})
+	}
+
+	actions, err := kernelActions(build)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Action{
+		NewUnknownAction(200, []byte{9, 8, 7, 6, 5}),
+		NewSampleAction(1, []Action{NewUnknownAction(201, nil)}),
+	}
+	if !actionsEqual(actions, expected) {
+		t.Fatal(actions)
+	}
+
+	kernel := NewNlMsgBuilder(RequestFlags, 0)
+	build(kernel)
+	ours := NewNlMsgBuilder(RequestFlags, 0)
+	putActionsNlAttrs(ours, actions)
+	if !bytes.Equal(kernel.buf, ours.buf) {
+		t.Fatalf("expected %x, got %x", kernel.buf, ours.buf)
+	}
+}
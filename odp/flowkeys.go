@@ -0,0 +1,1379 @@
+package odp
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// Typed flow keys for packet header fields beyond the ethernet
+// header.  These are all BlobFlowKeys underneath, with accessors for
+// the fields of the corresponding ovs_key_* struct.
+//
+// Unlike NewBlobFlowKey, the constructors here produce flow keys with
+// all fields wildcarded, so that the setters can be used to match on
+// just the fields of interest.
+
+func newWildcardBlobFlowKey(typ uint16, size int) BlobFlowKey {
+	fk := NewBlobFlowKey(typ, size)
+	setAllBytes(fk.mask(), 0)
+	return fk
+}
+
+func setAllBytes(data []byte, x byte) {
+	for i := range data {
+		data[i] = x
+	}
+}
+
+func printMaskedUint8(buf *bytes.Buffer, sep *string, n string, k, m uint8) {
+	if m != 0 {
+		fmt.Fprintf(buf, "%s%s: %d", *sep, n, k)
+		if m != 0xff {
+			fmt.Fprintf(buf, "&%x", m)
+		}
+
+		*sep = ", "
+	}
+}
+
+// Produce the mask for an IP address prefix of the given length
+func prefixMask(mask []byte, prefixLen int) {
+	for i := range mask {
+		switch {
+		case prefixLen >= 8:
+			mask[i] = 0xff
+			prefixLen -= 8
+		case prefixLen > 0:
+			mask[i] = ^byte(0xff >> uint(prefixLen))
+			prefixLen = 0
+		default:
+			mask[i] = 0
+		}
+	}
+}
+
+// OVS_KEY_ATTR_ETHERTYPE: Ethernet type flow key
+//
+// The kernel needs an exact match on the ethertype in order to accept
+// flow keys for higher layer protocols.
+
+type EthertypeFlowKey struct {
+	BlobFlowKey
+}
+
+func NewEthertypeFlowKey(ethertype uint16) EthertypeFlowKey {
+	fk := EthertypeFlowKey{NewBlobFlowKey(OVS_KEY_ATTR_ETHERTYPE, 2)}
+	*uint16At(fk.BlobFlowKey.key(), 0) = uint16ToBE(ethertype)
+	return fk
+}
+
+func (fk EthertypeFlowKey) Ethertype() uint16 {
+	return uint16FromBE(*uint16At(fk.BlobFlowKey.key(), 0))
+}
+
+func (fk EthertypeFlowKey) Mask() uint16 {
+	return uint16FromBE(*uint16At(fk.BlobFlowKey.mask(), 0))
+}
+
+func (fk EthertypeFlowKey) String() string {
+	m := fk.Mask()
+	if m == 0xffff {
+		return fmt.Sprintf("EthertypeFlowKey{ethertype: %04x}", fk.Ethertype())
+	}
+
+	return fmt.Sprintf("EthertypeFlowKey{ethertype: %04x&%04x}", fk.Ethertype(), m)
+}
+
+var ethertypeFlowKeyParser = blobFlowKeyParser(2,
+	func(fk BlobFlowKey) FlowKey { return EthertypeFlowKey{fk} })
+
+// OVS_KEY_ATTR_IPV4: IPv4 header flow key
+
+type IPv4FlowKey struct {
+	BlobFlowKey
+}
+
+func NewIPv4FlowKey() IPv4FlowKey {
+	return IPv4FlowKey{newWildcardBlobFlowKey(OVS_KEY_ATTR_IPV4,
+		SizeofOvsKeyIpv4)}
+}
+
+func (fk *IPv4FlowKey) key() *OvsKeyIpv4 {
+	return ovsKeyIpv4At(fk.BlobFlowKey.key(), 0)
+}
+
+func (fk *IPv4FlowKey) mask() *OvsKeyIpv4 {
+	return ovsKeyIpv4At(fk.BlobFlowKey.mask(), 0)
+}
+
+func (fk IPv4FlowKey) Key() OvsKeyIpv4 {
+	return *fk.key()
+}
+
+func (fk IPv4FlowKey) Mask() OvsKeyIpv4 {
+	return *fk.mask()
+}
+
+func (fk *IPv4FlowKey) SetMaskedIpv4Src(addr [4]byte, mask [4]byte) {
+	fk.key().Ipv4Src = addr
+	fk.mask().Ipv4Src = mask
+}
+
+func (fk *IPv4FlowKey) SetIpv4Src(addr [4]byte) {
+	fk.SetMaskedIpv4Src(addr, [...]byte{0xff, 0xff, 0xff, 0xff})
+}
+
+// Match source addresses within a CIDR prefix
+func (fk *IPv4FlowKey) SetIpv4SrcPrefix(addr [4]byte, prefixLen int) {
+	var mask [4]byte
+	prefixMask(mask[:], prefixLen)
+	for i := range addr {
+		addr[i] &= mask[i]
+	}
+	fk.SetMaskedIpv4Src(addr, mask)
+}
+
+func (fk *IPv4FlowKey) SetMaskedIpv4Dst(addr [4]byte, mask [4]byte) {
+	fk.key().Ipv4Dst = addr
+	fk.mask().Ipv4Dst = mask
+}
+
+func (fk *IPv4FlowKey) SetIpv4Dst(addr [4]byte) {
+	fk.SetMaskedIpv4Dst(addr, [...]byte{0xff, 0xff, 0xff, 0xff})
+}
+
+// Match destination addresses within a CIDR prefix
+func (fk *IPv4FlowKey) SetIpv4DstPrefix(addr [4]byte, prefixLen int) {
+	var mask [4]byte
+	prefixMask(mask[:], prefixLen)
+	for i := range addr {
+		addr[i] &= mask[i]
+	}
+	fk.SetMaskedIpv4Dst(addr, mask)
+}
+
+func (fk *IPv4FlowKey) SetMaskedProto(proto uint8, mask uint8) {
+	fk.key().Ipv4Proto = proto
+	fk.mask().Ipv4Proto = mask
+}
+
+func (fk *IPv4FlowKey) SetProto(proto uint8) {
+	fk.SetMaskedProto(proto, 0xff)
+}
+
+func (fk *IPv4FlowKey) SetMaskedTos(tos uint8, mask uint8) {
+	fk.key().Ipv4Tos = tos
+	fk.mask().Ipv4Tos = mask
+}
+
+func (fk *IPv4FlowKey) SetTos(tos uint8) {
+	fk.SetMaskedTos(tos, 0xff)
+}
+
+func (fk *IPv4FlowKey) SetMaskedTtl(ttl uint8, mask uint8) {
+	fk.key().Ipv4Ttl = ttl
+	fk.mask().Ipv4Ttl = mask
+}
+
+func (fk *IPv4FlowKey) SetTtl(ttl uint8) {
+	fk.SetMaskedTtl(ttl, 0xff)
+}
+
+// frag is one of the OVS_FRAG_TYPE_* values
+func (fk *IPv4FlowKey) SetMaskedFrag(frag uint8, mask uint8) {
+	fk.key().Ipv4Frag = frag
+	fk.mask().Ipv4Frag = mask
+}
+
+func (fk *IPv4FlowKey) SetFrag(frag uint8) {
+	fk.SetMaskedFrag(frag, 0xff)
+}
+
+func (fk IPv4FlowKey) String() string {
+	var buf bytes.Buffer
+	var sep string
+	fmt.Fprint(&buf, "IPv4FlowKey{")
+
+	k := fk.Key()
+	m := fk.Mask()
+	printMaskedBytes(&buf, &sep, "src", k.Ipv4Src[:], m.Ipv4Src[:], ipv4ToString)
+	printMaskedBytes(&buf, &sep, "dst", k.Ipv4Dst[:], m.Ipv4Dst[:], ipv4ToString)
+	printMaskedUint8(&buf, &sep, "proto", k.Ipv4Proto, m.Ipv4Proto)
+	printMaskedUint8(&buf, &sep, "tos", k.Ipv4Tos, m.Ipv4Tos)
+	printMaskedUint8(&buf, &sep, "ttl", k.Ipv4Ttl, m.Ipv4Ttl)
+	printMaskedUint8(&buf, &sep, "frag", k.Ipv4Frag, m.Ipv4Frag)
+	fmt.Fprint(&buf, "}")
+	return buf.String()
+}
+
+var ipv4FlowKeyParser = blobFlowKeyParser(SizeofOvsKeyIpv4,
+	func(fk BlobFlowKey) FlowKey { return IPv4FlowKey{fk} })
+
+// OVS_KEY_ATTR_IPV6: IPv6 header flow key
+
+type IPv6FlowKey struct {
+	BlobFlowKey
+}
+
+// The flow label is 20 bits
+const IPV6_LABEL_MASK = 0xfffff
+
+func NewIPv6FlowKey() IPv6FlowKey {
+	return IPv6FlowKey{newWildcardBlobFlowKey(OVS_KEY_ATTR_IPV6,
+		SizeofOvsKeyIpv6)}
+}
+
+func (fk *IPv6FlowKey) key() *OvsKeyIpv6 {
+	return ovsKeyIpv6At(fk.BlobFlowKey.key(), 0)
+}
+
+func (fk *IPv6FlowKey) mask() *OvsKeyIpv6 {
+	return ovsKeyIpv6At(fk.BlobFlowKey.mask(), 0)
+}
+
+// Note that the Ipv6Label field of the result is big-endian; use
+// Label and LabelMask to get the flow label.
+func (fk IPv6FlowKey) Key() OvsKeyIpv6 {
+	return *fk.key()
+}
+
+func (fk IPv6FlowKey) Mask() OvsKeyIpv6 {
+	return *fk.mask()
+}
+
+func (fk IPv6FlowKey) Src() netip.Addr {
+	return netip.AddrFrom16(fk.key().Ipv6Src)
+}
+
+func (fk IPv6FlowKey) SrcMask() [16]byte {
+	return fk.mask().Ipv6Src
+}
+
+func (fk IPv6FlowKey) Dst() netip.Addr {
+	return netip.AddrFrom16(fk.key().Ipv6Dst)
+}
+
+func (fk IPv6FlowKey) DstMask() [16]byte {
+	return fk.mask().Ipv6Dst
+}
+
+func (fk IPv6FlowKey) Label() uint32 {
+	return uint32FromBE(fk.key().Ipv6Label)
+}
+
+func (fk IPv6FlowKey) LabelMask() uint32 {
+	return uint32FromBE(fk.mask().Ipv6Label)
+}
+
+func (fk *IPv6FlowKey) SetMaskedIpv6Src(addr netip.Addr, mask [16]byte) {
+	fk.key().Ipv6Src = addr.As16()
+	fk.mask().Ipv6Src = mask
+}
+
+func (fk *IPv6FlowKey) SetIpv6Src(addr netip.Addr) {
+	var mask [16]byte
+	prefixMask(mask[:], 128)
+	fk.SetMaskedIpv6Src(addr, mask)
+}
+
+// Match source addresses within a prefix
+func (fk *IPv6FlowKey) SetIpv6SrcPrefix(prefix netip.Prefix) {
+	fk.SetMaskedIpv6Src(ipv6PrefixMask(prefix))
+}
+
+func (fk *IPv6FlowKey) SetMaskedIpv6Dst(addr netip.Addr, mask [16]byte) {
+	fk.key().Ipv6Dst = addr.As16()
+	fk.mask().Ipv6Dst = mask
+}
+
+func (fk *IPv6FlowKey) SetIpv6Dst(addr netip.Addr) {
+	var mask [16]byte
+	prefixMask(mask[:], 128)
+	fk.SetMaskedIpv6Dst(addr, mask)
+}
+
+// Match destination addresses within a prefix
+func (fk *IPv6FlowKey) SetIpv6DstPrefix(prefix netip.Prefix) {
+	fk.SetMaskedIpv6Dst(ipv6PrefixMask(prefix))
+}
+
+// Panics if the prefix is invalid or not IPv6, as there is no
+// sensible key to match
+func ipv6PrefixMask(prefix netip.Prefix) (netip.Addr, [16]byte) {
+	if !prefix.IsValid() || !prefix.Addr().Is6() {
+		panic(fmt.Sprintf("not an IPv6 prefix: %s", prefix))
+	}
+
+	var mask [16]byte
+	prefixMask(mask[:], prefix.Bits())
+	return prefix.Masked().Addr(), mask
+}
+
+// Only the low 20 bits of the label and mask are used
+func (fk *IPv6FlowKey) SetMaskedLabel(label uint32, mask uint32) {
+	fk.key().Ipv6Label = uint32ToBE(label & IPV6_LABEL_MASK)
+	fk.mask().Ipv6Label = uint32ToBE(mask & IPV6_LABEL_MASK)
+}
+
+func (fk *IPv6FlowKey) SetLabel(label uint32) {
+	fk.SetMaskedLabel(label, IPV6_LABEL_MASK)
+}
+
+func (fk *IPv6FlowKey) SetMaskedProto(proto uint8, mask uint8) {
+	fk.key().Ipv6Proto = proto
+	fk.mask().Ipv6Proto = mask
+}
+
+func (fk *IPv6FlowKey) SetProto(proto uint8) {
+	fk.SetMaskedProto(proto, 0xff)
+}
+
+func (fk *IPv6FlowKey) SetMaskedTclass(tclass uint8, mask uint8) {
+	fk.key().Ipv6Tclass = tclass
+	fk.mask().Ipv6Tclass = mask
+}
+
+func (fk *IPv6FlowKey) SetTclass(tclass uint8) {
+	fk.SetMaskedTclass(tclass, 0xff)
+}
+
+func (fk *IPv6FlowKey) SetMaskedHlimit(hlimit uint8, mask uint8) {
+	fk.key().Ipv6Hlimit = hlimit
+	fk.mask().Ipv6Hlimit = mask
+}
+
+func (fk *IPv6FlowKey) SetHlimit(hlimit uint8) {
+	fk.SetMaskedHlimit(hlimit, 0xff)
+}
+
+// frag is one of the OVS_FRAG_TYPE_* values
+func (fk *IPv6FlowKey) SetMaskedFrag(frag uint8, mask uint8) {
+	fk.key().Ipv6Frag = frag
+	fk.mask().Ipv6Frag = mask
+}
+
+func (fk *IPv6FlowKey) SetFrag(frag uint8) {
+	fk.SetMaskedFrag(frag, 0xff)
+}
+
+// Show an IPv6 address, using prefix notation where the mask allows
+func ipv6MaskedString(k [16]byte, m [16]byte) string {
+	addr := netip.AddrFrom16(k)
+	if bits, ok := maskPrefixLen(m[:]); ok {
+		if bits == 128 {
+			return addr.String()
+		}
+
+		return netip.PrefixFrom(addr, bits).String()
+	}
+
+	return addr.String() + "&" + netip.AddrFrom16(m).String()
+}
+
+// If the mask is a prefix mask, return the prefix length
+func maskPrefixLen(mask []byte) (int, bool) {
+	bits := 0
+	for i, b := range mask {
+		if b == 0xff {
+			bits += 8
+			continue
+		}
+
+		// The remaining bits must be zero, with the leading
+		// bits of this byte set
+		for b&0x80 != 0 {
+			b <<= 1
+			bits++
+		}
+
+		return bits, b == 0 && AllBytes(mask[i+1:], 0)
+	}
+
+	return bits, true
+}
+
+func (fk IPv6FlowKey) String() string {
+	var buf bytes.Buffer
+	var sep string
+	fmt.Fprint(&buf, "IPv6FlowKey{")
+
+	k := fk.Key()
+	m := fk.Mask()
+
+	printAddr := func(n string, k, m [16]byte) {
+		if !AllBytes(m[:], 0) {
+			fmt.Fprintf(&buf, "%s%s: %s", sep, n, ipv6MaskedString(k, m))
+			sep = ", "
+		}
+	}
+
+	printAddr("src", k.Ipv6Src, m.Ipv6Src)
+	printAddr("dst", k.Ipv6Dst, m.Ipv6Dst)
+
+	if lm := fk.LabelMask(); lm != 0 {
+		fmt.Fprintf(&buf, "%slabel: %x", sep, fk.Label())
+		if lm != IPV6_LABEL_MASK {
+			fmt.Fprintf(&buf, "&%x", lm)
+		}
+		sep = ", "
+	}
+
+	printMaskedUint8(&buf, &sep, "proto", k.Ipv6Proto, m.Ipv6Proto)
+	printMaskedUint8(&buf, &sep, "tclass", k.Ipv6Tclass, m.Ipv6Tclass)
+	printMaskedUint8(&buf, &sep, "hlimit", k.Ipv6Hlimit, m.Ipv6Hlimit)
+	printMaskedUint8(&buf, &sep, "frag", k.Ipv6Frag, m.Ipv6Frag)
+	fmt.Fprint(&buf, "}")
+	return buf.String()
+}
+
+var ipv6FlowKeyParser = blobFlowKeyParser(SizeofOvsKeyIpv6,
+	func(fk BlobFlowKey) FlowKey { return IPv6FlowKey{fk} })
+
+// OVS_KEY_ATTR_TCP, OVS_KEY_ATTR_UDP, OVS_KEY_ATTR_SCTP: Transport
+// layer port flow keys.  These share a layout: big-endian source and
+// destination ports.
+
+type portsFlowKey struct {
+	BlobFlowKey
+}
+
+func newPortsFlowKey(typ uint16) portsFlowKey {
+	return portsFlowKey{newWildcardBlobFlowKey(typ, 4)}
+}
+
+func (fk portsFlowKey) Src() uint16 {
+	return uint16FromBE(*uint16At(fk.BlobFlowKey.key(), 0))
+}
+
+func (fk portsFlowKey) SrcMask() uint16 {
+	return uint16FromBE(*uint16At(fk.BlobFlowKey.mask(), 0))
+}
+
+func (fk portsFlowKey) Dst() uint16 {
+	return uint16FromBE(*uint16At(fk.BlobFlowKey.key(), 2))
+}
+
+func (fk portsFlowKey) DstMask() uint16 {
+	return uint16FromBE(*uint16At(fk.BlobFlowKey.mask(), 2))
+}
+
+func (fk *portsFlowKey) SetMaskedSrc(port uint16, mask uint16) {
+	*uint16At(fk.BlobFlowKey.key(), 0) = uint16ToBE(port)
+	*uint16At(fk.BlobFlowKey.mask(), 0) = uint16ToBE(mask)
+}
+
+func (fk *portsFlowKey) SetSrc(port uint16) {
+	fk.SetMaskedSrc(port, 0xffff)
+}
+
+func (fk *portsFlowKey) SetMaskedDst(port uint16, mask uint16) {
+	*uint16At(fk.BlobFlowKey.key(), 2) = uint16ToBE(port)
+	*uint16At(fk.BlobFlowKey.mask(), 2) = uint16ToBE(mask)
+}
+
+func (fk *portsFlowKey) SetDst(port uint16) {
+	fk.SetMaskedDst(port, 0xffff)
+}
+
+func (fk portsFlowKey) format(name string) string {
+	var buf bytes.Buffer
+	var sep string
+	fmt.Fprintf(&buf, "%s{", name)
+
+	printPort := func(n string, k, m uint16) {
+		if m != 0 {
+			fmt.Fprintf(&buf, "%s%s: %d", sep, n, k)
+			if m != 0xffff {
+				fmt.Fprintf(&buf, "&%x", m)
+			}
+			sep = ", "
+		}
+	}
+
+	printPort("src", fk.Src(), fk.SrcMask())
+	printPort("dst", fk.Dst(), fk.DstMask())
+	fmt.Fprint(&buf, "}")
+	return buf.String()
+}
+
+type TcpFlowKey struct {
+	portsFlowKey
+}
+
+func NewTcpFlowKey() TcpFlowKey {
+	return TcpFlowKey{newPortsFlowKey(OVS_KEY_ATTR_TCP)}
+}
+
+func (fk TcpFlowKey) String() string {
+	return fk.format("TcpFlowKey")
+}
+
+type UdpFlowKey struct {
+	portsFlowKey
+}
+
+func NewUdpFlowKey() UdpFlowKey {
+	return UdpFlowKey{newPortsFlowKey(OVS_KEY_ATTR_UDP)}
+}
+
+func (fk UdpFlowKey) String() string {
+	return fk.format("UdpFlowKey")
+}
+
+type SctpFlowKey struct {
+	portsFlowKey
+}
+
+func NewSctpFlowKey() SctpFlowKey {
+	return SctpFlowKey{newPortsFlowKey(OVS_KEY_ATTR_SCTP)}
+}
+
+func (fk SctpFlowKey) String() string {
+	return fk.format("SctpFlowKey")
+}
+
+var tcpFlowKeyParser = blobFlowKeyParser(4,
+	func(fk BlobFlowKey) FlowKey { return TcpFlowKey{portsFlowKey{fk}} })
+
+var udpFlowKeyParser = blobFlowKeyParser(4,
+	func(fk BlobFlowKey) FlowKey { return UdpFlowKey{portsFlowKey{fk}} })
+
+var sctpFlowKeyParser = blobFlowKeyParser(4,
+	func(fk BlobFlowKey) FlowKey { return SctpFlowKey{portsFlowKey{fk}} })
+
+// A port number and mask, as used with SetMaskedSrc and SetMaskedDst
+type PortMask struct {
+	Port uint16
+	Mask uint16
+}
+
+// Flow keys can't match a range of ports directly.  This returns a
+// minimal set of masked port matches that together cover the
+// inclusive range from lo to hi, for use in separate flows.
+func PortRangeMasks(lo uint16, hi uint16) []PortMask {
+	var res []PortMask
+	port := uint32(lo)
+	for port <= uint32(hi) {
+		// Find the largest aligned block starting at port
+		// that doesn't extend past hi
+		size := uint32(1)
+		for port&(size*2-1) == 0 && port+size*2-1 <= uint32(hi) &&
+			size < 0x10000 {
+			size *= 2
+		}
+
+		res = append(res, PortMask{uint16(port), uint16(^(size - 1))})
+		port += size
+	}
+
+	return res
+}
+
+// OVS_KEY_ATTR_TCP_FLAGS: TCP flags flow key
+
+const (
+	TCP_FLAG_FIN = 0x001
+	TCP_FLAG_SYN = 0x002
+	TCP_FLAG_RST = 0x004
+	TCP_FLAG_PSH = 0x008
+	TCP_FLAG_ACK = 0x010
+	TCP_FLAG_URG = 0x020
+	TCP_FLAG_ECE = 0x040
+	TCP_FLAG_CWR = 0x080
+	TCP_FLAG_NS  = 0x100
+)
+
+type TcpFlagsFlowKey struct {
+	BlobFlowKey
+}
+
+func NewTcpFlagsFlowKey() TcpFlagsFlowKey {
+	return TcpFlagsFlowKey{newWildcardBlobFlowKey(OVS_KEY_ATTR_TCP_FLAGS, 2)}
+}
+
+func (fk TcpFlagsFlowKey) Flags() uint16 {
+	return uint16FromBE(*uint16At(fk.BlobFlowKey.key(), 0))
+}
+
+func (fk TcpFlagsFlowKey) Mask() uint16 {
+	return uint16FromBE(*uint16At(fk.BlobFlowKey.mask(), 0))
+}
+
+// Match packets where the flags in mask have the values given in
+// flags.  E.g. SetMaskedFlags(TCP_FLAG_SYN, TCP_FLAG_SYN|TCP_FLAG_ACK)
+// matches initial SYN packets.
+func (fk *TcpFlagsFlowKey) SetMaskedFlags(flags uint16, mask uint16) {
+	*uint16At(fk.BlobFlowKey.key(), 0) = uint16ToBE(flags)
+	*uint16At(fk.BlobFlowKey.mask(), 0) = uint16ToBE(mask)
+}
+
+func (fk *TcpFlagsFlowKey) SetFlags(flags uint16) {
+	fk.SetMaskedFlags(flags, 0xfff)
+}
+
+func (fk TcpFlagsFlowKey) String() string {
+	return fmt.Sprintf("TcpFlagsFlowKey{flags: %03x&%03x}", fk.Flags(), fk.Mask())
+}
+
+var tcpFlagsFlowKeyParser = blobFlowKeyParser(2,
+	func(fk BlobFlowKey) FlowKey { return TcpFlagsFlowKey{fk} })
+
+// OVS_KEY_ATTR_ICMP, OVS_KEY_ATTR_ICMPV6: ICMP type and code flow
+// keys
+
+type icmpFlowKey struct {
+	BlobFlowKey
+}
+
+func (fk *icmpFlowKey) key() *OvsKeyIcmp {
+	return ovsKeyIcmpAt(fk.BlobFlowKey.key(), 0)
+}
+
+func (fk *icmpFlowKey) mask() *OvsKeyIcmp {
+	return ovsKeyIcmpAt(fk.BlobFlowKey.mask(), 0)
+}
+
+func (fk icmpFlowKey) Key() OvsKeyIcmp {
+	return *fk.key()
+}
+
+func (fk icmpFlowKey) Mask() OvsKeyIcmp {
+	return *fk.mask()
+}
+
+func (fk *icmpFlowKey) SetMaskedType(typ uint8, mask uint8) {
+	fk.key().IcmpType = typ
+	fk.mask().IcmpType = mask
+}
+
+func (fk *icmpFlowKey) SetType(typ uint8) {
+	fk.SetMaskedType(typ, 0xff)
+}
+
+func (fk *icmpFlowKey) SetMaskedCode(code uint8, mask uint8) {
+	fk.key().IcmpCode = code
+	fk.mask().IcmpCode = mask
+}
+
+func (fk *icmpFlowKey) SetCode(code uint8) {
+	fk.SetMaskedCode(code, 0xff)
+}
+
+func (fk icmpFlowKey) format(name string) string {
+	var buf bytes.Buffer
+	var sep string
+	fmt.Fprintf(&buf, "%s{", name)
+
+	k := fk.Key()
+	m := fk.Mask()
+	printMaskedUint8(&buf, &sep, "type", k.IcmpType, m.IcmpType)
+	printMaskedUint8(&buf, &sep, "code", k.IcmpCode, m.IcmpCode)
+	fmt.Fprint(&buf, "}")
+	return buf.String()
+}
+
+type IcmpFlowKey struct {
+	icmpFlowKey
+}
+
+func NewIcmpFlowKey() IcmpFlowKey {
+	return IcmpFlowKey{icmpFlowKey{newWildcardBlobFlowKey(OVS_KEY_ATTR_ICMP,
+		SizeofOvsKeyIcmp)}}
+}
+
+func (fk IcmpFlowKey) String() string {
+	return fk.format("IcmpFlowKey")
+}
+
+type Icmpv6FlowKey struct {
+	icmpFlowKey
+}
+
+func NewIcmpv6FlowKey() Icmpv6FlowKey {
+	return Icmpv6FlowKey{icmpFlowKey{newWildcardBlobFlowKey(OVS_KEY_ATTR_ICMPV6,
+		SizeofOvsKeyIcmp)}}
+}
+
+func (fk Icmpv6FlowKey) String() string {
+	return fk.format("Icmpv6FlowKey")
+}
+
+var icmpFlowKeyParser = blobFlowKeyParser(SizeofOvsKeyIcmp,
+	func(fk BlobFlowKey) FlowKey { return IcmpFlowKey{icmpFlowKey{fk}} })
+
+var icmpv6FlowKeyParser = blobFlowKeyParser(SizeofOvsKeyIcmp,
+	func(fk BlobFlowKey) FlowKey { return Icmpv6FlowKey{icmpFlowKey{fk}} })
+
+// ICMPv6 types for neighbor discovery, which the ND flow key applies
+// to
+const (
+	ND_NEIGHBOR_SOLICIT = 135
+	ND_NEIGHBOR_ADVERT  = 136
+)
+
+// OVS_KEY_ATTR_ARP: ARP flow key
+
+type ArpFlowKey struct {
+	BlobFlowKey
+}
+
+func NewArpFlowKey() ArpFlowKey {
+	return ArpFlowKey{newWildcardBlobFlowKey(OVS_KEY_ATTR_ARP,
+		SizeofOvsKeyArp)}
+}
+
+func (fk *ArpFlowKey) key() *OvsKeyArp {
+	return ovsKeyArpAt(fk.BlobFlowKey.key(), 0)
+}
+
+func (fk *ArpFlowKey) mask() *OvsKeyArp {
+	return ovsKeyArpAt(fk.BlobFlowKey.mask(), 0)
+}
+
+// Note that the ArpOp field of the result is big-endian; use Op and
+// OpMask to get the ARP opcode.
+func (fk ArpFlowKey) Key() OvsKeyArp {
+	return *fk.key()
+}
+
+func (fk ArpFlowKey) Mask() OvsKeyArp {
+	return *fk.mask()
+}
+
+func (fk ArpFlowKey) Op() uint16 {
+	return uint16FromBE(fk.key().ArpOp)
+}
+
+func (fk ArpFlowKey) OpMask() uint16 {
+	return uint16FromBE(fk.mask().ArpOp)
+}
+
+func (fk *ArpFlowKey) SetMaskedSip(addr [4]byte, mask [4]byte) {
+	fk.key().ArpSip = addr
+	fk.mask().ArpSip = mask
+}
+
+func (fk *ArpFlowKey) SetSip(addr [4]byte) {
+	fk.SetMaskedSip(addr, [...]byte{0xff, 0xff, 0xff, 0xff})
+}
+
+func (fk *ArpFlowKey) SetMaskedTip(addr [4]byte, mask [4]byte) {
+	fk.key().ArpTip = addr
+	fk.mask().ArpTip = mask
+}
+
+func (fk *ArpFlowKey) SetTip(addr [4]byte) {
+	fk.SetMaskedTip(addr, [...]byte{0xff, 0xff, 0xff, 0xff})
+}
+
+func (fk *ArpFlowKey) SetMaskedOp(op uint16, mask uint16) {
+	fk.key().ArpOp = uint16ToBE(op)
+	fk.mask().ArpOp = uint16ToBE(mask)
+}
+
+func (fk *ArpFlowKey) SetOp(op uint16) {
+	fk.SetMaskedOp(op, 0xffff)
+}
+
+func (fk *ArpFlowKey) SetMaskedSha(addr [ETH_ALEN]byte, mask [ETH_ALEN]byte) {
+	fk.key().ArpSha = addr
+	fk.mask().ArpSha = mask
+}
+
+func (fk *ArpFlowKey) SetSha(addr [ETH_ALEN]byte) {
+	fk.SetMaskedSha(addr, [...]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+}
+
+func (fk *ArpFlowKey) SetMaskedTha(addr [ETH_ALEN]byte, mask [ETH_ALEN]byte) {
+	fk.key().ArpTha = addr
+	fk.mask().ArpTha = mask
+}
+
+func (fk *ArpFlowKey) SetTha(addr [ETH_ALEN]byte) {
+	fk.SetMaskedTha(addr, [...]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+}
+
+func (fk ArpFlowKey) String() string {
+	var buf bytes.Buffer
+	var sep string
+	fmt.Fprint(&buf, "ArpFlowKey{")
+
+	k := fk.Key()
+	m := fk.Mask()
+	ha := func(s []byte) string { return net.HardwareAddr(s).String() }
+	printMaskedBytes(&buf, &sep, "sip", k.ArpSip[:], m.ArpSip[:], ipv4ToString)
+	printMaskedBytes(&buf, &sep, "tip", k.ArpTip[:], m.ArpTip[:], ipv4ToString)
+
+	if om := fk.OpMask(); om != 0 {
+		fmt.Fprintf(&buf, "%sop: %d", sep, fk.Op())
+		if om != 0xffff {
+			fmt.Fprintf(&buf, "&%x", om)
+		}
+		sep = ", "
+	}
+
+	printMaskedBytes(&buf, &sep, "sha", k.ArpSha[:], m.ArpSha[:], ha)
+	printMaskedBytes(&buf, &sep, "tha", k.ArpTha[:], m.ArpTha[:], ha)
+	fmt.Fprint(&buf, "}")
+	return buf.String()
+}
+
+var arpFlowKeyParser = blobFlowKeyParser(SizeofOvsKeyArp,
+	func(fk BlobFlowKey) FlowKey { return ArpFlowKey{fk} })
+
+// OVS_KEY_ATTR_ND: IPv6 neighbor discovery flow key
+//
+// The kernel only accepts this along with an ICMPv6 flow key matching
+// neighbor solicitations or advertisements.
+
+type NdFlowKey struct {
+	BlobFlowKey
+}
+
+func NewNdFlowKey() NdFlowKey {
+	return NdFlowKey{newWildcardBlobFlowKey(OVS_KEY_ATTR_ND,
+		SizeofOvsKeyNd)}
+}
+
+func (fk *NdFlowKey) key() *OvsKeyNd {
+	return ovsKeyNdAt(fk.BlobFlowKey.key(), 0)
+}
+
+func (fk *NdFlowKey) mask() *OvsKeyNd {
+	return ovsKeyNdAt(fk.BlobFlowKey.mask(), 0)
+}
+
+func (fk NdFlowKey) Key() OvsKeyNd {
+	return *fk.key()
+}
+
+func (fk NdFlowKey) Mask() OvsKeyNd {
+	return *fk.mask()
+}
+
+func (fk NdFlowKey) Target() netip.Addr {
+	return netip.AddrFrom16(fk.key().NdTarget)
+}
+
+func (fk *NdFlowKey) SetMaskedTarget(addr netip.Addr, mask [16]byte) {
+	fk.key().NdTarget = addr.As16()
+	fk.mask().NdTarget = mask
+}
+
+func (fk *NdFlowKey) SetTarget(addr netip.Addr) {
+	var mask [16]byte
+	prefixMask(mask[:], 128)
+	fk.SetMaskedTarget(addr, mask)
+}
+
+// Source link-layer address option (in neighbor solicitations)
+func (fk *NdFlowKey) SetMaskedSll(addr [ETH_ALEN]byte, mask [ETH_ALEN]byte) {
+	fk.key().NdSll = addr
+	fk.mask().NdSll = mask
+}
+
+func (fk *NdFlowKey) SetSll(addr [ETH_ALEN]byte) {
+	fk.SetMaskedSll(addr, [...]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+}
+
+// Target link-layer address option (in neighbor advertisements)
+func (fk *NdFlowKey) SetMaskedTll(addr [ETH_ALEN]byte, mask [ETH_ALEN]byte) {
+	fk.key().NdTll = addr
+	fk.mask().NdTll = mask
+}
+
+func (fk *NdFlowKey) SetTll(addr [ETH_ALEN]byte) {
+	fk.SetMaskedTll(addr, [...]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+}
+
+func (fk NdFlowKey) String() string {
+	var buf bytes.Buffer
+	var sep string
+	fmt.Fprint(&buf, "NdFlowKey{")
+
+	k := fk.Key()
+	m := fk.Mask()
+	if !AllBytes(m.NdTarget[:], 0) {
+		fmt.Fprintf(&buf, "target: %s", ipv6MaskedString(k.NdTarget, m.NdTarget))
+		sep = ", "
+	}
+
+	ha := func(s []byte) string { return net.HardwareAddr(s).String() }
+	printMaskedBytes(&buf, &sep, "sll", k.NdSll[:], m.NdSll[:], ha)
+	printMaskedBytes(&buf, &sep, "tll", k.NdTll[:], m.NdTll[:], ha)
+	fmt.Fprint(&buf, "}")
+	return buf.String()
+}
+
+var ndFlowKeyParser = blobFlowKeyParser(SizeofOvsKeyNd,
+	func(fk BlobFlowKey) FlowKey { return NdFlowKey{fk} })
+
+// OVS_KEY_ATTR_VLAN: 802.1Q VLAN TCI flow key
+//
+// The kernel represents a VLAN tagged packet with an ethertype flow
+// key giving the TPID, a VLAN flow key giving the TCI, and an encap
+// flow key containing the flow keys for the rest of the packet.  The
+// CFI bit of the TCI indicates that the tag is present, and must
+// always be matched exactly.
+
+type VlanFlowKey struct {
+	BlobFlowKey
+}
+
+// Produce a VLAN flow key that matches any tagged packet
+func NewVlanFlowKey() VlanFlowKey {
+	fk := VlanFlowKey{newWildcardBlobFlowKey(OVS_KEY_ATTR_VLAN, 2)}
+	fk.setMaskedTCI(VLAN_CFI_MASK, VLAN_CFI_MASK, VLAN_CFI_MASK)
+	return fk
+}
+
+func (fk VlanFlowKey) TCI() uint16 {
+	return uint16FromBE(*uint16At(fk.BlobFlowKey.key(), 0))
+}
+
+func (fk VlanFlowKey) TCIMask() uint16 {
+	return uint16FromBE(*uint16At(fk.BlobFlowKey.mask(), 0))
+}
+
+// Set the TCI bits selected by field
+func (fk *VlanFlowKey) setMaskedTCI(tci uint16, mask uint16, field uint16) {
+	k := uint16At(fk.BlobFlowKey.key(), 0)
+	m := uint16At(fk.BlobFlowKey.mask(), 0)
+	*k = uint16ToBE(uint16FromBE(*k)&^field | tci&field)
+	*m = uint16ToBE(uint16FromBE(*m)&^field | mask&field)
+}
+
+func (fk VlanFlowKey) Vid() uint16 {
+	return fk.TCI() & VLAN_VID_MASK
+}
+
+func (fk VlanFlowKey) VidMask() uint16 {
+	return fk.TCIMask() & VLAN_VID_MASK
+}
+
+func (fk *VlanFlowKey) SetMaskedVid(vid uint16, mask uint16) {
+	fk.setMaskedTCI(vid, mask, VLAN_VID_MASK)
+}
+
+func (fk *VlanFlowKey) SetVid(vid uint16) {
+	fk.SetMaskedVid(vid, VLAN_VID_MASK)
+}
+
+func (fk VlanFlowKey) Pcp() uint8 {
+	return uint8(fk.TCI() >> VLAN_PCP_SHIFT)
+}
+
+func (fk VlanFlowKey) PcpMask() uint8 {
+	return uint8(fk.TCIMask() >> VLAN_PCP_SHIFT)
+}
+
+func (fk *VlanFlowKey) SetMaskedPcp(pcp uint8, mask uint8) {
+	fk.setMaskedTCI(uint16(pcp)<<VLAN_PCP_SHIFT,
+		uint16(mask)<<VLAN_PCP_SHIFT, VLAN_PCP_MASK)
+}
+
+func (fk *VlanFlowKey) SetPcp(pcp uint8) {
+	fk.SetMaskedPcp(pcp, VLAN_PCP_MASK>>VLAN_PCP_SHIFT)
+}
+
+func (fk VlanFlowKey) String() string {
+	var buf bytes.Buffer
+	var sep string
+	fmt.Fprint(&buf, "VlanFlowKey{")
+
+	if fk.TCIMask()&VLAN_CFI_MASK != 0 && fk.TCI()&VLAN_CFI_MASK == 0 {
+		fmt.Fprint(&buf, "present: false")
+		sep = ", "
+	}
+
+	if m := fk.VidMask(); m != 0 {
+		fmt.Fprintf(&buf, "%svid: %d", sep, fk.Vid())
+		if m != VLAN_VID_MASK {
+			fmt.Fprintf(&buf, "&%x", m)
+		}
+		sep = ", "
+	}
+
+	if m := fk.PcpMask(); m != 0 {
+		fmt.Fprintf(&buf, "%spcp: %d", sep, fk.Pcp())
+		if m != VLAN_PCP_MASK>>VLAN_PCP_SHIFT {
+			fmt.Fprintf(&buf, "&%x", m)
+		}
+	}
+
+	fmt.Fprint(&buf, "}")
+	return buf.String()
+}
+
+var vlanFlowKeyParser = blobFlowKeyParser(2,
+	func(fk BlobFlowKey) FlowKey { return VlanFlowKey{fk} })
+
+// OVS_KEY_ATTR_RECIRC_ID: Recirculation id flow key
+//
+// Packets that have not been recirculated have recirculation id 0.
+
+type RecircIdFlowKey struct {
+	BlobFlowKey
+}
+
+func NewRecircIdFlowKey() RecircIdFlowKey {
+	return RecircIdFlowKey{newWildcardBlobFlowKey(OVS_KEY_ATTR_RECIRC_ID, 4)}
+}
+
+func (fk RecircIdFlowKey) RecircId() uint32 {
+	return *uint32At(fk.BlobFlowKey.key(), 0)
+}
+
+func (fk RecircIdFlowKey) Mask() uint32 {
+	return *uint32At(fk.BlobFlowKey.mask(), 0)
+}
+
+func (fk *RecircIdFlowKey) SetMaskedRecircId(id uint32, mask uint32) {
+	*uint32At(fk.BlobFlowKey.key(), 0) = id
+	*uint32At(fk.BlobFlowKey.mask(), 0) = mask
+}
+
+func (fk *RecircIdFlowKey) SetRecircId(id uint32) {
+	fk.SetMaskedRecircId(id, 0xffffffff)
+}
+
+func (fk RecircIdFlowKey) String() string {
+	if m := fk.Mask(); m != 0xffffffff {
+		return fmt.Sprintf("RecircIdFlowKey{id: %d&%x}", fk.RecircId(), m)
+	}
+
+	return fmt.Sprintf("RecircIdFlowKey{id: %d}", fk.RecircId())
+}
+
+var recircIdFlowKeyParser = blobFlowKeyParser(4,
+	func(fk BlobFlowKey) FlowKey { return RecircIdFlowKey{fk} })
+
+// OVS_KEY_ATTR_DP_HASH: Datapath hash flow key, as computed by a
+// HashAction
+
+type DpHashFlowKey struct {
+	BlobFlowKey
+}
+
+func NewDpHashFlowKey() DpHashFlowKey {
+	return DpHashFlowKey{newWildcardBlobFlowKey(OVS_KEY_ATTR_DP_HASH, 4)}
+}
+
+func (fk DpHashFlowKey) DpHash() uint32 {
+	return *uint32At(fk.BlobFlowKey.key(), 0)
+}
+
+func (fk DpHashFlowKey) Mask() uint32 {
+	return *uint32At(fk.BlobFlowKey.mask(), 0)
+}
+
+func (fk *DpHashFlowKey) SetMaskedDpHash(hash uint32, mask uint32) {
+	*uint32At(fk.BlobFlowKey.key(), 0) = hash
+	*uint32At(fk.BlobFlowKey.mask(), 0) = mask
+}
+
+func (fk *DpHashFlowKey) SetDpHash(hash uint32) {
+	fk.SetMaskedDpHash(hash, 0xffffffff)
+}
+
+func (fk DpHashFlowKey) String() string {
+	if m := fk.Mask(); m != 0xffffffff {
+		return fmt.Sprintf("DpHashFlowKey{hash: %x&%x}", fk.DpHash(), m)
+	}
+
+	return fmt.Sprintf("DpHashFlowKey{hash: %x}", fk.DpHash())
+}
+
+var dpHashFlowKeyParser = blobFlowKeyParser(4,
+	func(fk BlobFlowKey) FlowKey { return DpHashFlowKey{fk} })
+
+// OVS_KEY_ATTR_MPLS: MPLS label stack flow key
+//
+// The key holds one or more label stack entries, outermost first.
+// Flows with MPLS keys should also have an MPLS ethertype.
+
+type MplsFlowKey struct {
+	BlobFlowKey
+}
+
+// Produce an MPLS flow key with the given number of label stack
+// entries, all wildcarded
+func NewMplsFlowKey(depth int) MplsFlowKey {
+	return MplsFlowKey{newWildcardBlobFlowKey(OVS_KEY_ATTR_MPLS,
+		depth*SizeofOvsKeyMpls)}
+}
+
+func (fk MplsFlowKey) Depth() int {
+	return len(fk.BlobFlowKey.key()) / SizeofOvsKeyMpls
+}
+
+func (fk MplsFlowKey) LSE(i int) uint32 {
+	return uint32FromBE(*uint32At(fk.BlobFlowKey.key(), i*SizeofOvsKeyMpls))
+}
+
+func (fk MplsFlowKey) LSEMask(i int) uint32 {
+	return uint32FromBE(*uint32At(fk.BlobFlowKey.mask(), i*SizeofOvsKeyMpls))
+}
+
+// Set the bits selected by field of label stack entry i
+func (fk *MplsFlowKey) setMaskedLSE(i int, lse uint32, mask uint32, field uint32) {
+	k := uint32At(fk.BlobFlowKey.key(), i*SizeofOvsKeyMpls)
+	m := uint32At(fk.BlobFlowKey.mask(), i*SizeofOvsKeyMpls)
+	*k = uint32ToBE(uint32FromBE(*k)&^field | lse&field)
+	*m = uint32ToBE(uint32FromBE(*m)&^field | mask&field)
+}
+
+func (fk MplsFlowKey) Label(i int) uint32 {
+	return fk.LSE(i) >> MPLS_LS_LABEL_SHIFT
+}
+
+func (fk MplsFlowKey) LabelMask(i int) uint32 {
+	return fk.LSEMask(i) >> MPLS_LS_LABEL_SHIFT
+}
+
+func (fk *MplsFlowKey) SetMaskedLabel(i int, label uint32, mask uint32) {
+	fk.setMaskedLSE(i, label<<MPLS_LS_LABEL_SHIFT,
+		mask<<MPLS_LS_LABEL_SHIFT, MPLS_LS_LABEL_MASK)
+}
+
+func (fk *MplsFlowKey) SetLabel(i int, label uint32) {
+	fk.SetMaskedLabel(i, label, MPLS_LS_LABEL_MASK>>MPLS_LS_LABEL_SHIFT)
+}
+
+func (fk MplsFlowKey) TC(i int) uint8 {
+	return uint8((fk.LSE(i) & MPLS_LS_TC_MASK) >> MPLS_LS_TC_SHIFT)
+}
+
+func (fk MplsFlowKey) TCMask(i int) uint8 {
+	return uint8((fk.LSEMask(i) & MPLS_LS_TC_MASK) >> MPLS_LS_TC_SHIFT)
+}
+
+func (fk *MplsFlowKey) SetMaskedTC(i int, tc uint8, mask uint8) {
+	fk.setMaskedLSE(i, uint32(tc)<<MPLS_LS_TC_SHIFT,
+		uint32(mask)<<MPLS_LS_TC_SHIFT, MPLS_LS_TC_MASK)
+}
+
+func (fk *MplsFlowKey) SetTC(i int, tc uint8) {
+	fk.SetMaskedTC(i, tc, MPLS_LS_TC_MASK>>MPLS_LS_TC_SHIFT)
+}
+
+func (fk MplsFlowKey) BOS(i int) bool {
+	return fk.LSE(i)&MPLS_LS_S_MASK != 0
+}
+
+func (fk MplsFlowKey) BOSMask(i int) bool {
+	return fk.LSEMask(i)&MPLS_LS_S_MASK != 0
+}
+
+func (fk *MplsFlowKey) SetBOS(i int, bos bool) {
+	var lse uint32
+	if bos {
+		lse = MPLS_LS_S_MASK
+	}
+
+	fk.setMaskedLSE(i, lse, MPLS_LS_S_MASK, MPLS_LS_S_MASK)
+}
+
+func (fk MplsFlowKey) TTL(i int) uint8 {
+	return uint8(fk.LSE(i) & MPLS_LS_TTL_MASK)
+}
+
+func (fk MplsFlowKey) TTLMask(i int) uint8 {
+	return uint8(fk.LSEMask(i) & MPLS_LS_TTL_MASK)
+}
+
+func (fk *MplsFlowKey) SetMaskedTTL(i int, ttl uint8, mask uint8) {
+	fk.setMaskedLSE(i, uint32(ttl), uint32(mask), MPLS_LS_TTL_MASK)
+}
+
+func (fk *MplsFlowKey) SetTTL(i int, ttl uint8) {
+	fk.SetMaskedTTL(i, ttl, MPLS_LS_TTL_MASK)
+}
+
+func (fk MplsFlowKey) String() string {
+	var buf bytes.Buffer
+	fmt.Fprint(&buf, "MplsFlowKey{")
+
+	for i := 0; i < fk.Depth(); i++ {
+		if i > 0 {
+			fmt.Fprint(&buf, ", ")
+		}
+
+		fmt.Fprintf(&buf, "{lse: %08x", fk.LSE(i))
+		if m := fk.LSEMask(i); m != 0xffffffff {
+			fmt.Fprintf(&buf, "&%08x", m)
+		}
+		fmt.Fprint(&buf, "}")
+	}
+
+	fmt.Fprint(&buf, "}")
+	return buf.String()
+}
+
+// The length of the MPLS flow key depends on the depth of the label
+// stack, so the exact and ignore masks are derived from the key.
+func parseMplsFlowKey(typ uint16, key []byte, mask []byte, exact bool) (FlowKey, error) {
+	if key != nil && len(mask) == 0 {
+		mask = make([]byte, len(key))
+		if exact {
+			setAllBytes(mask, 0xff)
+		}
+	}
+
+	if len(mask)%SizeofOvsKeyMpls != 0 {
+		return nil, fmt.Errorf("MPLS flow key mask has bad length %d", len(mask))
+	}
+
+	fk, err := parseBlobFlowKey(typ, key, mask, len(mask))
+	if err != nil {
+		return nil, err
+	}
+
+	return MplsFlowKey{fk}, nil
+}
+
+// OVS_KEY_ATTR_ENCAP: The flow keys for the contents of a VLAN tagged
+// packet.  For double tagged (QinQ) packets, the inner VLAN is
+// described by a VLAN flow key within the encap flow key, which
+// contains a further encap flow key.
+
+type EncapFlowKey struct {
+	keys FlowKeys
+}
+
+func NewEncapFlowKey(keys FlowKeys) EncapFlowKey {
+	return EncapFlowKey{keys: keys}
+}
+
+func (fk EncapFlowKey) Keys() FlowKeys {
+	return fk.keys
+}
+
+func (fk EncapFlowKey) String() string {
+	var keys []FlowKey
+	for _, k := range fk.keys {
+		keys = append(keys, k)
+	}
+
+	return fmt.Sprintf("EncapFlowKey{keys: %v}", keys)
+}
+
+func (EncapFlowKey) typeId() uint16 {
+	return OVS_KEY_ATTR_ENCAP
+}
+
+func (fk EncapFlowKey) putKeyNlAttr(msg *NlMsgBuilder) {
+	msg.PutNestedAttrs(OVS_KEY_ATTR_ENCAP, func() {
+		for _, k := range fk.keys {
+			if !k.Ignored() {
+				k.putKeyNlAttr(msg)
+			}
+		}
+	})
+}
+
+func (fk EncapFlowKey) putMaskNlAttr(msg *NlMsgBuilder) error {
+	var err error
+	msg.PutNestedAttrs(OVS_KEY_ATTR_ENCAP, func() {
+		for _, k := range fk.keys {
+			if !k.Ignored() {
+				if e := k.putMaskNlAttr(msg); e != nil {
+					err = e
+				}
+			}
+		}
+	})
+	return err
+}
+
+func (fk EncapFlowKey) Ignored() bool {
+	// The kernel requires an encap flow key whenever the
+	// ethertype indicates a VLAN tag, even if it is empty
+	return false
+}
+
+func (a EncapFlowKey) Equals(gb FlowKey) bool {
+	b, ok := gb.(EncapFlowKey)
+	if !ok {
+		return false
+	}
+	return a.keys.Equals(b.keys)
+}
+
+func parseEncapFlowKey(typ uint16, key []byte, mask []byte, exact bool) (FlowKey, error) {
+	keys := make(Attrs)
+	if key != nil {
+		var err error
+		keys, err = ParseNestedAttrs(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var masks Attrs
+	if !exact {
+		var err error
+		masks, err = ParseNestedAttrs(mask)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fks, err := ParseFlowKeys(keys, masks)
+	if err != nil {
+		return nil, err
+	}
+
+	return EncapFlowKey{keys: fks}, nil
+}
+
+func init() {
+	// The encap flow key parser refers to flowKeyParsers, so it
+	// has to be registered here to avoid an initialization loop
+	flowKeyParsers[OVS_KEY_ATTR_ENCAP] = FlowKeyParser{
+		parse:      parseEncapFlowKey,
+		exactMask:  nil,
+		ignoreMask: []byte{},
+	}
+}
+
+// Flow keys that describe the packet contents following the ethernet
+// header, and so belong within an encap flow key when the packet is
+// VLAN tagged.
+var encapsulatedFlowKeyTypes = map[uint16]bool{
+	OVS_KEY_ATTR_ENCAP:     true,
+	OVS_KEY_ATTR_VLAN:      true,
+	OVS_KEY_ATTR_ETHERTYPE: true,
+	OVS_KEY_ATTR_IPV4:      true,
+	OVS_KEY_ATTR_IPV6:      true,
+	OVS_KEY_ATTR_TCP:       true,
+	OVS_KEY_ATTR_UDP:       true,
+	OVS_KEY_ATTR_ICMP:      true,
+	OVS_KEY_ATTR_ICMPV6:    true,
+	OVS_KEY_ATTR_ARP:       true,
+	OVS_KEY_ATTR_ND:        true,
+	OVS_KEY_ATTR_SCTP:      true,
+	OVS_KEY_ATTR_TCP_FLAGS: true,
+	OVS_KEY_ATTR_MPLS:      true,
+}
+
+// Match a VLAN tag with the given TPID (ETH_P_8021Q or ETH_P_8021AD)
+// outside of the packet described by the existing flow keys.  The
+// flow keys for the packet contents move into an encap flow key.
+// Calling AddVlan again adds an outer tag, so for a QinQ packet, add
+// the inner (customer) tag first.
+func (keys FlowKeys) AddVlan(tpid uint16, vlan VlanFlowKey) {
+	inner := make(FlowKeys)
+	for typ, k := range keys {
+		if encapsulatedFlowKeyTypes[typ] {
+			inner[typ] = k
+			delete(keys, typ)
+		}
+	}
+
+	keys.Add(NewEthertypeFlowKey(tpid))
+	keys.Add(vlan)
+	keys.Add(NewEncapFlowKey(inner))
+}
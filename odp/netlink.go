@@ -30,15 +30,6 @@ func OpenNetlinkSocket(protocol int) (*NetlinkSocket, error) {
 		}
 	}()
 
-	// It's fairly easy to provoke ENOBUFS from a netlink socket
-	// receiving miss upcalls when every packet misses.  The
-	// default socket buffer size is relatively small at 200KB,
-	// and the default of /proc/sys/net/core/rmem_max means we
-	// can't easily increase it.
-	if err := syscall.SetsockoptInt(fd, SOL_NETLINK, syscall.NETLINK_NO_ENOBUFS, 1); err != nil {
-		return nil, err
-	}
-
 	addr := syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}
 	if err := syscall.Bind(fd, &addr); err != nil {
 		return nil, err
@@ -675,7 +666,11 @@ type Consumer interface {
 	Error(err error, stopped bool)
 }
 
-func (s *NetlinkSocket) consume(consumer Consumer, handler func(*NlMsgParser) error) {
+// Receive messages until an error occurs.  If overrun is non-nil,
+// ENOBUFS (meaning the kernel dropped messages because the socket's
+// receive buffer was full) is not fatal: overrun is called to recover
+// from the lost messages, and receiving continues.
+func (s *NetlinkSocket) consume(consumer Consumer, overrun func() error, handler func(*NlMsgParser) error) {
 	for {
 		err := s.Receive(func(msg *NlMsgParser) (bool, error) {
 			err := msg.checkHeader()
@@ -690,6 +685,13 @@ func (s *NetlinkSocket) consume(consumer Consumer, handler func(*NlMsgParser) er
 			return false, nil
 		})
 
+		if err == syscall.ENOBUFS && overrun != nil {
+			if err := overrun(); err != nil {
+				consumer.Error(err, false)
+			}
+			continue
+		}
+
 		if err != nil {
 			consumer.Error(err, true)
 			break
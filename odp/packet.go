@@ -1,7 +1,9 @@
 package odp
 
 import (
+	"fmt"
 	"sync"
+	"syscall"
 )
 
 type MissConsumer interface {
@@ -9,7 +11,31 @@ type MissConsumer interface {
 	Error(err error, stopped bool)
 }
 
-func (origDP DatapathHandle) ConsumeMisses(consumer MissConsumer) (Cancelable, error) {
+// A MissConsumer that also implements ActionUpcallConsumer receives
+// the packets sent to userspace by UserspaceActions, along with their
+// userdata.  Otherwise such packets are discarded.
+type ActionUpcallConsumer interface {
+	MissConsumer
+	ActionUpcall(packet []byte, flowKeys FlowKeys, userdata []byte) error
+}
+
+// Returned by ConsumeMisses.  As well as canceling, this provides the
+// netlink port ID that UserspaceActions should use to send packets to
+// the consumer.
+type UpcallCancelable interface {
+	Cancelable
+	UpcallPortId() uint32
+}
+
+type cancelableUpcalls struct {
+	cancelableDpif
+}
+
+func (c cancelableUpcalls) UpcallPortId() uint32 {
+	return c.sock.PortId()
+}
+
+func (origDP DatapathHandle) ConsumeMisses(consumer MissConsumer) (UpcallCancelable, error) {
 	// We end up needing 3 netlink sockets: one to consume
 	// misses, one to consume vport events, and one for general
 	// use.
@@ -36,6 +62,16 @@ func (origDP DatapathHandle) ConsumeMisses(consumer MissConsumer) (Cancelable, e
 		}
 	}()
 
+	// It's fairly easy to provoke ENOBUFS from a netlink socket
+	// receiving miss upcalls when every packet misses.  The
+	// default socket buffer size is relatively small at 200KB,
+	// and the default of /proc/sys/net/core/rmem_max means we
+	// can't easily increase it.
+	err = syscall.SetsockoptInt(missDP.dpif.sock.fd, SOL_NETLINK, syscall.NETLINK_NO_ENOBUFS, 1)
+	if err != nil {
+		return nil, err
+	}
+
 	// We need to set the upcall port ID on all vports.  That
 	// includes vports that get added while we are listening, so
 	// we need to listen for them too.
@@ -72,7 +108,7 @@ func (origDP DatapathHandle) ConsumeMisses(consumer MissConsumer) (Cancelable, e
 	success = true
 	vportConsumer.cancel = vportCancel
 	go missDP.consumeMisses(consumer, vportConsumer)
-	return cancelableDpif{missDP.dpif}, nil
+	return cancelableUpcalls{cancelableDpif{missDP.dpif}}, nil
 }
 
 type missVportConsumer struct {
@@ -109,6 +145,10 @@ func (c *missVportConsumer) VportCreated(dpid DatapathID, vport Vport) error {
 	return c.setVportUpcallPortId(vport.ID)
 }
 
+func (c *missVportConsumer) VportChanged(dpid DatapathID, vport Vport) error {
+	return nil
+}
+
 func (c *missVportConsumer) VportDeleted(dpid DatapathID, vport Vport) error {
 	c.lock.Lock()
 	delete(c.vportsDone, vport.ID)
@@ -121,11 +161,16 @@ func (c *missVportConsumer) Error(err error, stopped bool) {
 }
 
 func (dp DatapathHandle) consumeMisses(consumer MissConsumer, vportConsumer *missVportConsumer) {
-	dp.dpif.sock.consume(consumer, func(msg *NlMsgParser) error {
-		if err := dp.checkNlMsgHeaders(msg, PACKET, OVS_PACKET_CMD_MISS); err != nil {
+	dp.dpif.sock.consume(consumer, nil, func(msg *NlMsgParser) error {
+		genlhdr, err := dp.checkGenlMsgHeaders(msg, PACKET, -1)
+		if err != nil {
 			return err
 		}
 
+		if genlhdr.Cmd != OVS_PACKET_CMD_MISS && genlhdr.Cmd != OVS_PACKET_CMD_ACTION {
+			return fmt.Errorf("unexpected packet upcall cmd %d", genlhdr.Cmd)
+		}
+
 		attrs, err := msg.TakeAttrs()
 		if err != nil {
 			return err
@@ -141,7 +186,17 @@ func (dp DatapathHandle) consumeMisses(consumer MissConsumer, vportConsumer *mis
 			return err
 		}
 
-		return consumer.Miss(attrs[OVS_PACKET_ATTR_PACKET], fks)
+		if genlhdr.Cmd == OVS_PACKET_CMD_MISS {
+			return consumer.Miss(attrs[OVS_PACKET_ATTR_PACKET], fks)
+		}
+
+		auc, ok := consumer.(ActionUpcallConsumer)
+		if !ok {
+			return nil
+		}
+
+		return auc.ActionUpcall(attrs[OVS_PACKET_ATTR_PACKET], fks,
+			attrs[OVS_PACKET_ATTR_USERDATA])
 	})
 
 	vportConsumer.cancel.Cancel()
@@ -163,9 +218,7 @@ func (dp DatapathHandle) Execute(packet []byte, keys FlowKeys, actions []Action)
 	})
 
 	req.PutNestedAttrs(OVS_PACKET_ATTR_ACTIONS, func() {
-		for _, a := range actions {
-			a.toNlAttr(req)
-		}
+		putActionsNlAttrs(req, actions)
 	})
 
 	_, err := dpif.sock.send(req)
@@ -0,0 +1,65 @@
+package odp
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Recirculation ids are a datapath-wide namespace, but the kernel
+// does not allocate them.  So we keep track of the ids in use by
+// this process for each datapath.  Id 0 is reserved for packets
+// that have not been recirculated.
+
+type recircIds struct {
+	next uint32
+	used map[uint32]struct{}
+}
+
+var recircIdsLock sync.Mutex
+var recircIdsByDatapath = make(map[DatapathID]*recircIds)
+
+// Allocate a recirculation id that is not in use by other callers in
+// this process for the same datapath.
+func (dp DatapathHandle) AllocateRecircId() (uint32, error) {
+	recircIdsLock.Lock()
+	defer recircIdsLock.Unlock()
+
+	ids := recircIdsByDatapath[dp.ifindex]
+	if ids == nil {
+		ids = &recircIds{next: 1, used: make(map[uint32]struct{})}
+		recircIdsByDatapath[dp.ifindex] = ids
+	}
+
+	for start := ids.next; ; {
+		id := ids.next
+		ids.next++
+		if ids.next == 0 {
+			ids.next = 1
+		}
+
+		if _, inUse := ids.used[id]; !inUse {
+			ids.used[id] = struct{}{}
+			return id, nil
+		}
+
+		if ids.next == start {
+			return 0, fmt.Errorf("no free recirculation ids for datapath %d", dp.ifindex)
+		}
+	}
+}
+
+// Release a recirculation id obtained from AllocateRecircId.
+func (dp DatapathHandle) FreeRecircId(id uint32) {
+	recircIdsLock.Lock()
+	defer recircIdsLock.Unlock()
+
+	if ids := recircIdsByDatapath[dp.ifindex]; ids != nil {
+		delete(ids.used, id)
+	}
+}
+
+func forgetRecircIds(id DatapathID) {
+	recircIdsLock.Lock()
+	defer recircIdsLock.Unlock()
+	delete(recircIdsByDatapath, id)
+}
@@ -0,0 +1,160 @@
+package odp
+
+import (
+	"syscall"
+)
+
+// Rtnl provides the small subset of rtnetlink needed to set up
+// network devices for use with a datapath, so that callers don't
+// need to resort to "ip link".
+type Rtnl struct {
+	sock *NetlinkSocket
+}
+
+func NewRtnl() (*Rtnl, error) {
+	sock, err := OpenNetlinkSocket(syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Rtnl{sock: sock}, nil
+}
+
+func (rtnl *Rtnl) Close() error {
+	return rtnl.sock.Close()
+}
+
+// rtnetlink doesn't reliably honour NLM_F_ECHO for link operations,
+// so ask for an ack instead.
+const rtnlRequestFlags = syscall.NLM_F_REQUEST | syscall.NLM_F_ACK
+
+func (nlmsg *NlMsgBuilder) putIfInfomsg(flags uint32, change uint32) {
+	pos := nlmsg.AlignGrow(syscall.NLMSG_ALIGNTO, syscall.SizeofIfInfomsg)
+	ifi := ifInfomsgAt(nlmsg.buf, pos)
+	ifi.Family = syscall.AF_UNSPEC
+	ifi.Flags = flags
+	ifi.Change = change
+}
+
+// Links are identified by name rather than ifindex, which the kernel
+// supports for all the operations used here.
+func newLinkRequest(typ uint16, flags uint16, name string) *NlMsgBuilder {
+	req := NewNlMsgBuilder(flags, typ)
+	req.putIfInfomsg(0, 0)
+	req.PutStringAttr(syscall.IFLA_IFNAME, name)
+	return req
+}
+
+func (rtnl *Rtnl) CreateVethPair(name string, peer string) error {
+	req := newLinkRequest(syscall.RTM_NEWLINK,
+		rtnlRequestFlags|syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, name)
+	req.PutNestedAttrs(syscall.IFLA_LINKINFO, func() {
+		req.PutStringAttr(IFLA_INFO_KIND, "veth")
+		req.PutNestedAttrs(IFLA_INFO_DATA, func() {
+			// The peer is described by an ifinfomsg
+			// followed by its own link attributes
+			req.PutNestedAttrs(VETH_INFO_PEER, func() {
+				req.putIfInfomsg(0, 0)
+				req.PutStringAttr(syscall.IFLA_IFNAME, peer)
+			})
+		})
+	})
+
+	_, err := rtnl.sock.Request(req)
+	return err
+}
+
+func IsLinkNameAlreadyExistsError(err error) bool {
+	return err == NetlinkError(syscall.EEXIST)
+}
+
+func IsNoSuchLinkError(err error) bool {
+	return err == NetlinkError(syscall.ENODEV)
+}
+
+// Deleting one end of a veth pair also deletes the other end.
+func (rtnl *Rtnl) DeleteLink(name string) error {
+	_, err := rtnl.sock.Request(newLinkRequest(syscall.RTM_DELLINK,
+		rtnlRequestFlags, name))
+	return err
+}
+
+func (rtnl *Rtnl) setLinkFlags(name string, flags uint32, change uint32) error {
+	req := NewNlMsgBuilder(rtnlRequestFlags, syscall.RTM_SETLINK)
+	req.putIfInfomsg(flags, change)
+	req.PutStringAttr(syscall.IFLA_IFNAME, name)
+
+	_, err := rtnl.sock.Request(req)
+	return err
+}
+
+func (rtnl *Rtnl) SetLinkUp(name string) error {
+	return rtnl.setLinkFlags(name, syscall.IFF_UP, syscall.IFF_UP)
+}
+
+func (rtnl *Rtnl) SetLinkDown(name string) error {
+	return rtnl.setLinkFlags(name, 0, syscall.IFF_UP)
+}
+
+// Internal vports appear as network devices with the same name as the
+// vport, so this and SetLinkMacAddr can be used to configure them.
+func (rtnl *Rtnl) SetLinkMtu(name string, mtu uint32) error {
+	req := newLinkRequest(syscall.RTM_SETLINK, rtnlRequestFlags, name)
+	req.PutUint32Attr(syscall.IFLA_MTU, mtu)
+
+	_, err := rtnl.sock.Request(req)
+	return err
+}
+
+func (rtnl *Rtnl) SetLinkMacAddr(name string, addr [ETH_ALEN]byte) error {
+	req := newLinkRequest(syscall.RTM_SETLINK, rtnlRequestFlags, name)
+	req.PutSliceAttr(syscall.IFLA_ADDRESS, addr[:])
+
+	_, err := rtnl.sock.Request(req)
+	return err
+}
+
+// Create a veth pair, bring both ends up, and attach the first end to
+// the datapath as a netdev vport.  The peer end is left for the
+// caller to use (e.g. by moving it into a container's network
+// namespace).
+func (dp DatapathHandle) CreateVethVport(name string, peer string) (VportID, error) {
+	return dp.CreateVethVportWithID(name, peer, 0)
+}
+
+// Like CreateVethVport, but with a specific port number, as for
+// CreateVportWithID.
+func (dp DatapathHandle) CreateVethVportWithID(name string, peer string, id VportID) (VportID, error) {
+	rtnl, err := NewRtnl()
+	if err != nil {
+		return 0, err
+	}
+	defer rtnl.Close()
+
+	if err := rtnl.CreateVethPair(name, peer); err != nil {
+		return 0, err
+	}
+
+	success := false
+	defer func() {
+		if !success {
+			rtnl.DeleteLink(name)
+		}
+	}()
+
+	if err := rtnl.SetLinkUp(name); err != nil {
+		return 0, err
+	}
+
+	if err := rtnl.SetLinkUp(peer); err != nil {
+		return 0, err
+	}
+
+	id, err = dp.CreateVportWithID(NewNetdevVportSpec(name), id)
+	if err != nil {
+		return 0, err
+	}
+
+	success = true
+	return id, nil
+}
@@ -103,6 +103,10 @@ const ( // ovs_vport_attr
 	OVS_VPORT_ATTR_STATS      = 6
 )
 
+// The port number of a datapath's local vport, which has the same
+// name as the datapath
+const OVSP_LOCAL = 0
+
 const ( // ovs_vport_type
 	OVS_VPORT_TYPE_UNSPEC   = 0
 	OVS_VPORT_TYPE_NETDEV   = 1
@@ -112,6 +116,17 @@ const ( // ovs_vport_type
 	OVS_VPORT_TYPE_GENEVE   = 5
 )
 
+// Vport types that are only defined by the out-of-tree openvswitch
+// kernel module
+const (
+	OVS_VPORT_TYPE_LISP      = 105
+	OVS_VPORT_TYPE_STT       = 106
+	OVS_VPORT_TYPE_ERSPAN    = 107
+	OVS_VPORT_TYPE_IP6ERSPAN = 108
+	OVS_VPORT_TYPE_IP6GRE    = 109
+	OVS_VPORT_TYPE_GTPU      = 110
+)
+
 const ( // OVS_VPORT_ATTR_OPTIONS attributes for tunnels
 	OVS_TUNNEL_ATTR_UNSPEC   = 0
 	OVS_TUNNEL_ATTR_DST_PORT = 1
@@ -165,6 +180,14 @@ const ( // ovs_key_attr
 	OVS_KEY_ATTR_TCP_FLAGS = 18
 	OVS_KEY_ATTR_DP_HASH   = 19
 	OVS_KEY_ATTR_RECIRC_ID = 20
+	OVS_KEY_ATTR_MPLS      = 21
+	OVS_KEY_ATTR_CT_STATE  = 22
+	OVS_KEY_ATTR_CT_ZONE   = 23
+	OVS_KEY_ATTR_CT_MARK   = 24
+	OVS_KEY_ATTR_CT_LABELS = 25
+
+	OVS_KEY_ATTR_CT_ORIG_TUPLE_IPV4 = 26
+	OVS_KEY_ATTR_CT_ORIG_TUPLE_IPV6 = 27
 )
 
 const ( // ovs_tunnel_key_attr
@@ -186,6 +209,40 @@ const ( // ovs_tunnel_key_attr
 
 const ETH_ALEN = 6
 
+// VLAN tag protocol identifiers
+const (
+	ETH_P_8021Q  = 0x8100
+	ETH_P_8021AD = 0x88a8
+)
+
+// Fields of the VLAN TCI.  The kernel uses the CFI bit to indicate
+// that a VLAN tag is present.
+const (
+	VLAN_PCP_MASK  = 0xe000
+	VLAN_PCP_SHIFT = 13
+	VLAN_CFI_MASK  = 0x1000
+	VLAN_VID_MASK  = 0x0fff
+)
+
+// MPLS ethertypes
+const (
+	ETH_P_MPLS_UC = 0x8847
+	ETH_P_MPLS_MC = 0x8848
+)
+
+// Fields of an MPLS label stack entry
+const (
+	MPLS_LS_LABEL_MASK  = 0xfffff000
+	MPLS_LS_LABEL_SHIFT = 12
+	MPLS_LS_TC_MASK     = 0x00000e00
+	MPLS_LS_TC_SHIFT    = 9
+	MPLS_LS_S_MASK      = 0x00000100
+	MPLS_LS_TTL_MASK    = 0x000000ff
+)
+
+// The kernel's struct ovs_key_mpls is a single label stack entry
+const SizeofOvsKeyMpls = 4
+
 type OvsKeyEthernet struct {
 	EthSrc [ETH_ALEN]byte
 	EthDst [ETH_ALEN]byte
@@ -193,14 +250,182 @@ type OvsKeyEthernet struct {
 
 const SizeofOvsKeyEthernet = 12
 
+type OvsKeyIpv4 struct {
+	Ipv4Src   [4]byte
+	Ipv4Dst   [4]byte
+	Ipv4Proto uint8
+	Ipv4Tos   uint8
+	Ipv4Ttl   uint8
+	Ipv4Frag  uint8
+}
+
+const SizeofOvsKeyIpv4 = 12
+
+type OvsKeyIpv6 struct {
+	Ipv6Src    [16]byte
+	Ipv6Dst    [16]byte
+	Ipv6Label  uint32 // big-endian, 20 bits in the least-significant bits
+	Ipv6Proto  uint8
+	Ipv6Tclass uint8
+	Ipv6Hlimit uint8
+	Ipv6Frag   uint8
+}
+
+const SizeofOvsKeyIpv6 = 40
+
+type OvsKeyIcmp struct {
+	IcmpType uint8
+	IcmpCode uint8
+}
+
+const SizeofOvsKeyIcmp = 2
+
+type OvsKeyArp struct {
+	ArpSip [4]byte
+	ArpTip [4]byte
+	ArpOp  uint16 // big-endian
+	ArpSha [ETH_ALEN]byte
+	ArpTha [ETH_ALEN]byte
+	_      [2]byte
+}
+
+const SizeofOvsKeyArp = 24
+
+type OvsKeyNd struct {
+	NdTarget [16]byte
+	NdSll    [ETH_ALEN]byte
+	NdTll    [ETH_ALEN]byte
+}
+
+const SizeofOvsKeyNd = 28
+
+const ( // ovs_ct_state flags
+	OVS_CS_F_NEW         = 0x01
+	OVS_CS_F_ESTABLISHED = 0x02
+	OVS_CS_F_RELATED     = 0x04
+	OVS_CS_F_REPLY_DIR   = 0x08
+	OVS_CS_F_INVALID     = 0x10
+	OVS_CS_F_TRACKED     = 0x20
+	OVS_CS_F_SRC_NAT     = 0x40
+	OVS_CS_F_DST_NAT     = 0x80
+)
+
+const OVS_CT_LABELS_LEN = 16
+
+type OvsKeyCtTupleIpv4 struct {
+	Ipv4Src   [4]byte
+	Ipv4Dst   [4]byte
+	SrcPort   uint16 // big-endian
+	DstPort   uint16 // big-endian
+	Ipv4Proto uint8
+	_         [3]byte
+}
+
+const SizeofOvsKeyCtTupleIpv4 = 16
+
+type OvsKeyCtTupleIpv6 struct {
+	Ipv6Src   [16]byte
+	Ipv6Dst   [16]byte
+	SrcPort   uint16 // big-endian
+	DstPort   uint16 // big-endian
+	Ipv6Proto uint8
+	_         [3]byte
+}
+
+const SizeofOvsKeyCtTupleIpv6 = 40
+
+const ( // ovs_frag_type
+	OVS_FRAG_TYPE_NONE  = 0
+	OVS_FRAG_TYPE_FIRST = 1
+	OVS_FRAG_TYPE_LATER = 2
+)
+
 const ( // ovs_action_attr
-	OVS_ACTION_ATTR_UNSPEC    = 0
-	OVS_ACTION_ATTR_OUTPUT    = 1
-	OVS_ACTION_ATTR_USERSPACE = 2
-	OVS_ACTION_ATTR_SET       = 3
-	OVS_ACTION_ATTR_PUSH_VLAN = 4
-	OVS_ACTION_ATTR_POP_VLAN  = 5
-	OVS_ACTION_ATTR_SAMPLE    = 6
+	OVS_ACTION_ATTR_UNSPEC        = 0
+	OVS_ACTION_ATTR_OUTPUT        = 1
+	OVS_ACTION_ATTR_USERSPACE     = 2
+	OVS_ACTION_ATTR_SET           = 3
+	OVS_ACTION_ATTR_PUSH_VLAN     = 4
+	OVS_ACTION_ATTR_POP_VLAN      = 5
+	OVS_ACTION_ATTR_SAMPLE        = 6
+	OVS_ACTION_ATTR_RECIRC        = 7
+	OVS_ACTION_ATTR_HASH          = 8
+	OVS_ACTION_ATTR_PUSH_MPLS     = 9
+	OVS_ACTION_ATTR_POP_MPLS      = 10
+	OVS_ACTION_ATTR_SET_MASKED    = 11
+	OVS_ACTION_ATTR_CT            = 12
+	OVS_ACTION_ATTR_TRUNC         = 13
+	OVS_ACTION_ATTR_PUSH_ETH      = 14
+	OVS_ACTION_ATTR_POP_ETH       = 15
+	OVS_ACTION_ATTR_CT_CLEAR      = 16
+	OVS_ACTION_ATTR_PUSH_NSH      = 17
+	OVS_ACTION_ATTR_POP_NSH       = 18
+	OVS_ACTION_ATTR_METER         = 19
+	OVS_ACTION_ATTR_CLONE         = 20
+	OVS_ACTION_ATTR_CHECK_PKT_LEN = 21
+	OVS_ACTION_ATTR_ADD_MPLS      = 22
+	OVS_ACTION_ATTR_DEC_TTL       = 23
+	OVS_ACTION_ATTR_DROP          = 24
+)
+
+const SizeofOvsActionTrunc = 4
+const SizeofOvsActionPushEth = SizeofOvsKeyEthernet
+
+const ( // ovs_check_pkt_len_attr
+	OVS_CHECK_PKT_LEN_ATTR_UNSPEC                = 0
+	OVS_CHECK_PKT_LEN_ATTR_PKT_LEN               = 1
+	OVS_CHECK_PKT_LEN_ATTR_ACTIONS_IF_GREATER    = 2
+	OVS_CHECK_PKT_LEN_ATTR_ACTIONS_IF_LESS_EQUAL = 3
+)
+
+const ( // ovs_dec_ttl_attr
+	OVS_DEC_TTL_ATTR_UNSPEC = 0
+	OVS_DEC_TTL_ATTR_ACTION = 1
+)
+
+const ( // ovs_hash_alg
+	OVS_HASH_ALG_L4     = 0
+	OVS_HASH_ALG_SYM_L4 = 1
+)
+
+const ( // ovs_sample_attr
+	OVS_SAMPLE_ATTR_UNSPEC      = 0
+	OVS_SAMPLE_ATTR_PROBABILITY = 1
+	OVS_SAMPLE_ATTR_ACTIONS     = 2
+)
+
+const ( // ovs_userspace_attr
+	OVS_USERSPACE_ATTR_UNSPEC          = 0
+	OVS_USERSPACE_ATTR_PID             = 1
+	OVS_USERSPACE_ATTR_USERDATA        = 2
+	OVS_USERSPACE_ATTR_EGRESS_TUN_PORT = 3
+	OVS_USERSPACE_ATTR_ACTIONS         = 4
+)
+
+const ( // ovs_ct_attr
+	OVS_CT_ATTR_UNSPEC       = 0
+	OVS_CT_ATTR_COMMIT       = 1
+	OVS_CT_ATTR_ZONE         = 2
+	OVS_CT_ATTR_MARK         = 3
+	OVS_CT_ATTR_LABELS       = 4
+	OVS_CT_ATTR_HELPER       = 5
+	OVS_CT_ATTR_NAT          = 6
+	OVS_CT_ATTR_FORCE_COMMIT = 7
+	OVS_CT_ATTR_EVENTMASK    = 8
+	OVS_CT_ATTR_TIMEOUT      = 9
+)
+
+const ( // ovs_nat_attr
+	OVS_NAT_ATTR_UNSPEC       = 0
+	OVS_NAT_ATTR_SRC          = 1
+	OVS_NAT_ATTR_DST          = 2
+	OVS_NAT_ATTR_IP_MIN       = 3
+	OVS_NAT_ATTR_IP_MAX       = 4
+	OVS_NAT_ATTR_PROTO_MIN    = 5
+	OVS_NAT_ATTR_PROTO_MAX    = 6
+	OVS_NAT_ATTR_PERSISTENT   = 7
+	OVS_NAT_ATTR_PROTO_HASH   = 8
+	OVS_NAT_ATTR_PROTO_RANDOM = 9
 )
 
 const ( // ovs_packet_cmd
@@ -218,6 +443,19 @@ const ( // ovs_packet_attr
 	OVS_PACKET_ATTR_USERDATA = 4
 )
 
+// from linux/include/uapi/linux/if_link.h
+const (
+	IFLA_INFO_UNSPEC = 0
+	IFLA_INFO_KIND   = 1
+	IFLA_INFO_DATA   = 2
+)
+
+// from linux/include/uapi/linux/veth.h
+const (
+	VETH_INFO_UNSPEC = 0
+	VETH_INFO_PEER   = 1
+)
+
 type ifreqIfindex struct {
 	name    [syscall.IFNAMSIZ]byte
 	ifindex int32
@@ -56,6 +56,10 @@ func nlMsgerrAt(data []byte, pos int) *syscall.NlMsgerr {
 	return (*syscall.NlMsgerr)(unsafe.Pointer(&data[pos]))
 }
 
+func ifInfomsgAt(data []byte, pos int) *syscall.IfInfomsg {
+	return (*syscall.IfInfomsg)(unsafe.Pointer(&data[pos]))
+}
+
 func genlMsghdrAt(data []byte, pos int) *GenlMsghdr {
 	return (*GenlMsghdr)(unsafe.Pointer(&data[pos]))
 }
@@ -68,6 +72,34 @@ func ovsKeyEthernetAt(data []byte, pos int) *OvsKeyEthernet {
 	return (*OvsKeyEthernet)(unsafe.Pointer(&data[pos]))
 }
 
+func ovsKeyIpv4At(data []byte, pos int) *OvsKeyIpv4 {
+	return (*OvsKeyIpv4)(unsafe.Pointer(&data[pos]))
+}
+
+func ovsKeyIpv6At(data []byte, pos int) *OvsKeyIpv6 {
+	return (*OvsKeyIpv6)(unsafe.Pointer(&data[pos]))
+}
+
+func ovsKeyIcmpAt(data []byte, pos int) *OvsKeyIcmp {
+	return (*OvsKeyIcmp)(unsafe.Pointer(&data[pos]))
+}
+
+func ovsKeyArpAt(data []byte, pos int) *OvsKeyArp {
+	return (*OvsKeyArp)(unsafe.Pointer(&data[pos]))
+}
+
+func ovsKeyNdAt(data []byte, pos int) *OvsKeyNd {
+	return (*OvsKeyNd)(unsafe.Pointer(&data[pos]))
+}
+
+func ovsKeyCtTupleIpv4At(data []byte, pos int) *OvsKeyCtTupleIpv4 {
+	return (*OvsKeyCtTupleIpv4)(unsafe.Pointer(&data[pos]))
+}
+
+func ovsKeyCtTupleIpv6At(data []byte, pos int) *OvsKeyCtTupleIpv6 {
+	return (*OvsKeyCtTupleIpv6)(unsafe.Pointer(&data[pos]))
+}
+
 func ovsFlowStatsAt(data []byte, pos int) *OvsFlowStats {
 	return (*OvsFlowStats)(unsafe.Pointer(&data[pos]))
 }
@@ -80,3 +112,12 @@ func uint16FromBE(n uint16) uint16 {
 func uint16ToBE(n uint16) uint16 {
 	return uint16FromBE(n)
 }
+
+func uint32FromBE(n uint32) uint32 {
+	a := (*[4]byte)(unsafe.Pointer(&n))
+	return uint32(a[0])<<24 + uint32(a[1])<<16 + uint32(a[2])<<8 + uint32(a[3])
+}
+
+func uint32ToBE(n uint32) uint32 {
+	return uint32FromBE(n)
+}
@@ -2,20 +2,34 @@ package odp
 
 import (
 	"fmt"
+	"reflect"
+	"sort"
+	"sync"
 	"syscall"
 )
 
+// A VportSpec describes a vport.  Applications can support vport
+// types that go-odp doesn't know about by implementing this
+// interface and registering the type with RegisterVportType.
 type VportSpec interface {
 	TypeName() string
 	Name() string
-	typeId() uint32
-	optionNlAttrs(req *NlMsgBuilder)
+
+	// The OVS_VPORT_TYPE_* value
+	TypeId() uint32
+
+	// Emit the contents of the OVS_VPORT_ATTR_OPTIONS attribute
+	OptionNlAttrs(req *NlMsgBuilder)
 }
 
 type VportSpecBase struct {
 	name string
 }
 
+func NewVportSpecBase(name string) VportSpecBase {
+	return VportSpecBase{name}
+}
+
 func (v VportSpecBase) Name() string {
 	return v.name
 }
@@ -30,11 +44,11 @@ func (s SimpleVportSpec) TypeName() string {
 	return s.typeName
 }
 
-func (s SimpleVportSpec) typeId() uint32 {
+func (s SimpleVportSpec) TypeId() uint32 {
 	return s.typ
 }
 
-func (SimpleVportSpec) optionNlAttrs(req *NlMsgBuilder) {
+func (SimpleVportSpec) OptionNlAttrs(req *NlMsgBuilder) {
 }
 
 func NewNetdevVportSpec(name string) VportSpec {
@@ -63,11 +77,11 @@ func (GreVportSpec) TypeName() string {
 	return "gre"
 }
 
-func (GreVportSpec) typeId() uint32 {
+func (GreVportSpec) TypeId() uint32 {
 	return OVS_VPORT_TYPE_GRE
 }
 
-func (v GreVportSpec) optionNlAttrs(req *NlMsgBuilder) {
+func (v GreVportSpec) OptionNlAttrs(req *NlMsgBuilder) {
 }
 
 func NewGreVportSpec(name string) VportSpec {
@@ -81,7 +95,7 @@ type udpVportSpec struct {
 	Port uint16
 }
 
-func (v udpVportSpec) optionNlAttrs(req *NlMsgBuilder) {
+func (v udpVportSpec) OptionNlAttrs(req *NlMsgBuilder) {
 	req.PutUint16Attr(OVS_TUNNEL_ATTR_DST_PORT, v.Port)
 }
 
@@ -102,7 +116,7 @@ func (VxlanVportSpec) TypeName() string {
 	return "vxlan"
 }
 
-func (VxlanVportSpec) typeId() uint32 {
+func (VxlanVportSpec) TypeId() uint32 {
 	return OVS_VPORT_TYPE_VXLAN
 }
 
@@ -120,7 +134,7 @@ func (GeneveVportSpec) TypeName() string {
 	return "geneve"
 }
 
-func (GeneveVportSpec) typeId() uint32 {
+func (GeneveVportSpec) TypeId() uint32 {
 	return OVS_VPORT_TYPE_GENEVE
 }
 
@@ -128,6 +142,181 @@ func NewGeneveVportSpec(name string, port uint16) VportSpec {
 	return GeneveVportSpec{udpVportSpec{VportSpecBase{name}, port}}
 }
 
+// Vports of types we don't otherwise support.  The options are kept
+// as raw attributes, so that such vports can be listed, and recreated
+// with CreateVport.
+
+type UnknownVportSpec struct {
+	VportSpecBase
+	Type    uint32
+	Options Attrs
+}
+
+var unknownVportTypeNames = map[uint32]string{
+	OVS_VPORT_TYPE_LISP:      "lisp",
+	OVS_VPORT_TYPE_STT:       "stt",
+	OVS_VPORT_TYPE_ERSPAN:    "erspan",
+	OVS_VPORT_TYPE_IP6ERSPAN: "ip6erspan",
+	OVS_VPORT_TYPE_IP6GRE:    "ip6gre",
+	OVS_VPORT_TYPE_GTPU:      "gtpu",
+}
+
+func (v UnknownVportSpec) TypeName() string {
+	if name, ok := unknownVportTypeNames[v.Type]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("type%d", v.Type)
+}
+
+func (v UnknownVportSpec) TypeId() uint32 {
+	return v.Type
+}
+
+func (v UnknownVportSpec) OptionNlAttrs(req *NlMsgBuilder) {
+	// Emit the attributes in a consistent order
+	typs := make([]int, 0, len(v.Options))
+	for typ := range v.Options {
+		typs = append(typs, int(typ))
+	}
+	sort.Ints(typs)
+
+	for _, typ := range typs {
+		req.PutSliceAttr(uint16(typ), v.Options[uint16(typ)])
+	}
+}
+
+func NewUnknownVportSpec(name string, typ uint32, opts Attrs) VportSpec {
+	if opts == nil {
+		opts = make(Attrs)
+	}
+
+	return UnknownVportSpec{VportSpecBase{name}, typ, opts}
+}
+
+// The options of a vport spec, as they would be sent to the kernel
+func VportSpecOptions(spec VportSpec) (Attrs, error) {
+	req := NewNlMsgBuilder(0, 0)
+	req.PutNestedAttrs(OVS_VPORT_ATTR_OPTIONS, func() {
+		spec.OptionNlAttrs(req)
+	})
+
+	attrs, err := ParseNestedAttrs(req.buf[syscall.NLMSG_HDRLEN:])
+	if err != nil {
+		return nil, err
+	}
+
+	return attrs.GetNestedAttrs(OVS_VPORT_ATTR_OPTIONS, false)
+}
+
+// Vport type registration
+
+type VportType struct {
+	// The OVS_VPORT_TYPE_* value
+	ID uint32
+
+	// The name returned by the TypeName method of the VportSpec
+	Name string
+
+	// Produce a VportSpec from a vport's name and the contents of
+	// its OVS_VPORT_ATTR_OPTIONS attribute
+	Parse func(name string, opts Attrs) (VportSpec, error)
+}
+
+var vportTypesLock sync.RWMutex
+
+var vportTypes = map[uint32]VportType{}
+
+func init() {
+	simple := func(f func(string) VportSpec) func(string, Attrs) (VportSpec, error) {
+		return func(name string, opts Attrs) (VportSpec, error) {
+			return f(name), nil
+		}
+	}
+
+	builtins := []VportType{
+		{OVS_VPORT_TYPE_NETDEV, "netdev", simple(NewNetdevVportSpec)},
+		{OVS_VPORT_TYPE_INTERNAL, "internal", simple(NewInternalVportSpec)},
+		{OVS_VPORT_TYPE_GRE, "gre", simple(NewGreVportSpec)},
+		{OVS_VPORT_TYPE_VXLAN, "vxlan",
+			func(name string, opts Attrs) (VportSpec, error) {
+				u, err := parseUdpVportSpec(name, opts)
+				return VxlanVportSpec{u}, err
+			}},
+		{OVS_VPORT_TYPE_GENEVE, "geneve",
+			func(name string, opts Attrs) (VportSpec, error) {
+				u, err := parseUdpVportSpec(name, opts)
+				return GeneveVportSpec{u}, err
+			}},
+	}
+
+	for _, t := range builtins {
+		if err := RegisterVportType(t); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Register a vport type, so that vports of that type are parsed into
+// the application's VportSpec implementation rather than an
+// UnknownVportSpec.  This is intended to be called from init
+// functions.
+func RegisterVportType(t VportType) error {
+	if t.Name == "" || t.Parse == nil {
+		return fmt.Errorf("incomplete vport type %d", t.ID)
+	}
+
+	vportTypesLock.Lock()
+	defer vportTypesLock.Unlock()
+
+	for _, other := range vportTypes {
+		if other.ID == t.ID {
+			return fmt.Errorf("vport type %d is already registered as \"%s\"", t.ID, other.Name)
+		}
+
+		if other.Name == t.Name {
+			return fmt.Errorf("vport type name \"%s\" is already registered for type %d", t.Name, other.ID)
+		}
+	}
+
+	vportTypes[t.ID] = t
+	return nil
+}
+
+// The registered vport types, ordered by ID
+func VportTypes() []VportType {
+	vportTypesLock.RLock()
+	defer vportTypesLock.RUnlock()
+
+	res := make([]VportType, 0, len(vportTypes))
+	for _, t := range vportTypes {
+		res = append(res, t)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+func LookupVportTypeByName(name string) (VportType, bool) {
+	vportTypesLock.RLock()
+	defer vportTypesLock.RUnlock()
+
+	for _, t := range vportTypes {
+		if t.Name == name {
+			return t, true
+		}
+	}
+
+	return VportType{}, false
+}
+
+func lookupVportType(id uint32) (VportType, bool) {
+	vportTypesLock.RLock()
+	defer vportTypesLock.RUnlock()
+	t, ok := vportTypes[id]
+	return t, ok
+}
+
 // Vport numbers are scoped to a particular datapath
 type VportID uint32
 
@@ -162,45 +351,35 @@ func parseVport(msg *NlMsgParser) (id VportID, s VportSpec, err error) {
 		opts = make(Attrs)
 	}
 
-	switch typ {
-	case OVS_VPORT_TYPE_NETDEV:
-		s = NewNetdevVportSpec(name)
-
-	case OVS_VPORT_TYPE_INTERNAL:
-		s = NewInternalVportSpec(name)
-
-	case OVS_VPORT_TYPE_GRE:
-		s = NewGreVportSpec(name)
-
-	case OVS_VPORT_TYPE_VXLAN:
-		u, err := parseUdpVportSpec(name, opts)
-		if err == nil {
-			s = VxlanVportSpec{u}
-		}
-
-	case OVS_VPORT_TYPE_GENEVE:
-		u, err := parseUdpVportSpec(name, opts)
-		if err == nil {
-			s = GeneveVportSpec{u}
-		}
-
-	default:
-		err = fmt.Errorf("unsupported vport type %d", typ)
+	if t, ok := lookupVportType(typ); ok {
+		s, err = t.Parse(name, opts)
+	} else {
+		s = NewUnknownVportSpec(name, typ, opts)
 	}
 
 	return
 }
 
 func (dp DatapathHandle) CreateVport(spec VportSpec) (VportID, error) {
+	return dp.CreateVportWithID(spec, 0)
+}
+
+// Create a vport with the given port number.  If id is 0, the kernel
+// picks the port number (port 0 is always the datapath's local
+// vport).
+func (dp DatapathHandle) CreateVportWithID(spec VportSpec, id VportID) (VportID, error) {
 	dpif := dp.dpif
 
 	req := NewNlMsgBuilder(RequestFlags, dpif.families[VPORT].id)
 	req.PutGenlMsghdr(OVS_VPORT_CMD_NEW, OVS_VPORT_VERSION)
 	req.putOvsHeader(dp.ifindex)
+	if id != 0 {
+		req.PutUint32Attr(OVS_VPORT_ATTR_PORT_NO, uint32(id))
+	}
 	req.PutStringAttr(OVS_VPORT_ATTR_NAME, spec.Name())
-	req.PutUint32Attr(OVS_VPORT_ATTR_TYPE, spec.typeId())
+	req.PutUint32Attr(OVS_VPORT_ATTR_TYPE, spec.TypeId())
 	req.PutNestedAttrs(OVS_VPORT_ATTR_OPTIONS, func() {
-		spec.optionNlAttrs(req)
+		spec.OptionNlAttrs(req)
 	})
 	req.PutUint32Attr(OVS_VPORT_ATTR_UPCALL_PID, 0)
 
@@ -214,7 +393,7 @@ func (dp DatapathHandle) CreateVport(spec VportSpec) (VportID, error) {
 		return 0, err
 	}
 
-	id, _, err := parseVport(resp)
+	id, _, err = parseVport(resp)
 	if err != nil {
 		return 0, err
 	}
@@ -226,6 +405,18 @@ func IsNoSuchVportError(err error) bool {
 	return err == NetlinkError(syscall.ENODEV)
 }
 
+// Returned by CreateVportWithID when another vport already has the
+// requested port number
+func IsVportIDInUseError(err error) bool {
+	return err == NetlinkError(syscall.EBUSY)
+}
+
+// Returned by CreateVportWithID when the requested port number
+// exceeds the kernel's limit
+func IsVportIDTooLargeError(err error) bool {
+	return err == NetlinkError(syscall.EFBIG)
+}
+
 type Vport struct {
 	ID   VportID
 	Spec VportSpec
@@ -360,11 +551,25 @@ type VportEventsConsumer interface {
 	Error(err error, stopped bool)
 }
 
+// A VportEventsConsumer that also implements VportChangeConsumer is
+// told when the options of an existing vport change.  Otherwise such
+// changes are not reported.
+type VportChangeConsumer interface {
+	VportEventsConsumer
+	VportChanged(dpid DatapathID, vport Vport) error
+}
+
 func (dpif *Dpif) ConsumeVportEvents(consumer VportEventsConsumer) (Cancelable, error) {
 	return DatapathHandle{dpif, -1}.ConsumeVportEvents(consumer)
 }
 
 func (dp DatapathHandle) ConsumeVportEvents(consumer VportEventsConsumer) (Cancelable, error) {
+	return dp.subscribeVportEvents(consumer, nil)
+}
+
+// If initial is non-nil, it is called with the vports that exist when
+// consuming starts, before any events are delivered.
+func (dp DatapathHandle) subscribeVportEvents(consumer VportEventsConsumer, initial func(map[DatapathID]map[VportID]Vport)) (Cancelable, error) {
 	mcGroup, err := dp.dpif.getMCGroup(VPORT, "ovs_vport")
 	if err != nil {
 		return nil, err
@@ -375,25 +580,54 @@ func (dp DatapathHandle) ConsumeVportEvents(consumer VportEventsConsumer) (Cance
 		return nil, err
 	}
 
+	success := false
+	defer func() {
+		if !success {
+			consumeDpif.Close()
+		}
+	}()
+
 	err = syscall.SetsockoptInt(consumeDpif.sock.fd, SOL_NETLINK, syscall.NETLINK_ADD_MEMBERSHIP, int(mcGroup))
 	if err != nil {
-		consumeDpif.Close()
 		return nil, err
 	}
 
-	go consumeDpif.consumeVportEvents(consumer, dp.ifindex)
+	tracker := &vportTracker{
+		dpif:     consumeDpif,
+		ifindex:  dp.ifindex,
+		consumer: consumer,
+		vports:   make(map[DatapathID]map[VportID]Vport),
+	}
+
+	if initial != nil {
+		// The snapshot is taken after joining the multicast
+		// group, so any later changes will be reported as
+		// events.  Events for changes that the snapshot
+		// already reflects are suppressed by the tracker.
+		vports, err := enumerateVportsByDatapath(dp.dpif, dp.ifindex)
+		if err != nil {
+			return nil, err
+		}
+
+		initial(vports)
+		tracker.vports = vports
+		tracker.complete = true
+	}
+
+	success = true
+	go consumeDpif.consumeVportEvents(tracker)
 	return cancelableDpif{consumeDpif}, nil
 }
 
-func (dpif *Dpif) consumeVportEvents(consumer VportEventsConsumer, ifindex DatapathID) {
-	dpif.sock.consume(consumer, func(msg *NlMsgParser) error {
+func (dpif *Dpif) consumeVportEvents(tracker *vportTracker) {
+	dpif.sock.consume(tracker.consumer, tracker.resync, func(msg *NlMsgParser) error {
 		genlhdr, ovshdr, err := dpif.checkNlMsgHeaders(msg, VPORT, -1)
 		if err != nil {
 			return err
 		}
 
 		// filter by ifindex, if consuming on a specific datapath
-		if ifindex >= 0 && ovshdr.datapathID() != ifindex {
+		if tracker.ifindex >= 0 && ovshdr.datapathID() != tracker.ifindex {
 			return nil
 		}
 
@@ -402,15 +636,198 @@ func (dpif *Dpif) consumeVportEvents(consumer VportEventsConsumer, ifindex Datap
 			return err
 		}
 
-		switch genlhdr.Cmd {
-		case OVS_VPORT_CMD_NEW:
-			return consumer.VportCreated(ovshdr.datapathID(), Vport{id, spec})
+		return tracker.event(genlhdr.Cmd, ovshdr.datapathID(), Vport{id, spec})
+	})
+}
 
-		case OVS_VPORT_CMD_DEL:
-			return consumer.VportDeleted(ovshdr.datapathID(), Vport{id, spec})
+// Enumerate the vports of the given datapath, or of all datapaths if
+// ifindex is negative.
+func enumerateVportsByDatapath(dpif *Dpif, ifindex DatapathID) (map[DatapathID]map[VportID]Vport, error) {
+	var dps []DatapathHandle
+	if ifindex >= 0 {
+		dps = append(dps, DatapathHandle{dpif: dpif, ifindex: ifindex})
+	} else {
+		dpmap, err := dpif.EnumerateDatapaths()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, dp := range dpmap {
+			dps = append(dps, dp)
+		}
+	}
+
+	res := make(map[DatapathID]map[VportID]Vport)
+	for _, dp := range dps {
+		vports, err := dp.EnumerateVports()
+		if err != nil {
+			if IsNoSuchDatapathError(err) {
+				// The datapath went away, so it has no
+				// vports
+				continue
+			}
+
+			return nil, err
+		}
+
+		m := make(map[VportID]Vport)
+		for _, vport := range vports {
+			m[vport.ID] = vport
+		}
+
+		res[dp.ifindex] = m
+	}
+
+	return res, nil
+}
+
+// A vportTracker follows the vports that its consumer has been told
+// about.  If the kernel drops events because the socket buffer
+// overflowed, we re-enumerate the vports and deliver synthetic events
+// for the differences, so that the consumer's view converges with the
+// kernel's.
+//
+// If complete is set, vports holds every vport, rather than just
+// those seen in events.  Then events that duplicate what the tracker
+// already knows, such as those queued before a snapshot was taken,
+// are suppressed.
+//
+// It is only used from the goroutine consuming events, so needs no
+// locking.
+type vportTracker struct {
+	dpif     *Dpif
+	ifindex  DatapathID
+	consumer VportEventsConsumer
+	vports   map[DatapathID]map[VportID]Vport
+	complete bool
+}
+
+func (t *vportTracker) event(cmd uint8, dpid DatapathID, vport Vport) error {
+	old, known := t.vports[dpid][vport.ID]
+
+	switch cmd {
+	case OVS_VPORT_CMD_NEW, OVS_VPORT_CMD_SET:
+		t.record(dpid, vport)
+		switch {
+		case known:
+			return t.replaced(dpid, old, vport)
+
+		case cmd == OVS_VPORT_CMD_SET && !t.complete:
+			// The vport existed before we started
+			// tracking
+			return t.changed(dpid, vport)
 
 		default:
+			return t.consumer.VportCreated(dpid, vport)
+		}
+
+	case OVS_VPORT_CMD_DEL:
+		if known {
+			if !sameVport(old, vport) {
+				// The vport number was already reused
+				return nil
+			}
+
+			delete(t.vports[dpid], vport.ID)
+		} else if t.complete {
+			// The vport was already gone
 			return nil
 		}
-	})
+
+		return t.consumer.VportDeleted(dpid, vport)
+
+	default:
+		return nil
+	}
+}
+
+func (t *vportTracker) record(dpid DatapathID, vport Vport) {
+	vports := t.vports[dpid]
+	if vports == nil {
+		vports = make(map[VportID]Vport)
+		t.vports[dpid] = vports
+	}
+
+	vports[vport.ID] = vport
+}
+
+func sameVport(a Vport, b Vport) bool {
+	return a.Spec.Name() == b.Spec.Name() &&
+		a.Spec.TypeName() == b.Spec.TypeName()
+}
+
+// Report the differences between two vports with the same port
+// number
+func (t *vportTracker) replaced(dpid DatapathID, old Vport, vport Vport) error {
+	if !sameVport(old, vport) {
+		// The vport number was reused
+		if err := t.consumer.VportDeleted(dpid, old); err != nil {
+			return err
+		}
+
+		return t.consumer.VportCreated(dpid, vport)
+	}
+
+	if !reflect.DeepEqual(old.Spec, vport.Spec) {
+		return t.changed(dpid, vport)
+	}
+
+	return nil
+}
+
+func (t *vportTracker) changed(dpid DatapathID, vport Vport) error {
+	if c, ok := t.consumer.(VportChangeConsumer); ok {
+		return c.VportChanged(dpid, vport)
+	}
+
+	return nil
+}
+
+func (t *vportTracker) resync() error {
+	// The consuming socket is only for receiving events, so we
+	// need another one to make requests on
+	dpif, err := t.dpif.Reopen()
+	if err != nil {
+		return err
+	}
+	defer dpif.Close()
+
+	current, err := enumerateVportsByDatapath(dpif, t.ifindex)
+	if err != nil {
+		return err
+	}
+
+	t.update(current)
+	return nil
+}
+
+// Deliver events for the differences between the vports the consumer
+// knows about and the current vports
+func (t *vportTracker) update(current map[DatapathID]map[VportID]Vport) {
+	report := func(err error) {
+		if err != nil {
+			t.consumer.Error(err, false)
+		}
+	}
+
+	for dpid, vports := range t.vports {
+		for id, vport := range vports {
+			if _, ok := current[dpid][id]; !ok {
+				report(t.consumer.VportDeleted(dpid, vport))
+			}
+		}
+	}
+
+	for dpid, vports := range current {
+		for id, vport := range vports {
+			if old, ok := t.vports[dpid][id]; ok {
+				report(t.replaced(dpid, old, vport))
+			} else {
+				report(t.consumer.VportCreated(dpid, vport))
+			}
+		}
+	}
+
+	t.vports = current
+	t.complete = true
 }
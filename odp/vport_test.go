@@ -0,0 +1,150 @@
+package odp
+
+import (
+	"fmt"
+	"syscall"
+	"testing"
+)
+
+// A vport type that go-odp doesn't know about, as an application
+// might define it
+const testVportTypeID = 200
+
+type testVportSpec struct {
+	VportSpecBase
+	key uint32
+}
+
+func (testVportSpec) TypeName() string {
+	return "test"
+}
+
+func (testVportSpec) TypeId() uint32 {
+	return testVportTypeID
+}
+
+func (v testVportSpec) OptionNlAttrs(req *NlMsgBuilder) {
+	req.PutUint32Attr(1, v.key)
+}
+
+func parseTestVportSpec(name string, opts Attrs) (VportSpec, error) {
+	key, err := opts.GetUint32(1)
+	if err != nil {
+		return nil, err
+	}
+
+	return testVportSpec{VportSpecBase{name}, key}, nil
+}
+
+func registerTestVportType(t *testing.T) {
+	err := RegisterVportType(VportType{testVportTypeID, "test", parseTestVportSpec})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		vportTypesLock.Lock()
+		defer vportTypesLock.Unlock()
+		delete(vportTypes, testVportTypeID)
+	})
+}
+
+func TestRegisterVportType(t *testing.T) {
+	bad := []VportType{
+		{testVportTypeID, "vxlan", parseTestVportSpec},
+		{OVS_VPORT_TYPE_VXLAN, "test", parseTestVportSpec},
+		{testVportTypeID, "test", nil},
+		{testVportTypeID, "", parseTestVportSpec},
+	}
+	for _, vt := range bad {
+		if err := RegisterVportType(vt); err == nil {
+			t.Fatalf("expected error registering %d \"%s\"", vt.ID, vt.Name)
+		}
+	}
+
+	if _, ok := LookupVportTypeByName("test"); ok {
+		t.Fatal("failed registration took effect")
+	}
+
+	registerTestVportType(t)
+
+	vt, ok := LookupVportTypeByName("test")
+	if !ok || vt.ID != testVportTypeID {
+		t.Fatal(vt)
+	}
+
+	types := VportTypes()
+	found := false
+	for i, vt := range types {
+		if i > 0 && types[i-1].ID >= vt.ID {
+			t.Fatalf("vport types out of order: %v", types)
+		}
+
+		found = found || vt.ID == testVportTypeID
+	}
+
+	if !found {
+		t.Fatalf("test vport type missing from %v", types)
+	}
+}
+
+// Build a vport as the kernel would send it in response to
+// OVS_VPORT_CMD_GET, and parse it
+func parseKernelVport(typ uint32, name string, opts func(*NlMsgBuilder)) (VportSpec, error) {
+	msg := NewNlMsgBuilder(RequestFlags, 0)
+	msg.PutUint32Attr(OVS_VPORT_ATTR_PORT_NO, 3)
+	msg.PutUint32Attr(OVS_VPORT_ATTR_TYPE, typ)
+	msg.PutStringAttr(OVS_VPORT_ATTR_NAME, name)
+	msg.PutNestedAttrs(OVS_VPORT_ATTR_OPTIONS, func() { opts(msg) })
+
+	id, spec, err := parseVport(&NlMsgParser{data: msg.buf[syscall.NLMSG_HDRLEN:]})
+	if err == nil && id != 3 {
+		err = fmt.Errorf("expected port number 3, got %d", id)
+	}
+
+	return spec, err
+}
+
+func TestParseVportRegisteredType(t *testing.T) {
+	opts := func(msg *NlMsgBuilder) { msg.PutUint32Attr(1, 42) }
+
+	// Unregistered types are kept raw
+	spec, err := parseKernelVport(testVportTypeID, "t0", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, ok := spec.(UnknownVportSpec)
+	if !ok || u.Type != testVportTypeID || len(u.Options) != 1 {
+		t.Fatal(spec)
+	}
+
+	registerTestVportType(t)
+
+	spec, err = parseKernelVport(testVportTypeID, "t0", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if spec != (testVportSpec{VportSpecBase{"t0"}, 42}) {
+		t.Fatal(spec)
+	}
+
+	// Errors from the registered parser are reported
+	_, err = parseKernelVport(testVportTypeID, "t0", func(*NlMsgBuilder) {})
+	if err == nil {
+		t.Fatal("expected error for missing vport option")
+	}
+
+	// Built-in types are parsed through the same registry
+	spec, err = parseKernelVport(OVS_VPORT_TYPE_VXLAN, "vx", func(msg *NlMsgBuilder) {
+		msg.PutUint16Attr(OVS_TUNNEL_ATTR_DST_PORT, 4789)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if spec != NewVxlanVportSpec("vx", 4789) {
+		t.Fatal(spec)
+	}
+}
@@ -0,0 +1,241 @@
+package odp
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+)
+
+// A VportCache maps between vport numbers and names, and from
+// datapath IDs to datapath names.  It is kept up to date by vport
+// events, so that rendering flows doesn't need a netlink round trip
+// for every vport mentioned.
+type VportCache struct {
+	// Used to look up datapaths that were created after the
+	// cache was started.
+	dpif    *Dpif
+	ifindex DatapathID
+	cancel  Cancelable
+
+	lock sync.Mutex
+	err  error
+	dps  map[DatapathID]*datapathVports
+}
+
+type datapathVports struct {
+	vports map[VportID]Vport
+	ids    map[string]VportID
+}
+
+// Create a cache covering all datapaths.
+func (dpif *Dpif) NewVportCache() (*VportCache, error) {
+	return DatapathHandle{dpif, -1}.NewVportCache()
+}
+
+// Create a cache covering only this datapath.
+func (dp DatapathHandle) NewVportCache() (*VportCache, error) {
+	dpif, err := dp.dpif.Reopen()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &VportCache{
+		dpif:    dpif,
+		ifindex: dp.ifindex,
+		dps:     make(map[DatapathID]*datapathVports),
+	}
+
+	// The initial contents come from the same snapshot that the
+	// events follow on from, so the cache is consistent.
+	cancel, err := dp.subscribeVportEvents(vportCacheConsumer{c},
+		func(vports map[DatapathID]map[VportID]Vport) {
+			for dpid, m := range vports {
+				for _, vport := range m {
+					c.add(dpid, vport)
+				}
+			}
+		})
+	if err != nil {
+		dpif.Close()
+		return nil, err
+	}
+
+	c.cancel = cancel
+	return c, nil
+}
+
+func (c *VportCache) Close() error {
+	err := c.cancel.Cancel()
+	if e := c.dpif.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+func (c *VportCache) add(dpid DatapathID, vport Vport) {
+	dpv := c.dps[dpid]
+	if dpv == nil {
+		dpv = &datapathVports{
+			vports: make(map[VportID]Vport),
+			ids:    make(map[string]VportID),
+		}
+		c.dps[dpid] = dpv
+	}
+
+	if old, ok := dpv.vports[vport.ID]; ok {
+		delete(dpv.ids, old.Spec.Name())
+	}
+
+	dpv.vports[vport.ID] = vport
+	dpv.ids[vport.Spec.Name()] = vport.ID
+}
+
+func (c *VportCache) remove(dpid DatapathID, vport Vport) {
+	dpv := c.dps[dpid]
+	if dpv == nil {
+		return
+	}
+
+	if old, ok := dpv.vports[vport.ID]; ok {
+		delete(dpv.ids, old.Spec.Name())
+		delete(dpv.vports, vport.ID)
+	}
+}
+
+func (c *VportCache) checkDatapath(dpid DatapathID) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	if c.ifindex >= 0 && dpid != c.ifindex {
+		return fmt.Errorf("vport cache for datapath %d does not cover datapath %d", c.ifindex, dpid)
+	}
+
+	return nil
+}
+
+func (c *VportCache) LookupVport(dpid DatapathID, id VportID) (Vport, error) {
+	vport, found, err := c.lookupCached(dpid, id)
+	if found || err != nil {
+		return vport, err
+	}
+
+	if id != OVSP_LOCAL {
+		return Vport{}, NetlinkError(syscall.ENODEV)
+	}
+
+	// There are no events for the local vport of a newly created
+	// datapath, so we need to ask for it.  This is done without
+	// holding the lock, so that other lookups and the event
+	// consumer are not held up by the netlink round trip.
+	vport, err = DatapathHandle{c.dpif, dpid}.LookupVport(OVSP_LOCAL)
+	if err != nil {
+		return Vport{}, err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// An event may have filled in the vport in the meantime, and
+	// that is more current than our answer.
+	if dpv := c.dps[dpid]; dpv != nil {
+		if cached, ok := dpv.vports[id]; ok {
+			return cached, nil
+		}
+	}
+
+	c.add(dpid, vport)
+	return vport, nil
+}
+
+func (c *VportCache) lookupCached(dpid DatapathID, id VportID) (Vport, bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err := c.checkDatapath(dpid); err != nil {
+		return Vport{}, false, err
+	}
+
+	if dpv := c.dps[dpid]; dpv != nil {
+		if vport, ok := dpv.vports[id]; ok {
+			return vport, true, nil
+		}
+	}
+
+	return Vport{}, false, nil
+}
+
+// Like DatapathHandle.LookupVportName, this falls back to showing the
+// vport number if there is no such vport.
+func (c *VportCache) LookupVportName(dpid DatapathID, id VportID) (string, error) {
+	vport, err := c.LookupVport(dpid, id)
+	if err != nil {
+		if !IsNoSuchVportError(err) {
+			return "", err
+		}
+
+		return fmt.Sprintf("%d:%d", dpid, id), nil
+	}
+
+	return vport.Spec.Name(), nil
+}
+
+func (c *VportCache) LookupVportID(dpid DatapathID, name string) (VportID, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if err := c.checkDatapath(dpid); err != nil {
+		return 0, err
+	}
+
+	if dpv := c.dps[dpid]; dpv != nil {
+		if id, ok := dpv.ids[name]; ok {
+			return id, nil
+		}
+	}
+
+	return 0, NetlinkError(syscall.ENODEV)
+}
+
+// A datapath's local vport has the same name as the datapath.
+func (c *VportCache) LookupDatapathName(dpid DatapathID) (string, error) {
+	vport, err := c.LookupVport(dpid, OVSP_LOCAL)
+	if err != nil {
+		return "", err
+	}
+
+	return vport.Spec.Name(), nil
+}
+
+// The VportEventsConsumer methods are on a separate type to keep them
+// out of VportCache's API.
+type vportCacheConsumer struct {
+	*VportCache
+}
+
+func (c vportCacheConsumer) VportCreated(dpid DatapathID, vport Vport) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.add(dpid, vport)
+	return nil
+}
+
+func (c vportCacheConsumer) VportChanged(dpid DatapathID, vport Vport) error {
+	return c.VportCreated(dpid, vport)
+}
+
+func (c vportCacheConsumer) VportDeleted(dpid DatapathID, vport Vport) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.remove(dpid, vport)
+	return nil
+}
+
+func (c vportCacheConsumer) Error(err error, stopped bool) {
+	if stopped {
+		// The cache will no longer be kept up to date
+		c.lock.Lock()
+		c.err = err
+		c.lock.Unlock()
+	}
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"encoding/hex"
 	"flag"
 	"fmt"
 	"io"
+	"math"
 	"net"
+	"net/netip"
 	"os"
 	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -182,6 +186,11 @@ var commands = subcommands{
 					"Add internal vport",
 					addInternalVport,
 				},
+				"veth": command{
+					"<datapath> <vport> <peer>",
+					"Add veth pair, with one end as a netdev vport",
+					addVethVport,
+				},
 				"vxlan": command{
 					"<datapath> <vport>",
 					"Add vxlan vport",
@@ -197,6 +206,11 @@ var commands = subcommands{
 					"Add geneve vport",
 					addGeneveVport,
 				},
+				"raw": command{
+					"<datapath> <vport>",
+					"Add vport given its type and raw options",
+					addRawVport,
+				},
 			},
 			"delete": command{
 				"<vport>", "Delete vport",
@@ -221,14 +235,26 @@ var commands = subcommands{
 			"<datapath> <options>...", "Delete flow",
 			deleteFlow,
 		},
+		"modify": command{
+			"<datapath> <options>...", "Modify flow actions",
+			modifyFlow,
+		},
 		"clear": command{
 			"<datapath> <options>...", "Clear flow stats",
 			clearFlow,
 		},
+		"get": command{
+			"<datapath> <options>...", "Show flow",
+			getFlow,
+		},
 		"list": command{
 			"<datapath>", "List flows",
 			listFlows,
 		},
+		"flush": command{
+			"<datapath>", "Delete all flows",
+			flushFlows,
+		},
 	},
 }
 
@@ -327,15 +353,30 @@ func listenOnDatapath(f Flags) bool {
 		return false
 	}
 
+	var names vportNames
+	if showKeys {
+		cache, err := dp.NewVportCache()
+		if err != nil {
+			return printErr("%s", err)
+		}
+		defer cache.Close()
+		names = vportNames{dpid: dp.ID(), cache: cache}
+	}
+
 	pipe, err := openTcpdump()
 	if err != nil {
 		return printErr("Error starting tcpdump: %s", err)
 	}
 
-	miss := func(packet []byte, flowKeys odp.FlowKeys) error {
+	// Packets sent by userspace actions are shown along with
+	// their userdata
+	upcall := func(packet []byte, flowKeys odp.FlowKeys, userdata []byte) error {
 		if showKeys {
 			os.Stdout.WriteString("[" + dpname)
-			if err := printFlowKeys(flowKeys, *dp); err != nil {
+			if userdata != nil {
+				fmt.Printf(" userdata=%s", hex.EncodeToString(userdata))
+			}
+			if err := printFlowKeys(os.Stdout, flowKeys, names); err != nil {
 				return err
 			}
 			os.Stdout.WriteString("]\n")
@@ -344,12 +385,19 @@ func listenOnDatapath(f Flags) bool {
 		return writeTcpdumpPacket(pipe, time.Now(), packet)
 	}
 
+	miss := func(packet []byte, flowKeys odp.FlowKeys) error {
+		return upcall(packet, flowKeys, nil)
+	}
+
 	done := make(chan struct{})
-	_, err = dp.ConsumeMisses(missConsumer{consumer{done}, miss})
+	cancel, err := dp.ConsumeMisses(missConsumer{consumer{done}, miss, upcall})
 	if err != nil {
 		return printErr("%s", err)
 	}
 
+	// Flows can send packets here with --userspace=<port id>
+	fmt.Fprintf(os.Stderr, "Upcall port id: %d\n", cancel.UpcallPortId())
+
 	<-done
 	return true
 }
@@ -367,13 +415,18 @@ func (c consumer) Error(err error, stopped bool) {
 
 type missConsumer struct {
 	consumer
-	miss func([]byte, odp.FlowKeys) error
+	miss   func([]byte, odp.FlowKeys) error
+	upcall func([]byte, odp.FlowKeys, []byte) error
 }
 
 func (c missConsumer) Miss(packet []byte, flowKeys odp.FlowKeys) error {
 	return c.miss(packet, flowKeys)
 }
 
+func (c missConsumer) ActionUpcall(packet []byte, flowKeys odp.FlowKeys, userdata []byte) error {
+	return c.upcall(packet, flowKeys, userdata)
+}
+
 type pcapHeader struct {
 	magicNumber  uint32
 	versionMajor uint16
@@ -457,26 +510,66 @@ func listDatapaths(f Flags) bool {
 	return true
 }
 
+// All the "vport add" commands accept a --port-no option
+func portNoFlag(f Flags) *uint {
+	return f.Uint("port-no", 0, "vport port number (by default, the kernel picks one)")
+}
+
 func addNetdevVport(f Flags) bool {
+	portNo := portNoFlag(f)
 	args := f.Parse(2, 2)
-	return addVport(args[0], odp.NewNetdevVportSpec(args[1]))
+	return addVport(args[0], odp.NewNetdevVportSpec(args[1]), *portNo)
 }
 
 func addInternalVport(f Flags) bool {
+	portNo := portNoFlag(f)
 	args := f.Parse(2, 2)
-	return addVport(args[0], odp.NewInternalVportSpec(args[1]))
+	return addVport(args[0], odp.NewInternalVportSpec(args[1]), *portNo)
+}
+
+func addVethVport(f Flags) bool {
+	portNo := portNoFlag(f)
+	args := f.Parse(3, 3)
+
+	id, ok := vportID(*portNo)
+	if !ok {
+		return false
+	}
+
+	dpif, err := odp.NewDpif()
+	if err != nil {
+		return printErr("%s", err)
+	}
+	defer dpif.Close()
+
+	dp, _ := lookupDatapath(dpif, args[0])
+	if dp == nil {
+		return false
+	}
+
+	_, err = dp.CreateVethVportWithID(args[1], args[2], id)
+	if err != nil {
+		if odp.IsLinkNameAlreadyExistsError(err) {
+			return printErr("Network device named %s or %s already exists", args[1], args[2])
+		}
+
+		return printCreateVportErr(err, id)
+	}
+
+	return true
 }
 
 func addUdpVport(f Flags, defaultPort uint, makeVportSpec func(name string, port uint16) odp.VportSpec) bool {
 	var port uint
 	f.UintVar(&port, "port", defaultPort, "UDP port number")
+	portNo := portNoFlag(f)
 	args := f.Parse(2, 2)
 
 	if port > 65535 {
 		return printErr("port number too large")
 	}
 
-	return addVport(args[0], makeVportSpec(args[1], uint16(port)))
+	return addVport(args[0], makeVportSpec(args[1], uint16(port)), *portNo)
 }
 
 func addVxlanVport(f Flags) bool {
@@ -485,9 +578,10 @@ func addVxlanVport(f Flags) bool {
 }
 
 func addGreVport(f Flags) bool {
+	portNo := portNoFlag(f)
 	args := f.Parse(2, 2)
 
-	return addVport(args[0], odp.NewGreVportSpec(args[1]))
+	return addVport(args[0], odp.NewGreVportSpec(args[1]), *portNo)
 }
 
 func addGeneveVport(f Flags) bool {
@@ -495,7 +589,89 @@ func addGeneveVport(f Flags) bool {
 	return addUdpVport(f, 6081, odp.NewGeneveVportSpec)
 }
 
-func addVport(dpname string, spec odp.VportSpec) bool {
+// Vports of types without a "vport add" command of their own are
+// listed in the form this accepts
+func addRawVport(f Flags) bool {
+	rawSpec := rawVportFlags(f)
+	portNo := portNoFlag(f)
+	args := f.Parse(2, 2)
+
+	spec, err := rawSpec(args[1])
+	if err != nil {
+		return printErr("%s", err)
+	}
+
+	return addVport(args[0], spec, *portNo)
+}
+
+// Add the flags describing a vport by its type and raw options.
+// Types registered with the odp package are looked up when the
+// returned function is called, so that their options are checked by
+// the registered parser.
+func rawVportFlags(f Flags) func(name string) (odp.VportSpec, error) {
+	var options, typeName string
+	f.StringVar(&options, "options", "", "vport options, as <type>:<hex value>,...")
+	f.StringVar(&typeName, "type", "", "registered vport type name")
+	typeId := f.Uint("type-id", 0, "numeric vport type")
+
+	return func(name string) (odp.VportSpec, error) {
+		opts, err := parseAttrs(options)
+		if err != nil {
+			return nil, err
+		}
+
+		if typeName != "" {
+			t, ok := odp.LookupVportTypeByName(typeName)
+			if !ok {
+				return nil, fmt.Errorf("unknown vport type \"%s\"", typeName)
+			}
+
+			if *typeId != 0 && *typeId != uint(t.ID) {
+				return nil, fmt.Errorf("vport type \"%s\" has type id %d", typeName, t.ID)
+			}
+
+			return t.Parse(name, opts)
+		}
+
+		if *typeId == 0 || *typeId > math.MaxUint32 {
+			return nil, fmt.Errorf("A valid --type or --type-id is required")
+		}
+
+		for _, t := range odp.VportTypes() {
+			if uint(t.ID) == *typeId {
+				return t.Parse(name, opts)
+			}
+		}
+
+		return odp.NewUnknownVportSpec(name, uint32(*typeId), opts), nil
+	}
+}
+
+func vportID(portNo uint) (odp.VportID, bool) {
+	if portNo > math.MaxUint32 {
+		return 0, printErr("port number too large")
+	}
+
+	return odp.VportID(portNo), true
+}
+
+func printCreateVportErr(err error, id odp.VportID) bool {
+	switch {
+	case odp.IsVportIDInUseError(err):
+		return printErr("Port number %d is already in use", id)
+	case odp.IsVportIDTooLargeError(err):
+		return printErr("Port number %d is too large", id)
+	default:
+		return printErr("%s", err)
+	}
+}
+
+func addVport(dpname string, spec odp.VportSpec, portNo uint) bool {
+	id, ok := vportID(portNo)
+	if !ok {
+		return false
+	}
+
 	dpif, err := odp.NewDpif()
 	if err != nil {
 		return printErr("%s", err)
@@ -507,9 +683,9 @@ func addVport(dpname string, spec odp.VportSpec) bool {
 		return false
 	}
 
-	_, err = dp.CreateVport(spec)
+	_, err = dp.CreateVportWithID(spec, id)
 	if err != nil {
-		return printErr("%s", err)
+		return printCreateVportErr(err, id)
 	}
 
 	return true
@@ -624,6 +800,16 @@ func (c vportEventsConsumer) VportCreated(dpid odp.DatapathID, vport odp.Vport)
 	return nil
 }
 
+func (c vportEventsConsumer) VportChanged(dpid odp.DatapathID, vport odp.Vport) error {
+	dp, err := c.dpif.LookupDatapathByID(dpid)
+	if err != nil {
+		return err
+	}
+
+	printVport("change ", dp.Name, vport)
+	return nil
+}
+
 func (c vportEventsConsumer) VportDeleted(dpid odp.DatapathID, vport odp.Vport) error {
 	dp, err := c.dpif.LookupDatapathByID(dpid)
 	if err != nil {
@@ -635,17 +821,99 @@ func (c vportEventsConsumer) VportDeleted(dpid odp.DatapathID, vport odp.Vport)
 }
 
 func printVport(prefix string, dpname string, vport odp.Vport) {
-	spec := vport.Spec
-	fmt.Printf("%s%s %s %s", prefix, spec.TypeName(), dpname, spec.Name())
+	fmt.Printf("%s%s\n", prefix, formatVport(dpname, vport.Spec))
+}
+
+// Format a vport in the form accepted by "vport add"
+func formatVport(dpname string, spec odp.VportSpec) string {
+	var buf bytes.Buffer
+	typeName := spec.TypeName()
+
+	switch spec.(type) {
+	case odp.SimpleVportSpec, odp.GreVportSpec, odp.VxlanVportSpec, odp.GeneveVportSpec:
+	default:
+		// Vports without a "vport add" command of their own
+		typeName = "raw"
+	}
+
+	fmt.Fprintf(&buf, "%s %s %s", typeName, dpname, spec.Name())
 
 	switch spec := spec.(type) {
+	case odp.SimpleVportSpec, odp.GreVportSpec:
 	case odp.VxlanVportSpec:
-		fmt.Printf(" --port=%d", spec.Port)
+		fmt.Fprintf(&buf, " --port=%d", spec.Port)
 	case odp.GeneveVportSpec:
-		fmt.Printf(" --port=%d", spec.Port)
+		fmt.Fprintf(&buf, " --port=%d", spec.Port)
+	case odp.UnknownVportSpec:
+		fmt.Fprintf(&buf, " --type-id=%d", spec.Type)
+		if len(spec.Options) > 0 {
+			fmt.Fprintf(&buf, " --options=%s", formatAttrs(spec.Options))
+		}
+
+		// Name the types the odp package knows about, in a
+		// shell comment so that the line can still be pasted
+		// back in
+		if name := spec.TypeName(); name != fmt.Sprintf("type%d", spec.Type) {
+			fmt.Fprintf(&buf, " # %s", name)
+		}
+	default:
+		// A vport type registered with the odp package, so show
+		// its raw options
+		fmt.Fprintf(&buf, " --type=%s", spec.TypeName())
+		opts, err := odp.VportSpecOptions(spec)
+		if err != nil {
+			fmt.Fprintf(&buf, " <error: %s>", err)
+		} else if len(opts) > 0 {
+			fmt.Fprintf(&buf, " --options=%s", formatAttrs(opts))
+		}
+	}
+
+	return buf.String()
+}
+
+// Show raw attributes as a comma-separated list of type:hex-value
+func formatAttrs(attrs odp.Attrs) string {
+	typs := make([]int, 0, len(attrs))
+	for typ := range attrs {
+		typs = append(typs, int(typ))
+	}
+	sort.Ints(typs)
+
+	res := make([]string, len(typs))
+	for i, typ := range typs {
+		res[i] = fmt.Sprintf("%d:%s", typ, hex.EncodeToString(attrs[uint16(typ)]))
+	}
+
+	return strings.Join(res, ",")
+}
+
+// The inverse of formatAttrs
+func parseAttrs(s string) (odp.Attrs, error) {
+	attrs := make(odp.Attrs)
+	if s == "" {
+		return attrs, nil
+	}
+
+	for _, attr := range strings.Split(s, ",") {
+		parts := strings.SplitN(attr, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected <type>:<hex value>, got \"%s\"", attr)
+		}
+
+		typ, err := strconv.ParseUint(parts[0], 10, 16)
+		if err != nil {
+			return nil, err
+		}
+
+		val, err := hex.DecodeString(parts[1])
+		if err != nil {
+			return nil, err
+		}
+
+		attrs[uint16(typ)] = val
 	}
 
-	fmt.Printf("\n")
+	return attrs, nil
 }
 
 func parseMAC(s string) (mac [6]byte, err error) {
@@ -699,18 +967,23 @@ type tunnelFlags struct {
 	id      string
 	ipv4Src string
 	ipv4Dst string
+	ipv6Src string
+	ipv6Dst string
 	tos     int
 	ttl     int
 	df      string
 	csum    string
 	tpsrc   int
 	tpdst   int
+	geneve  string
 }
 
 func addTunnelFlags(f Flags, tf *tunnelFlags, prefix string, descrPrefix string) {
 	f.StringVar(&tf.id, prefix+"id", "", descrPrefix+"ID")
 	f.StringVar(&tf.ipv4Src, prefix+"ipv4-src", "", descrPrefix+"ipv4 source address")
 	f.StringVar(&tf.ipv4Dst, prefix+"ipv4-dst", "", descrPrefix+"ipv4 destination address")
+	f.StringVar(&tf.ipv6Src, prefix+"ipv6-src", "", descrPrefix+"ipv6 source address")
+	f.StringVar(&tf.ipv6Dst, prefix+"ipv6-dst", "", descrPrefix+"ipv6 destination address")
 	f.IntVar(&tf.tos, prefix+"tos", -1, descrPrefix+"ToS")
 	f.IntVar(&tf.ttl, prefix+"ttl", -1, descrPrefix+"TTL")
 
@@ -721,6 +994,70 @@ func addTunnelFlags(f Flags, tf *tunnelFlags, prefix string, descrPrefix string)
 
 	f.IntVar(&tf.tpsrc, prefix+"tp-src", -1, descrPrefix+"source port")
 	f.IntVar(&tf.tpdst, prefix+"tp-dst", -1, descrPrefix+"destination port")
+	f.StringVar(&tf.geneve, prefix+"geneve", "", descrPrefix+"Geneve options (<class>:<type>:<hex data>, comma separated)")
+}
+
+// Parse a comma separated list of Geneve options, each in the form
+// "<class>:<type>:<hex data>[&<class mask>:<type mask>:<hex data mask>]"
+func parseGeneveOptions(opt string) (opts []odp.GeneveOption, masks []odp.GeneveOption, err error) {
+	parse := func(s string) (o odp.GeneveOption, err error) {
+		parts := strings.Split(s, ":")
+		if len(parts) != 3 {
+			err = fmt.Errorf("bad Geneve option \"%s\"", s)
+			return
+		}
+
+		class, err := strconv.ParseUint(parts[0], 0, 16)
+		if err != nil {
+			return
+		}
+
+		typ, err := strconv.ParseUint(parts[1], 0, 8)
+		if err != nil {
+			return
+		}
+
+		o.Class = uint16(class)
+		o.Type = uint8(typ)
+		o.Data, err = hex.DecodeString(strings.TrimPrefix(parts[2], "0x"))
+		return
+	}
+
+	for _, s := range strings.Split(opt, ",") {
+		val := s
+		maskStr := ""
+		if i := strings.Index(s, "&"); i >= 0 {
+			val = s[:i]
+			maskStr = s[i+1:]
+		}
+
+		o, err := parse(val)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		m := odp.GeneveOption{Class: 0xffff, Type: 0xff, Data: make([]byte, len(o.Data))}
+		setBytes(m.Data, 0xff)
+		if maskStr != "" {
+			m, err = parse(maskStr)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if len(m.Data) != len(o.Data) {
+				return nil, nil, fmt.Errorf("Geneve option mask \"%s\" does not match data length", maskStr)
+			}
+		}
+
+		opts = append(opts, o)
+		masks = append(masks, m)
+	}
+
+	return
+}
+
+func isExactGeneveMask(m odp.GeneveOption) bool {
+	return m.Class == 0xffff && m.Type == 0xff && odp.AllBytes(m.Data, 0xff)
 }
 
 func makeBoolStrings(trueStrs, falseStrs string) map[string]bool {
@@ -784,6 +1121,29 @@ func parseTunnelFlags(tf *tunnelFlags) (odp.TunnelFlowKey, error) {
 		fk.SetIpv4Dst(addr)
 	}
 
+	if (tf.ipv6Src != "" || tf.ipv6Dst != "") &&
+		(tf.ipv4Src != "" || tf.ipv4Dst != "") {
+		return fk, fmt.Errorf("IPv4 and IPv6 tunnel addresses cannot be combined")
+	}
+
+	if tf.ipv6Src != "" {
+		addr, err := parseIpv6(tf.ipv6Src)
+		if err != nil {
+			return fk, err
+		}
+
+		fk.SetIpv6Src(addr.As16())
+	}
+
+	if tf.ipv6Dst != "" {
+		addr, err := parseIpv6(tf.ipv6Dst)
+		if err != nil {
+			return fk, err
+		}
+
+		fk.SetIpv6Dst(addr.As16())
+	}
+
 	if tf.tos >= 0 {
 		fk.SetTos(uint8(tf.tos))
 	}
@@ -818,6 +1178,15 @@ func parseTunnelFlags(tf *tunnelFlags) (odp.TunnelFlowKey, error) {
 		fk.SetTpDst(uint16(tf.tpdst))
 	}
 
+	if tf.geneve != "" {
+		opts, masks, err := parseGeneveOptions(tf.geneve)
+		if err != nil {
+			return fk, err
+		}
+
+		fk.SetMaskedGeneveOptions(opts, masks)
+	}
+
 	return fk, nil
 }
 
@@ -849,21 +1218,32 @@ func parseSetTunnelFlags(tf *tunnelFlags) (*odp.SetTunnelAction, error) {
 	a.Present.TunnelId = bytesPresent(m.TunnelId[:])
 	a.Present.Ipv4Src = bytesPresent(m.Ipv4Src[:])
 	a.Present.Ipv4Dst = bytesPresent(m.Ipv4Dst[:])
+	a.Present.Ipv6Src = bytesPresent(m.Ipv6Src[:])
+	a.Present.Ipv6Dst = bytesPresent(m.Ipv6Dst[:])
 	a.Present.Tos = present(m.Tos == 0xff, m.Tos == 0)
 	a.Present.Ttl = present(m.Ttl == 0xff, m.Ttl == 0)
 	a.Present.Df = m.Df
 	a.Present.Csum = m.Csum
 	a.Present.TpSrc = present(m.TpSrc == 0xffff, m.TpSrc == 0)
 	a.Present.TpDst = present(m.TpDst == 0xffff, m.TpDst == 0)
+	a.Present.GeneveOpts = len(m.GeneveOpts) != 0
+
+	for _, gm := range m.GeneveOpts {
+		if !isExactGeneveMask(gm) {
+			foundMask = true
+		}
+	}
 
 	if foundMask {
 		return nil, fmt.Errorf("--set-tunnel option includes a mask")
 	}
 
 	if a.Present.TunnelId || a.Present.Ipv4Src || a.Present.Ipv4Dst ||
+		a.Present.Ipv6Src || a.Present.Ipv6Dst ||
 		a.Present.Tos || a.Present.Ttl ||
 		a.Present.Df || a.Present.Csum ||
-		a.Present.TpSrc || a.Present.TpDst {
+		a.Present.TpSrc || a.Present.TpDst ||
+		a.Present.GeneveOpts {
 		return &a, nil
 	} else {
 		return nil, nil
@@ -880,14 +1260,40 @@ func flagsToFlowSpec(f Flags, dpif *odp.Dpif) (dp odp.DatapathHandle, flow odp.F
 	f.StringVar(&ethSrc, "eth-src", "", "key: ethernet source MAC")
 	f.StringVar(&ethDst, "eth-dst", "", "key: ethernet destination MAC")
 
+	var ethType string
+	f.StringVar(&ethType, "eth-type", "", "key: ethertype")
+
+	var ipf ipFlags
+	addIPFlags(f, &ipf)
+
+	var l4f l4Flags
+	addL4Flags(f, &l4f)
+
+	var arpf arpFlags
+	addArpFlags(f, &arpf)
+
+	var mplsf mplsFlags
+	addMplsFlags(f, &mplsf)
+
+	var ctf ctFlags
+	addCtFlags(f, &ctf)
+
+	var recircId, dpHash string
+	f.StringVar(&recircId, "recirc-id", "", "key: recirculation id")
+	f.StringVar(&dpHash, "dp-hash", "", "key: datapath hash computed by a hash action")
+
+	var vlanVid, vlanPcp string
+	f.StringVar(&vlanVid, "vlan-vid", "", "key: VLAN ids, outermost first")
+	f.StringVar(&vlanPcp, "vlan-pcp", "", "key: VLAN priorities, outermost first")
+
 	var tun tunnelFlags
 	addTunnelFlags(f, &tun, "tunnel-", "tunnel ")
 
 	var setTun tunnelFlags
 	addTunnelFlags(f, &setTun, "set-tunnel-", "action: set tunnel ")
 
-	var output string
-	f.StringVar(&output, "output", "", "action: output to vports")
+	var actions []actionMaker
+	addActionFlags(f, &actions)
 
 	args := f.Parse(1, 1)
 	dpp, _ := lookupDatapath(dpif, args[0])
@@ -911,6 +1317,48 @@ func flagsToFlowSpec(f Flags, dpif *odp.Dpif) (dp odp.DatapathHandle, flow odp.F
 		return
 	}
 
+	err = handleL4FlowKeyOptions(flow, &l4f, &ipf)
+	if err != nil {
+		printErr("%s", err)
+		return
+	}
+
+	err = handleArpFlowKeyOptions(flow, &arpf, &ethType)
+	if err != nil {
+		printErr("%s", err)
+		return
+	}
+
+	err = handleMplsFlowKeyOptions(flow, &mplsf, &ethType)
+	if err != nil {
+		printErr("%s", err)
+		return
+	}
+
+	err = handleIPFlowKeyOptions(flow, ethType, &ipf)
+	if err != nil {
+		printErr("%s", err)
+		return
+	}
+
+	err = handleCtFlowKeyOptions(flow, &ctf)
+	if err != nil {
+		printErr("%s", err)
+		return
+	}
+
+	err = handleRecircFlowKeyOptions(flow, recircId, dpHash)
+	if err != nil {
+		printErr("%s", err)
+		return
+	}
+
+	err = handleVlanFlowKeyOptions(flow, vlanVid, vlanPcp)
+	if err != nil {
+		printErr("%s", err)
+		return
+	}
+
 	flowKey, err := parseTunnelFlags(&tun)
 	if err != nil {
 		printErr("%s", err)
@@ -921,8 +1369,8 @@ func flagsToFlowSpec(f Flags, dpif *odp.Dpif) (dp odp.DatapathHandle, flow odp.F
 		flow.AddKey(flowKey)
 	}
 
-	// Actions are ordered, but flags aren't.  As a temporary
-	// hack, we already put SET actions before an OUTPUT action.
+	// The set tunnel options combine into a single action, which
+	// we put before the other actions.
 
 	setTunAttrs, err := parseSetTunnelFlags(&setTun)
 	if err != nil {
@@ -934,67 +1382,2160 @@ func flagsToFlowSpec(f Flags, dpif *odp.Dpif) (dp odp.DatapathHandle, flow odp.F
 		flow.AddAction(*setTunAttrs)
 	}
 
-	if output != "" {
-		for _, vpname := range strings.Split(output, ",") {
-			vport, err := dpp.LookupVportByName(vpname)
-			if err != nil {
-				printErr("%s", err)
-				return
-			}
-			flow.AddAction(odp.NewOutputAction(vport.ID))
+	for _, m := range actions {
+		as, err := m(dpp)
+		if err != nil {
+			printErr("%s", err)
+			return
 		}
+
+		flow.AddActions(as)
 	}
 
 	return *dpp, flow, true
 }
 
-func handleEthernetFlowKeyOptions(flow odp.FlowSpec, src string, dst string) error {
-	var err error
-	takeErr := func(key [ETH_ALEN]byte, mask [ETH_ALEN]byte,
-		e error) ([ETH_ALEN]byte, [ETH_ALEN]byte) {
-		err = e
-		return key, mask
-	}
+// Actions are ordered, but the flag package doesn't tell us the
+// order of distinct options.  So action options are flag.Values that
+// append to a shared list as they are parsed.  Producing the actions
+// can need the datapath, which isn't known until after parsing.
+type actionMaker func(dp *odp.DatapathHandle) ([]odp.Action, error)
 
-	fk := odp.NewEthernetFlowKey()
+type actionFlag struct {
+	actions *[]actionMaker
+	parse   func(string) (actionMaker, error)
+	isBool  bool
+}
 
-	fk.SetMaskedEthSrc(takeErr(handleEthernetAddrOption(src)))
-	fk.SetMaskedEthDst(takeErr(handleEthernetAddrOption(dst)))
+func (af actionFlag) String() string {
+	return ""
+}
 
+func (af actionFlag) Set(val string) error {
+	m, err := af.parse(val)
 	if err != nil {
 		return err
 	}
 
-	flow.AddKey(fk)
+	*af.actions = append(*af.actions, m)
 	return nil
 }
 
-const ETH_ALEN = odp.ETH_ALEN
+func (af actionFlag) IsBoolFlag() bool {
+	return af.isBool
+}
 
-func handleEthernetAddrOption(opt string) (key [ETH_ALEN]byte, mask [ETH_ALEN]byte, err error) {
-	if opt != "" {
-		var k, m string
-		i := strings.Index(opt, "&")
-		if i > 0 {
-			k = opt[:i]
-			m = opt[i+1:]
-		} else {
-			k = opt
-			m = "ff:ff:ff:ff:ff:ff"
+func addActionFlags(f Flags, actions *[]actionMaker) {
+	f.Var(actionFlag{actions: actions, parse: parseOutputOption},
+		"output", "action: output to vports")
+	f.Var(actionFlag{actions: actions, parse: parsePushVlanOption},
+		"push-vlan", "action: push VLAN tag (<vid>[,pcp=<pcp>][,tpid=<tpid>])")
+	f.Var(actionFlag{actions: actions, parse: parsePopVlanOption, isBool: true},
+		"pop-vlan", "action: pop outermost VLAN tag")
+	f.Var(actionFlag{actions: actions, parse: parsePushMplsOption},
+		"push-mpls", "action: push MPLS label (<label>[,tc=<tc>][,ttl=<ttl>][,bos=<0|1>][,ethertype=<ethertype>])")
+	f.Var(actionFlag{actions: actions, parse: parsePopMplsOption},
+		"pop-mpls", "action: pop outermost MPLS label, giving the resulting ethertype")
+	f.Var(actionFlag{actions: actions, parse: parseUserspaceOption},
+		"userspace", "action: send to userspace (<port id>[,userdata=<hex>][,tunnel-port=<vport>])")
+	addSetMaskedFlags(f, actions)
+	f.Var(actionFlag{actions: actions, parse: parseSampleOption},
+		"sample", "action: perform actions on one in n packets (<n>:<action options>)")
+	f.Var(actionFlag{actions: actions, parse: parseCtOption, isBool: true},
+		"ct", "action: connection tracking (e.g. commit,zone=<zone>,snat=<range>)")
+	f.Var(actionFlag{actions: actions, parse: parseHashOption},
+		"hash", "action: compute datapath hash (l4|sym-l4[,basis=<basis>])")
+	f.Var(actionFlag{actions: actions, parse: parseRecircOption},
+		"recirc", "action: recirculate with the given recirculation id")
+	f.Var(actionFlag{actions: actions, parse: parseTruncOption},
+		"trunc", "action: truncate the packet on output to the given length")
+	f.Var(actionFlag{actions: actions, parse: parseCloneOption},
+		"clone", "action: perform actions on a copy of the packet (<action options>)")
+	f.Var(actionFlag{actions: actions, parse: parseCheckPktLenOption},
+		"check-pkt-len", "action: perform actions depending on packet length (<len>:<action options if greater>|<action options otherwise>)")
+	f.Var(actionFlag{actions: actions, parse: parseDecTtlOption, isBool: true},
+		"dec-ttl", "action: decrement IP TTL, optionally with actions for expired packets")
+	f.Var(actionFlag{actions: actions, parse: parsePushEthOption},
+		"push-eth", "action: push ethernet header (<src MAC>,<dst MAC>)")
+	f.Var(actionFlag{actions: actions, parse: parsePopEthOption, isBool: true},
+		"pop-eth", "action: pop ethernet header")
+	f.Var(actionFlag{actions: actions, parse: parseMeterOption},
+		"meter", "action: apply the meter with the given id")
+	f.Var(actionFlag{actions: actions, parse: parseDropOption, isBool: true},
+		"drop", "action: drop the packet, optionally with a reason code")
+	f.Var(actionFlag{actions: actions, parse: parseRawActionOption},
+		"raw-action", "action: action attribute given as <type>:<hex>")
+}
+
+func parseOutputOption(val string) (actionMaker, error) {
+	return func(dp *odp.DatapathHandle) ([]odp.Action, error) {
+		var as []odp.Action
+		for _, vpname := range strings.Split(val, ",") {
+			vport, err := dp.LookupVportByName(vpname)
+			if err != nil {
+				return nil, err
+			}
+
+			as = append(as, odp.NewOutputAction(vport.ID))
+		}
+
+		return as, nil
+	}, nil
+}
+
+func parseUserspaceOption(val string) (actionMaker, error) {
+	parts := strings.Split(val, ",")
+	pid, err := strconv.ParseUint(parts[0], 0, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	a := odp.NewUserspaceAction(uint32(pid), nil)
+	var tunnelPort string
+	for _, part := range parts[1:] {
+		switch {
+		case strings.HasPrefix(part, "userdata="):
+			a.Userdata, err = hex.DecodeString(strings.TrimPrefix(part[9:], "0x"))
+			if err != nil {
+				return nil, err
+			}
+
+		case strings.HasPrefix(part, "tunnel-port="):
+			tunnelPort = part[12:]
+
+		default:
+			return nil, fmt.Errorf("unknown userspace parameter \"%s\"", part)
+		}
+	}
+
+	return func(dp *odp.DatapathHandle) ([]odp.Action, error) {
+		if tunnelPort != "" {
+			vport, err := dp.LookupVportByName(tunnelPort)
+			if err != nil {
+				return nil, err
+			}
+
+			a.EgressTunPort = &vport.ID
+		}
+
+		return []odp.Action{a}, nil
+	}, nil
+}
+
+// Each --set-* option (other than the --set-tunnel-* options)
+// produces a masked set action that rewrites a single header field.
+// Values can include masks, as for the corresponding key options.
+func addSetMaskedFlags(f Flags, actions *[]actionMaker) {
+	add := func(name string, descr string, build func(string) (odp.BlobFlowKeyish, error)) {
+		parse := func(val string) (actionMaker, error) {
+			fk, err := build(val)
+			if err != nil {
+				return nil, err
+			}
+
+			return constActions(odp.NewSetMaskedAction(fk)), nil
+		}
+
+		f.Var(actionFlag{actions: actions, parse: parse},
+			"set-"+name, "action: set "+descr)
+	}
+
+	ethAddr := func(set func(*odp.EthernetFlowKey, [ETH_ALEN]byte, [ETH_ALEN]byte)) func(string) (odp.BlobFlowKeyish, error) {
+		return func(val string) (odp.BlobFlowKeyish, error) {
+			fk := odp.NewWildcardEthernetFlowKey()
+			addr, mask, err := handleEthernetAddrOption(val)
+			set(&fk, addr, mask)
+			return fk, err
+		}
+	}
+
+	add("eth-src", "ethernet source MAC", ethAddr((*odp.EthernetFlowKey).SetMaskedEthSrc))
+	add("eth-dst", "ethernet destination MAC", ethAddr((*odp.EthernetFlowKey).SetMaskedEthDst))
+
+	ipv4Addr := func(set func(*odp.IPv4FlowKey, [4]byte, [4]byte)) func(string) (odp.BlobFlowKeyish, error) {
+		return func(val string) (odp.BlobFlowKeyish, error) {
+			fk := odp.NewIPv4FlowKey()
+			addr, mask, err := parseIpv4Option(val)
+			set(&fk, addr, mask)
+			return fk, err
+		}
+	}
+
+	add("ipv4-src", "IPv4 source address", ipv4Addr((*odp.IPv4FlowKey).SetMaskedIpv4Src))
+	add("ipv4-dst", "IPv4 destination address", ipv4Addr((*odp.IPv4FlowKey).SetMaskedIpv4Dst))
+
+	ipv6Addr := func(set func(*odp.IPv6FlowKey, netip.Addr, [16]byte)) func(string) (odp.BlobFlowKeyish, error) {
+		return func(val string) (odp.BlobFlowKeyish, error) {
+			fk := odp.NewIPv6FlowKey()
+			addr, mask, err := parseIpv6Option(val)
+			if err != nil {
+				return nil, err
+			}
+
+			set(&fk, addr, mask)
+			return fk, nil
+		}
+	}
+
+	add("ipv6-src", "IPv6 source address", ipv6Addr((*odp.IPv6FlowKey).SetMaskedIpv6Src))
+	add("ipv6-dst", "IPv6 destination address", ipv6Addr((*odp.IPv6FlowKey).SetMaskedIpv6Dst))
+
+	ipv4Byte := func(set func(*odp.IPv4FlowKey, uint8, uint8)) func(string) (odp.BlobFlowKeyish, error) {
+		return func(val string) (odp.BlobFlowKeyish, error) {
+			fk := odp.NewIPv4FlowKey()
+			v, m, err := parseMaskedUint(val, 8)
+			set(&fk, uint8(v), uint8(m))
+			return fk, err
+		}
+	}
+
+	add("ipv4-tos", "IPv4 ToS byte", ipv4Byte((*odp.IPv4FlowKey).SetMaskedTos))
+	add("ipv4-ttl", "IPv4 TTL", ipv4Byte((*odp.IPv4FlowKey).SetMaskedTtl))
+
+	ipv6Byte := func(set func(*odp.IPv6FlowKey, uint8, uint8)) func(string) (odp.BlobFlowKeyish, error) {
+		return func(val string) (odp.BlobFlowKeyish, error) {
+			fk := odp.NewIPv6FlowKey()
+			v, m, err := parseMaskedUint(val, 8)
+			set(&fk, uint8(v), uint8(m))
+			return fk, err
+		}
+	}
+
+	add("ipv6-tclass", "IPv6 traffic class", ipv6Byte((*odp.IPv6FlowKey).SetMaskedTclass))
+	add("ipv6-hlimit", "IPv6 hop limit", ipv6Byte((*odp.IPv6FlowKey).SetMaskedHlimit))
+
+	type portsFlowKey interface {
+		odp.BlobFlowKeyish
+		SetMaskedSrc(uint16, uint16)
+		SetMaskedDst(uint16, uint16)
+	}
+
+	port := func(newKey func() portsFlowKey, dst bool) func(string) (odp.BlobFlowKeyish, error) {
+		return func(val string) (odp.BlobFlowKeyish, error) {
+			fk := newKey()
+			v, m, err := parseMaskedUint(val, 16)
+			if dst {
+				fk.SetMaskedDst(uint16(v), uint16(m))
+			} else {
+				fk.SetMaskedSrc(uint16(v), uint16(m))
+			}
+			return fk, err
+		}
+	}
+
+	tcp := func() portsFlowKey { fk := odp.NewTcpFlowKey(); return &fk }
+	udp := func() portsFlowKey { fk := odp.NewUdpFlowKey(); return &fk }
+	add("tcp-src", "TCP source port", port(tcp, false))
+	add("tcp-dst", "TCP destination port", port(tcp, true))
+	add("udp-src", "UDP source port", port(udp, false))
+	add("udp-dst", "UDP destination port", port(udp, true))
+}
+
+// The sampled actions are given as action options separated by
+// spaces, e.g. --sample="100:--userspace=1234 --output=vp"
+func parseSampleOption(val string) (actionMaker, error) {
+	i := strings.Index(val, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("sample option \"%s\" should have the form <n>:<action options>", val)
+	}
+
+	n, err := strconv.ParseUint(val[:i], 0, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	nested, err := parseNestedActionOptions("sample", val[i+1:])
+	if err != nil {
+		return nil, err
+	}
+
+	return func(dp *odp.DatapathHandle) ([]odp.Action, error) {
+		as, err := nested(dp)
+		if err != nil {
+			return nil, err
+		}
+
+		return []odp.Action{odp.NewSampleAction(odp.SampleOneIn(uint32(n)), as)}, nil
+	}, nil
+}
+
+// Split nested action options into words, with quotes and
+// backslashes handled as in the shell, so that nested options can
+// themselves contain quoted values.  The words are grouped at
+// unquoted "|" characters.
+func splitOptionWords(s string) ([][]string, error) {
+	groups := [][]string{nil}
+	var word []byte
+	inWord := false
+	endWord := func() {
+		if inWord {
+			last := len(groups) - 1
+			groups[last] = append(groups[last], string(word))
+			word = word[:0]
+			inWord = false
+		}
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			endWord()
+
+		case c == '|':
+			endWord()
+			groups = append(groups, nil)
+
+		case c == '\\':
+			i++
+			if i == len(s) {
+				return nil, fmt.Errorf("trailing backslash in \"%s\"", s)
+			}
+			word = append(word, s[i])
+			inWord = true
+
+		case c == '\'':
+			j := strings.IndexByte(s[i+1:], '\'')
+			if j < 0 {
+				return nil, fmt.Errorf("unterminated quote in \"%s\"", s)
+			}
+			word = append(word, s[i+1:i+1+j]...)
+			i += j + 1
+			inWord = true
+
+		case c == '"':
+			for i++; ; i++ {
+				if i == len(s) {
+					return nil, fmt.Errorf("unterminated quote in \"%s\"", s)
+				}
+
+				c = s[i]
+				if c == '"' {
+					break
+				}
+
+				if c == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`", s[i+1]) >= 0 {
+					i++
+					c = s[i]
+				}
+				word = append(word, c)
+			}
+			inWord = true
+
+		default:
+			word = append(word, c)
+			inWord = true
+		}
+	}
+
+	endWord()
+	return groups, nil
+}
+
+// Parse a list of action options separated by spaces, for actions
+// that contain nested actions
+func parseNestedActionOptions(name string, opts string) (actionMaker, error) {
+	groups, err := splitOptionWords(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(groups) > 1 {
+		return nil, fmt.Errorf("unexpected \"|\" in %s option", name)
+	}
+
+	return parseNestedActionWords(name, groups[0])
+}
+
+func parseNestedActionWords(name string, words []string) (actionMaker, error) {
+	var nested []actionMaker
+	f := flag.NewFlagSet(name, flag.ContinueOnError)
+	f.SetOutput(io.Discard)
+	addActionFlags(Flags{f, nil}, &nested)
+	if err := f.Parse(words); err != nil {
+		return nil, err
+	}
+
+	if f.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected %s argument \"%s\"", name, f.Arg(0))
+	}
+
+	return func(dp *odp.DatapathHandle) ([]odp.Action, error) {
+		as := make([]odp.Action, 0)
+		for _, m := range nested {
+			nas, err := m(dp)
+			if err != nil {
+				return nil, err
+			}
+
+			as = append(as, nas...)
+		}
+
+		return as, nil
+	}, nil
+}
+
+func parseCloneOption(val string) (actionMaker, error) {
+	nested, err := parseNestedActionOptions("clone", val)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(dp *odp.DatapathHandle) ([]odp.Action, error) {
+		as, err := nested(dp)
+		if err != nil {
+			return nil, err
+		}
+
+		return []odp.Action{odp.NewCloneAction(as)}, nil
+	}, nil
+}
+
+// The two action lists are separated by "|", e.g.
+// --check-pkt-len="1500:--userspace=1234|--output=vp".  A "|" inside
+// quotes belongs to a nested option.
+func parseCheckPktLenOption(val string) (actionMaker, error) {
+	lenStr, branches, ok := strings.Cut(val, ":")
+	if !ok {
+		return nil, fmt.Errorf("check-pkt-len option \"%s\" should have the form <len>:<action options if greater>|<action options otherwise>", val)
+	}
+
+	pktLen, err := strconv.ParseUint(lenStr, 0, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := splitOptionWords(branches)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(groups) > 2 {
+		return nil, fmt.Errorf("check-pkt-len option \"%s\" has more than two action lists", val)
+	}
+
+	greater, err := parseNestedActionWords("check-pkt-len", groups[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var lessEqualWords []string
+	if len(groups) == 2 {
+		lessEqualWords = groups[1]
+	}
+
+	lessEqual, err := parseNestedActionWords("check-pkt-len", lessEqualWords)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(dp *odp.DatapathHandle) ([]odp.Action, error) {
+		gas, err := greater(dp)
+		if err != nil {
+			return nil, err
+		}
+
+		leas, err := lessEqual(dp)
+		if err != nil {
+			return nil, err
+		}
+
+		return []odp.Action{odp.NewCheckPktLenAction(uint16(pktLen), gas, leas)}, nil
+	}, nil
+}
+
+func parseDecTtlOption(val string) (actionMaker, error) {
+	if val == "true" {
+		return constActions(odp.NewDecTtlAction(nil)), nil
+	}
+
+	nested, err := parseNestedActionOptions("dec-ttl", val)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(dp *odp.DatapathHandle) ([]odp.Action, error) {
+		as, err := nested(dp)
+		if err != nil {
+			return nil, err
+		}
+
+		return []odp.Action{odp.NewDecTtlAction(as)}, nil
+	}, nil
+}
+
+// Actions that go-odp doesn't know about are shown as raw attributes,
+// so that flow listings can be fed back to "odp flow add"
+func parseRawActionOption(val string) (actionMaker, error) {
+	typStr, dataStr, _ := strings.Cut(val, ":")
+	typ, err := strconv.ParseUint(typStr, 0, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := hex.DecodeString(dataStr)
+	if err != nil {
+		return nil, err
+	}
+
+	return constActions(odp.NewUnknownAction(uint16(typ), data)), nil
+}
+
+func parseTruncOption(val string) (actionMaker, error) {
+	maxLen, err := strconv.ParseUint(val, 0, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return constActions(odp.NewTruncAction(uint32(maxLen))), nil
+}
+
+func parsePushEthOption(val string) (actionMaker, error) {
+	srcStr, dstStr, ok := strings.Cut(val, ",")
+	if !ok {
+		return nil, fmt.Errorf("push-eth option \"%s\" should have the form <src MAC>,<dst MAC>", val)
+	}
+
+	var a odp.PushEthAction
+	for _, addr := range []struct {
+		str  string
+		dest *[ETH_ALEN]byte
+	}{
+		{srcStr, &a.EthSrc},
+		{dstStr, &a.EthDst},
+	} {
+		mac, err := net.ParseMAC(addr.str)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(mac) != ETH_ALEN {
+			return nil, fmt.Errorf("bad ethernet address \"%s\"", addr.str)
+		}
+
+		copy(addr.dest[:], mac)
+	}
+
+	return constActions(a), nil
+}
+
+func parsePopEthOption(val string) (actionMaker, error) {
+	return constActions(odp.NewPopEthAction()), nil
+}
+
+func parseMeterOption(val string) (actionMaker, error) {
+	id, err := strconv.ParseUint(val, 0, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return constActions(odp.NewMeterAction(uint32(id))), nil
+}
+
+func parseDropOption(val string) (actionMaker, error) {
+	var reason uint64
+	if val != "true" {
+		var err error
+		reason, err = strconv.ParseUint(val, 0, 32)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return constActions(odp.NewDropAction(uint32(reason))), nil
+}
+
+func constActions(as ...odp.Action) actionMaker {
+	return func(*odp.DatapathHandle) ([]odp.Action, error) {
+		return as, nil
+	}
+}
+
+func parsePushVlanOption(val string) (actionMaker, error) {
+	parts := strings.Split(val, ",")
+	vid, err := strconv.ParseUint(parts[0], 0, 12)
+	if err != nil {
+		return nil, err
+	}
+
+	a := odp.NewPushVlanAction(odp.ETH_P_8021Q, uint16(vid), 0)
+	for _, part := range parts[1:] {
+		var bits int
+		i := strings.Index(part, "=")
+		if i >= 0 {
+			switch part[:i] {
+			case "pcp":
+				bits = 3
+			case "tpid":
+				bits = 16
+			}
+		}
+
+		if bits == 0 {
+			return nil, fmt.Errorf("unknown push-vlan parameter \"%s\"", part)
+		}
+
+		x, err := strconv.ParseUint(part[i+1:], 0, bits)
+		if err != nil {
+			return nil, err
+		}
+
+		if bits == 3 {
+			a.Pcp = uint8(x)
+		} else {
+			a.Tpid = uint16(x)
+		}
+	}
+
+	return constActions(a), nil
+}
+
+func parsePopVlanOption(val string) (actionMaker, error) {
+	pop, err := strconv.ParseBool(val)
+	if err != nil || !pop {
+		return constActions(), err
+	}
+
+	return constActions(odp.NewPopVlanAction()), nil
+}
+
+func parsePushMplsOption(val string) (actionMaker, error) {
+	parts := strings.Split(val, ",")
+	label, err := strconv.ParseUint(parts[0], 0, 20)
+	if err != nil {
+		return nil, err
+	}
+
+	a := odp.NewPushMplsAction(odp.ETH_P_MPLS_UC, uint32(label), 0, true, 64)
+	for _, part := range parts[1:] {
+		i := strings.Index(part, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("unknown push-mpls parameter \"%s\"", part)
+		}
+
+		var bits int
+		switch part[:i] {
+		case "tc":
+			bits = 3
+		case "ttl":
+			bits = 8
+		case "bos":
+			bits = 1
+		case "ethertype":
+			bits = 16
+		default:
+			return nil, fmt.Errorf("unknown push-mpls parameter \"%s\"", part)
+		}
+
+		x, err := strconv.ParseUint(part[i+1:], 0, bits)
+		if err != nil {
+			return nil, err
+		}
+
+		switch part[:i] {
+		case "tc":
+			a.TC = uint8(x)
+		case "ttl":
+			a.TTL = uint8(x)
+		case "bos":
+			a.BOS = x != 0
+		case "ethertype":
+			a.Ethertype = uint16(x)
+		}
+	}
+
+	return constActions(a), nil
+}
+
+func parsePopMplsOption(val string) (actionMaker, error) {
+	ethertype, err := strconv.ParseUint(val, 0, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	return constActions(odp.NewPopMplsAction(uint16(ethertype))), nil
+}
+
+var hashAlgNames = map[string]uint32{
+	"l4":     odp.OVS_HASH_ALG_L4,
+	"sym-l4": odp.OVS_HASH_ALG_SYM_L4,
+}
+
+func hashAlgName(alg uint32) string {
+	for name, a := range hashAlgNames {
+		if a == alg {
+			return name
+		}
+	}
+
+	return strconv.FormatUint(uint64(alg), 10)
+}
+
+func parseHashOption(val string) (actionMaker, error) {
+	parts := strings.Split(val, ",")
+	alg, ok := hashAlgNames[parts[0]]
+	if !ok {
+		a, err := strconv.ParseUint(parts[0], 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("unknown hash algorithm \"%s\"", parts[0])
+		}
+		alg = uint32(a)
+	}
+
+	var basis uint64
+	for _, part := range parts[1:] {
+		var err error
+		switch {
+		case strings.HasPrefix(part, "basis="):
+			basis, err = strconv.ParseUint(part[6:], 0, 32)
+		default:
+			err = fmt.Errorf("unknown hash parameter \"%s\"", part)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return constActions(odp.NewHashAction(alg, uint32(basis))), nil
+}
+
+func parseRecircOption(val string) (actionMaker, error) {
+	id, err := strconv.ParseUint(val, 0, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return constActions(odp.NewRecircAction(uint32(id))), nil
+}
+
+func handleRecircFlowKeyOptions(flow odp.FlowSpec, recircId string, dpHash string) error {
+	if recircId != "" {
+		id, mask, err := parseMaskedUint(recircId, 32)
+		if err != nil {
+			return err
+		}
+
+		fk := odp.NewRecircIdFlowKey()
+		fk.SetMaskedRecircId(uint32(id), uint32(mask))
+		flow.AddKey(fk)
+	}
+
+	if dpHash != "" {
+		hash, mask, err := parseMaskedUint(dpHash, 32)
+		if err != nil {
+			return err
+		}
+
+		fk := odp.NewDpHashFlowKey()
+		fk.SetMaskedDpHash(uint32(hash), uint32(mask))
+		flow.AddKey(fk)
+	}
+
+	return nil
+}
+
+func handleEthernetFlowKeyOptions(flow odp.FlowSpec, src string, dst string) error {
+	var err error
+	takeErr := func(key [ETH_ALEN]byte, mask [ETH_ALEN]byte,
+		e error) ([ETH_ALEN]byte, [ETH_ALEN]byte) {
+		err = e
+		return key, mask
+	}
+
+	fk := odp.NewEthernetFlowKey()
+
+	fk.SetMaskedEthSrc(takeErr(handleEthernetAddrOption(src)))
+	fk.SetMaskedEthDst(takeErr(handleEthernetAddrOption(dst)))
+
+	if err != nil {
+		return err
+	}
+
+	flow.AddKey(fk)
+	return nil
+}
+
+const ETH_ALEN = odp.ETH_ALEN
+
+func handleEthernetAddrOption(opt string) (key [ETH_ALEN]byte, mask [ETH_ALEN]byte, err error) {
+	if opt != "" {
+		var k, m string
+		i := strings.Index(opt, "&")
+		if i > 0 {
+			k = opt[:i]
+			m = opt[i+1:]
+		} else {
+			k = opt
+			m = "ff:ff:ff:ff:ff:ff"
 		}
 
 		key, err = parseMAC(k)
 		if err != nil {
-			return
+			return
+		}
+
+		mask, err = parseMAC(m)
+	}
+
+	return
+}
+
+// Parse an integer option that may include a mask, in the form
+// "<value>&<mask>"
+func parseMaskedUint(opt string, bits int) (val uint64, mask uint64, err error) {
+	mask = 1<<uint(bits) - 1
+	if i := strings.Index(opt, "&"); i >= 0 {
+		mask, err = strconv.ParseUint(opt[i+1:], 0, bits)
+		if err != nil {
+			return
+		}
+
+		opt = opt[:i]
+	}
+
+	val, err = strconv.ParseUint(opt, 0, bits)
+	return
+}
+
+// Parse an IPv4 address option, which may be followed by a prefix
+// length ("/<length>") or a mask ("&<mask>")
+func parseIpv4Option(opt string) (addr [4]byte, mask [4]byte, err error) {
+	mask = [4]byte{0xff, 0xff, 0xff, 0xff}
+
+	if i := strings.Index(opt, "/"); i >= 0 {
+		var ipnet *net.IPNet
+		_, ipnet, err = net.ParseCIDR(opt)
+		if err != nil {
+			return
+		}
+
+		ones, bits := ipnet.Mask.Size()
+		if bits != 32 {
+			err = fmt.Errorf("invalid IPv4 prefix \"%s\"", opt)
+			return
+		}
+
+		copy(mask[:], net.CIDRMask(ones, 32))
+		opt = opt[:i]
+	} else if i := strings.Index(opt, "&"); i >= 0 {
+		mask, err = parseIpv4(opt[i+1:])
+		if err != nil {
+			return
+		}
+
+		opt = opt[:i]
+	}
+
+	addr, err = parseIpv4(opt)
+	return
+}
+
+var fragTypes = map[string]uint8{
+	"no":    odp.OVS_FRAG_TYPE_NONE,
+	"first": odp.OVS_FRAG_TYPE_FIRST,
+	"later": odp.OVS_FRAG_TYPE_LATER,
+}
+
+func fragTypeName(frag uint8) string {
+	for name, f := range fragTypes {
+		if f == frag {
+			return name
+		}
+	}
+
+	return strconv.Itoa(int(frag))
+}
+
+// The fragment type can be given by name, or numerically with a mask
+func handleFragOption(opt string, set func(uint8, uint8)) error {
+	if frag, ok := fragTypes[opt]; ok {
+		set(frag, 0xff)
+		return nil
+	}
+
+	return handleUint8Option(opt, set)
+}
+
+type ipFlags struct {
+	ipv4Src   string
+	ipv4Dst   string
+	ipv6Src   string
+	ipv6Dst   string
+	ipv6Label string
+	proto     string
+	tos       string
+	ttl       string
+	frag      string
+
+	// Set to 4 or 6 when other options imply an IP version
+	version int
+}
+
+func addIPFlags(f Flags, ipf *ipFlags) {
+	f.StringVar(&ipf.ipv4Src, "ipv4-src", "", "key: IPv4 source address, with optional /prefix or &mask")
+	f.StringVar(&ipf.ipv4Dst, "ipv4-dst", "", "key: IPv4 destination address, with optional /prefix or &mask")
+	f.StringVar(&ipf.ipv6Src, "ipv6-src", "", "key: IPv6 source address, with optional /prefix or &mask")
+	f.StringVar(&ipf.ipv6Dst, "ipv6-dst", "", "key: IPv6 destination address, with optional /prefix or &mask")
+	f.StringVar(&ipf.ipv6Label, "ipv6-label", "", "key: IPv6 flow label")
+	f.StringVar(&ipf.proto, "ip-proto", "", "key: IP protocol")
+	f.StringVar(&ipf.tos, "ip-tos", "", "key: IP ToS (traffic class for IPv6)")
+	f.StringVar(&ipf.ttl, "ip-ttl", "", "key: IP TTL (hop limit for IPv6)")
+	f.StringVar(&ipf.frag, "ip-frag", "", "key: IP fragment type (no, first or later)")
+}
+
+// Apply a masked uint8 option through a flow key setter
+func handleUint8Option(opt string, set func(uint8, uint8)) error {
+	if opt == "" {
+		return nil
+	}
+
+	val, mask, err := parseMaskedUint(opt, 8)
+	if err != nil {
+		return err
+	}
+
+	set(uint8(val), uint8(mask))
+	return nil
+}
+
+func handleIPFlowKeyOptions(flow odp.FlowSpec, ethType string, ipf *ipFlags) error {
+	ethertype := -1
+	if ethType != "" {
+		et, err := strconv.ParseUint(ethType, 0, 16)
+		if err != nil {
+			return err
+		}
+
+		ethertype = int(et)
+	}
+
+	// The generic --ip-* options apply to IPv6 if any IPv6
+	// options are given, or the ethertype says so
+	var fk odp.FlowKey
+	var ipEthertype int
+	var err error
+	if ipf.ipv6Src != "" || ipf.ipv6Dst != "" || ipf.ipv6Label != "" ||
+		ethertype == ETH_P_IPV6 || ipf.version == 6 {
+		if ipf.ipv4Src != "" || ipf.ipv4Dst != "" || ipf.version == 4 {
+			return fmt.Errorf("IPv4 and IPv6 options cannot be combined")
+		}
+
+		fk, err = makeIPv6FlowKey(ipf)
+		ipEthertype = ETH_P_IPV6
+	} else {
+		fk, err = makeIPv4FlowKey(ipf)
+		ipEthertype = ETH_P_IP
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !fk.Ignored() {
+		// IP flow keys are only accepted by the kernel with
+		// the corresponding ethertype
+		if ethertype >= 0 && ethertype != ipEthertype {
+			return fmt.Errorf("IP options conflict with ethertype 0x%04x", ethertype)
+		}
+
+		ethertype = ipEthertype
+		flow.AddKey(fk)
+	}
+
+	if ethertype >= 0 {
+		flow.AddKey(odp.NewEthertypeFlowKey(uint16(ethertype)))
+	}
+
+	return nil
+}
+
+// Apply the options common to IPv4 and IPv6
+func handleIPOptions(ipf *ipFlags, proto, tos, ttl, frag func(uint8, uint8)) error {
+	for _, o := range []struct {
+		opt string
+		set func(uint8, uint8)
+	}{
+		{ipf.proto, proto},
+		{ipf.tos, tos},
+		{ipf.ttl, ttl},
+	} {
+		if err := handleUint8Option(o.opt, o.set); err != nil {
+			return err
+		}
+	}
+
+	return handleFragOption(ipf.frag, frag)
+}
+
+func makeIPv4FlowKey(ipf *ipFlags) (odp.FlowKey, error) {
+	fk := odp.NewIPv4FlowKey()
+
+	if ipf.ipv4Src != "" {
+		addr, mask, err := parseIpv4Option(ipf.ipv4Src)
+		if err != nil {
+			return nil, err
+		}
+
+		fk.SetMaskedIpv4Src(addr, mask)
+	}
+
+	if ipf.ipv4Dst != "" {
+		addr, mask, err := parseIpv4Option(ipf.ipv4Dst)
+		if err != nil {
+			return nil, err
+		}
+
+		fk.SetMaskedIpv4Dst(addr, mask)
+	}
+
+	err := handleIPOptions(ipf, fk.SetMaskedProto, fk.SetMaskedTos,
+		fk.SetMaskedTtl, fk.SetMaskedFrag)
+	return fk, err
+}
+
+func makeIPv6FlowKey(ipf *ipFlags) (odp.FlowKey, error) {
+	fk := odp.NewIPv6FlowKey()
+
+	if ipf.ipv6Src != "" {
+		addr, mask, err := parseIpv6Option(ipf.ipv6Src)
+		if err != nil {
+			return nil, err
+		}
+
+		fk.SetMaskedIpv6Src(addr, mask)
+	}
+
+	if ipf.ipv6Dst != "" {
+		addr, mask, err := parseIpv6Option(ipf.ipv6Dst)
+		if err != nil {
+			return nil, err
+		}
+
+		fk.SetMaskedIpv6Dst(addr, mask)
+	}
+
+	if ipf.ipv6Label != "" {
+		label, mask, err := parseMaskedUint(ipf.ipv6Label, 20)
+		if err != nil {
+			return nil, err
+		}
+
+		fk.SetMaskedLabel(uint32(label), uint32(mask))
+	}
+
+	err := handleIPOptions(ipf, fk.SetMaskedProto, fk.SetMaskedTclass,
+		fk.SetMaskedHlimit, fk.SetMaskedFrag)
+	return fk, err
+}
+
+// Parse an IPv6 address option, which may be followed by a prefix
+// length ("/<length>") or a mask ("&<mask>")
+func parseIpv6Option(opt string) (addr netip.Addr, mask [16]byte, err error) {
+	if strings.Contains(opt, "/") {
+		var prefix netip.Prefix
+		prefix, err = netip.ParsePrefix(opt)
+		if err != nil {
+			return
+		}
+
+		if !prefix.Addr().Is6() {
+			err = fmt.Errorf("invalid IPv6 prefix \"%s\"", opt)
+			return
+		}
+
+		copy(mask[:], net.CIDRMask(prefix.Bits(), 128))
+		addr = prefix.Masked().Addr()
+		return
+	}
+
+	setBytes(mask[:], 0xff)
+	if i := strings.Index(opt, "&"); i >= 0 {
+		var m netip.Addr
+		m, err = parseIpv6(opt[i+1:])
+		if err != nil {
+			return
+		}
+
+		mask = m.As16()
+		opt = opt[:i]
+	}
+
+	addr, err = parseIpv6(opt)
+	return
+}
+
+func parseIpv6(s string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(s)
+	if err != nil || !addr.Is6() {
+		return addr, fmt.Errorf("invalid IPv6 address \"%s\"", s)
+	}
+
+	return addr, nil
+}
+
+const (
+	ETH_P_IP   = 0x0800
+	ETH_P_IPV6 = 0x86dd
+)
+
+const (
+	IPPROTO_ICMP   = 1
+	IPPROTO_TCP    = 6
+	IPPROTO_UDP    = 17
+	IPPROTO_ICMPV6 = 58
+	IPPROTO_SCTP   = 132
+)
+
+type icmpSetters interface {
+	SetMaskedType(uint8, uint8)
+	SetMaskedCode(uint8, uint8)
+}
+
+func handleIcmpOptions(fk icmpSetters, typ string, code string) error {
+	if err := handleUint8Option(typ, fk.SetMaskedType); err != nil {
+		return err
+	}
+
+	return handleUint8Option(code, fk.SetMaskedCode)
+}
+
+func makeNdFlowKey(l4f *l4Flags) (odp.NdFlowKey, error) {
+	fk := odp.NewNdFlowKey()
+
+	if l4f.ndTarget != "" {
+		addr, mask, err := parseIpv6Option(l4f.ndTarget)
+		if err != nil {
+			return fk, err
+		}
+
+		fk.SetMaskedTarget(addr, mask)
+	}
+
+	var err error
+	takeErr := func(key [ETH_ALEN]byte, mask [ETH_ALEN]byte,
+		e error) ([ETH_ALEN]byte, [ETH_ALEN]byte) {
+		if err == nil {
+			err = e
+		}
+		return key, mask
+	}
+
+	fk.SetMaskedSll(takeErr(handleEthernetAddrOption(l4f.ndSll)))
+	fk.SetMaskedTll(takeErr(handleEthernetAddrOption(l4f.ndTll)))
+	return fk, err
+}
+
+type arpFlags struct {
+	sip string
+	tip string
+	op  string
+	sha string
+	tha string
+}
+
+func addArpFlags(f Flags, arpf *arpFlags) {
+	f.StringVar(&arpf.sip, "arp-sip", "", "key: ARP sender IPv4 address")
+	f.StringVar(&arpf.tip, "arp-tip", "", "key: ARP target IPv4 address")
+	f.StringVar(&arpf.op, "arp-op", "", "key: ARP opcode")
+	f.StringVar(&arpf.sha, "arp-sha", "", "key: ARP sender hardware address")
+	f.StringVar(&arpf.tha, "arp-tha", "", "key: ARP target hardware address")
+}
+
+const ETH_P_ARP = 0x0806
+
+// The VLAN options take comma-separated lists, one element per tag,
+// outermost first.  A single tag uses the 802.1Q TPID, and for QinQ
+// the outer tags use the 802.1ad TPID.  Empty elements leave the
+// field of that tag wildcarded.
+func handleVlanFlowKeyOptions(flow odp.FlowSpec, vid string, pcp string) error {
+	if vid == "" && pcp == "" {
+		return nil
+	}
+
+	vids := strings.Split(vid, ",")
+	pcps := strings.Split(pcp, ",")
+	n := len(vids)
+	if len(pcps) > n {
+		n = len(pcps)
+	}
+
+	vlans := make([]odp.VlanFlowKey, n)
+	for i := range vlans {
+		vlans[i] = odp.NewVlanFlowKey()
+
+		if i < len(vids) && vids[i] != "" {
+			v, m, err := parseMaskedUint(vids[i], 12)
+			if err != nil {
+				return err
+			}
+
+			vlans[i].SetMaskedVid(uint16(v), uint16(m))
+		}
+
+		if i < len(pcps) && pcps[i] != "" {
+			p, m, err := parseMaskedUint(pcps[i], 3)
+			if err != nil {
+				return err
+			}
+
+			vlans[i].SetMaskedPcp(uint8(p), uint8(m))
+		}
+	}
+
+	// Wrap the flow keys from the innermost tag outwards
+	for i := n - 1; i >= 0; i-- {
+		tpid := uint16(odp.ETH_P_8021AD)
+		if i == n-1 {
+			tpid = odp.ETH_P_8021Q
+		}
+
+		flow.AddVlan(tpid, vlans[i])
+	}
+
+	return nil
+}
+
+// ARP options imply the ARP ethertype
+func handleArpFlowKeyOptions(flow odp.FlowSpec, arpf *arpFlags, ethType *string) error {
+	fk := odp.NewArpFlowKey()
+
+	for _, o := range []struct {
+		opt string
+		set func([4]byte, [4]byte)
+	}{
+		{arpf.sip, fk.SetMaskedSip},
+		{arpf.tip, fk.SetMaskedTip},
+	} {
+		if o.opt == "" {
+			continue
+		}
+
+		addr, mask, err := parseIpv4Option(o.opt)
+		if err != nil {
+			return err
+		}
+
+		o.set(addr, mask)
+	}
+
+	if err := handlePortOption(arpf.op, fk.SetMaskedOp); err != nil {
+		return err
+	}
+
+	var err error
+	takeErr := func(key [ETH_ALEN]byte, mask [ETH_ALEN]byte,
+		e error) ([ETH_ALEN]byte, [ETH_ALEN]byte) {
+		if err == nil {
+			err = e
+		}
+		return key, mask
+	}
+
+	fk.SetMaskedSha(takeErr(handleEthernetAddrOption(arpf.sha)))
+	fk.SetMaskedTha(takeErr(handleEthernetAddrOption(arpf.tha)))
+	if err != nil {
+		return err
+	}
+
+	if fk.Ignored() {
+		return nil
+	}
+
+	if *ethType == "" {
+		*ethType = strconv.Itoa(ETH_P_ARP)
+	} else if et, err := strconv.ParseUint(*ethType, 0, 16); err != nil || et != ETH_P_ARP {
+		return fmt.Errorf("ARP options conflict with --eth-type=%s", *ethType)
+	}
+
+	flow.AddKey(fk)
+	return nil
+}
+
+// Like the VLAN options, the MPLS options take comma-separated
+// lists, one element per label stack entry, outermost first.
+type mplsFlags struct {
+	label string
+	tc    string
+	bos   string
+	ttl   string
+}
+
+func addMplsFlags(f Flags, mplsf *mplsFlags) {
+	f.StringVar(&mplsf.label, "mpls-label", "", "key: MPLS labels, outermost first")
+	f.StringVar(&mplsf.tc, "mpls-tc", "", "key: MPLS traffic classes, outermost first")
+	f.StringVar(&mplsf.bos, "mpls-bos", "", "key: MPLS bottom of stack bits, outermost first")
+	f.StringVar(&mplsf.ttl, "mpls-ttl", "", "key: MPLS TTLs, outermost first")
+}
+
+// MPLS options imply the MPLS unicast ethertype
+func handleMplsFlowKeyOptions(flow odp.FlowSpec, mplsf *mplsFlags, ethType *string) error {
+	fields := []struct {
+		opt  string
+		bits int
+		set  func(fk *odp.MplsFlowKey, i int, v uint64, m uint64)
+	}{
+		{mplsf.label, 20, func(fk *odp.MplsFlowKey, i int, v uint64, m uint64) {
+			fk.SetMaskedLabel(i, uint32(v), uint32(m))
+		}},
+		{mplsf.tc, 3, func(fk *odp.MplsFlowKey, i int, v uint64, m uint64) {
+			fk.SetMaskedTC(i, uint8(v), uint8(m))
+		}},
+		{mplsf.bos, 1, func(fk *odp.MplsFlowKey, i int, v uint64, m uint64) {
+			if m != 0 {
+				fk.SetBOS(i, v != 0)
+			}
+		}},
+		{mplsf.ttl, 8, func(fk *odp.MplsFlowKey, i int, v uint64, m uint64) {
+			fk.SetMaskedTTL(i, uint8(v), uint8(m))
+		}},
+	}
+
+	depth := 0
+	lists := make([][]string, len(fields))
+	for j, field := range fields {
+		if field.opt == "" {
+			continue
+		}
+
+		lists[j] = strings.Split(field.opt, ",")
+		if len(lists[j]) > depth {
+			depth = len(lists[j])
+		}
+	}
+
+	if depth == 0 {
+		return nil
+	}
+
+	fk := odp.NewMplsFlowKey(depth)
+	for j, field := range fields {
+		for i, s := range lists[j] {
+			if s == "" {
+				continue
+			}
+
+			v, m, err := parseMaskedUint(s, field.bits)
+			if err != nil {
+				return err
+			}
+
+			field.set(&fk, i, v, m)
+		}
+	}
+
+	if *ethType == "" {
+		*ethType = strconv.Itoa(odp.ETH_P_MPLS_UC)
+	} else if et, err := strconv.ParseUint(*ethType, 0, 16); err != nil ||
+		(et != odp.ETH_P_MPLS_UC && et != odp.ETH_P_MPLS_MC) {
+		return fmt.Errorf("MPLS options conflict with --eth-type=%s", *ethType)
+	}
+
+	flow.AddKey(fk)
+	return nil
+}
+
+type l4Flags struct {
+	tcpSrc   string
+	tcpDst   string
+	udpSrc   string
+	udpDst   string
+	sctpSrc  string
+	sctpDst  string
+	tcpFlags string
+
+	icmpType   string
+	icmpCode   string
+	icmpv6Type string
+	icmpv6Code string
+	ndTarget   string
+	ndSll      string
+	ndTll      string
+}
+
+func addL4Flags(f Flags, l4f *l4Flags) {
+	f.StringVar(&l4f.tcpSrc, "tcp-src", "", "key: TCP source port")
+	f.StringVar(&l4f.tcpDst, "tcp-dst", "", "key: TCP destination port")
+	f.StringVar(&l4f.udpSrc, "udp-src", "", "key: UDP source port")
+	f.StringVar(&l4f.udpDst, "udp-dst", "", "key: UDP destination port")
+	f.StringVar(&l4f.sctpSrc, "sctp-src", "", "key: SCTP source port")
+	f.StringVar(&l4f.sctpDst, "sctp-dst", "", "key: SCTP destination port")
+	f.StringVar(&l4f.tcpFlags, "tcp-flags", "", "key: TCP flags, numerically or as e.g. +syn-ack")
+	f.StringVar(&l4f.icmpType, "icmp-type", "", "key: ICMP type")
+	f.StringVar(&l4f.icmpCode, "icmp-code", "", "key: ICMP code")
+	f.StringVar(&l4f.icmpv6Type, "icmpv6-type", "", "key: ICMPv6 type")
+	f.StringVar(&l4f.icmpv6Code, "icmpv6-code", "", "key: ICMPv6 code")
+	f.StringVar(&l4f.ndTarget, "nd-target", "", "key: neighbor discovery target address")
+	f.StringVar(&l4f.ndSll, "nd-sll", "", "key: neighbor discovery source link-layer address")
+	f.StringVar(&l4f.ndTll, "nd-tll", "", "key: neighbor discovery target link-layer address")
+}
+
+// Apply a masked port option through a flow key setter
+func handlePortOption(opt string, set func(uint16, uint16)) error {
+	if opt == "" {
+		return nil
+	}
+
+	port, mask, err := parseMaskedUint(opt, 16)
+	if err != nil {
+		return err
+	}
+
+	set(uint16(port), uint16(mask))
+	return nil
+}
+
+// Transport layer options imply the IP protocol, which gets filled
+// into the IP options
+func handleL4FlowKeyOptions(flow odp.FlowSpec, l4f *l4Flags, ipf *ipFlags) error {
+	var keys []odp.FlowKey
+	proto := -1
+
+	setProto := func(p int) error {
+		if proto >= 0 && proto != p {
+			return fmt.Errorf("options for more than one transport protocol")
+		}
+
+		proto = p
+		return nil
+	}
+
+	tcp := odp.NewTcpFlowKey()
+	udp := odp.NewUdpFlowKey()
+	sctp := odp.NewSctpFlowKey()
+	for _, o := range []struct {
+		src    string
+		dst    string
+		fk     odp.FlowKey
+		proto  int
+		setSrc func(uint16, uint16)
+		setDst func(uint16, uint16)
+	}{
+		{l4f.tcpSrc, l4f.tcpDst, tcp, IPPROTO_TCP, tcp.SetMaskedSrc, tcp.SetMaskedDst},
+		{l4f.udpSrc, l4f.udpDst, udp, IPPROTO_UDP, udp.SetMaskedSrc, udp.SetMaskedDst},
+		{l4f.sctpSrc, l4f.sctpDst, sctp, IPPROTO_SCTP, sctp.SetMaskedSrc, sctp.SetMaskedDst},
+	} {
+		if o.src == "" && o.dst == "" {
+			continue
+		}
+
+		if err := handlePortOption(o.src, o.setSrc); err != nil {
+			return err
+		}
+
+		if err := handlePortOption(o.dst, o.setDst); err != nil {
+			return err
+		}
+
+		if err := setProto(o.proto); err != nil {
+			return err
+		}
+
+		keys = append(keys, o.fk)
+	}
+
+	if l4f.tcpFlags != "" {
+		flags, mask, err := parseTcpFlags(l4f.tcpFlags)
+		if err != nil {
+			return err
+		}
+
+		if err := setProto(IPPROTO_TCP); err != nil {
+			return err
+		}
+
+		fk := odp.NewTcpFlagsFlowKey()
+		fk.SetMaskedFlags(flags, mask)
+		keys = append(keys, fk)
+	}
+
+	if l4f.icmpType != "" || l4f.icmpCode != "" {
+		fk := odp.NewIcmpFlowKey()
+		if err := handleIcmpOptions(&fk, l4f.icmpType, l4f.icmpCode); err != nil {
+			return err
+		}
+
+		if err := setProto(IPPROTO_ICMP); err != nil {
+			return err
+		}
+
+		ipf.version = 4
+		keys = append(keys, fk)
+	}
+
+	if l4f.icmpv6Type != "" || l4f.icmpv6Code != "" {
+		fk := odp.NewIcmpv6FlowKey()
+		if err := handleIcmpOptions(&fk, l4f.icmpv6Type, l4f.icmpv6Code); err != nil {
+			return err
+		}
+
+		if err := setProto(IPPROTO_ICMPV6); err != nil {
+			return err
+		}
+
+		ipf.version = 6
+		keys = append(keys, fk)
+
+		nd, err := makeNdFlowKey(l4f)
+		if err != nil {
+			return err
+		}
+
+		if !nd.Ignored() {
+			// The kernel only parses ND options for
+			// neighbor solicitations and advertisements
+			k := fk.Key()
+			m := fk.Mask()
+			if m.IcmpType != 0xff || (k.IcmpType != odp.ND_NEIGHBOR_SOLICIT && k.IcmpType != odp.ND_NEIGHBOR_ADVERT) {
+				return fmt.Errorf("neighbor discovery options require --icmpv6-type=%d or %d", odp.ND_NEIGHBOR_SOLICIT, odp.ND_NEIGHBOR_ADVERT)
+			}
+
+			keys = append(keys, nd)
+		}
+	} else if l4f.ndTarget != "" || l4f.ndSll != "" || l4f.ndTll != "" {
+		return fmt.Errorf("neighbor discovery options require --icmpv6-type=%d or %d", odp.ND_NEIGHBOR_SOLICIT, odp.ND_NEIGHBOR_ADVERT)
+	}
+
+	if proto < 0 {
+		return nil
+	}
+
+	if ipf.proto == "" {
+		ipf.proto = strconv.Itoa(proto)
+	} else {
+		p, mask, err := parseMaskedUint(ipf.proto, 8)
+		if err != nil {
+			return err
+		}
+
+		if int(p) != proto || mask != 0xff {
+			return fmt.Errorf("--ip-proto=%s conflicts with transport protocol options", ipf.proto)
+		}
+	}
+
+	for _, fk := range keys {
+		flow.AddKey(fk)
+	}
+
+	return nil
+}
+
+type flagName struct {
+	name string
+	flag uint32
+}
+
+var tcpFlagNames = []flagName{
+	{"fin", odp.TCP_FLAG_FIN},
+	{"syn", odp.TCP_FLAG_SYN},
+	{"rst", odp.TCP_FLAG_RST},
+	{"psh", odp.TCP_FLAG_PSH},
+	{"ack", odp.TCP_FLAG_ACK},
+	{"urg", odp.TCP_FLAG_URG},
+	{"ece", odp.TCP_FLAG_ECE},
+	{"cwr", odp.TCP_FLAG_CWR},
+	{"ns", odp.TCP_FLAG_NS},
+}
+
+// TCP flags can be given numerically ("<flags>[&<mask>]"), or as a
+// sequence of flag names each preceded by "+" (flag must be set) or
+// "-" (flag must be clear), e.g. "+syn-ack".
+func parseTcpFlags(opt string) (flags uint16, mask uint16, err error) {
+	f, m, err := parseNamedFlags(opt, tcpFlagNames, 12, "TCP flag")
+	return uint16(f), uint16(m), err
+}
+
+func parseNamedFlags(opt string, names []flagName, bits int, what string) (flags uint32, mask uint32, err error) {
+	if opt[0] != '+' && opt[0] != '-' {
+		var f, m uint64
+		f, m, err = parseMaskedUint(opt, bits)
+		return uint32(f), uint32(m), err
+	}
+
+	for opt != "" {
+		set := opt[0] == '+'
+		if !set && opt[0] != '-' {
+			return 0, 0, fmt.Errorf("expected + or - in %ss, got \"%s\"", what, opt)
+		}
+
+		opt = opt[1:]
+		end := strings.IndexAny(opt, "+-")
+		if end < 0 {
+			end = len(opt)
+		}
+
+		name := opt[:end]
+		opt = opt[end:]
+
+		found := false
+		for _, f := range names {
+			if f.name == name {
+				mask |= f.flag
+				if set {
+					flags |= f.flag
+				}
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return 0, 0, fmt.Errorf("unknown %s \"%s\"", what, name)
+		}
+	}
+
+	return
+}
+
+var ctStateNames = []flagName{
+	{"new", odp.OVS_CS_F_NEW},
+	{"est", odp.OVS_CS_F_ESTABLISHED},
+	{"rel", odp.OVS_CS_F_RELATED},
+	{"rpl", odp.OVS_CS_F_REPLY_DIR},
+	{"inv", odp.OVS_CS_F_INVALID},
+	{"trk", odp.OVS_CS_F_TRACKED},
+	{"snat", odp.OVS_CS_F_SRC_NAT},
+	{"dnat", odp.OVS_CS_F_DST_NAT},
+}
+
+type ctFlags struct {
+	state  string
+	zone   string
+	mark   string
+	labels string
+
+	origSrc   string
+	origDst   string
+	origTpSrc string
+	origTpDst string
+	origProto string
+}
+
+func addCtFlags(f Flags, ctf *ctFlags) {
+	f.StringVar(&ctf.state, "ct-state", "", "key: connection tracking state, numerically or as e.g. +trk+est")
+	f.StringVar(&ctf.zone, "ct-zone", "", "key: connection tracking zone")
+	f.StringVar(&ctf.mark, "ct-mark", "", "key: connection tracking mark")
+	f.StringVar(&ctf.labels, "ct-labels", "", "key: connection tracking labels (hex)")
+	f.StringVar(&ctf.origSrc, "ct-orig-src", "", "key: connection original direction source address")
+	f.StringVar(&ctf.origDst, "ct-orig-dst", "", "key: connection original direction destination address")
+	f.StringVar(&ctf.origTpSrc, "ct-orig-tp-src", "", "key: connection original direction source port")
+	f.StringVar(&ctf.origTpDst, "ct-orig-tp-dst", "", "key: connection original direction destination port")
+	f.StringVar(&ctf.origProto, "ct-orig-proto", "", "key: connection original direction IP protocol")
+}
+
+// Parse connection tracking labels given in hex, with an optional
+// mask ("<labels>[&<mask>]").  Short values are zero-extended on the
+// left.
+func parseCtLabelsOption(opt string) (labels [odp.OVS_CT_LABELS_LEN]byte, mask [odp.OVS_CT_LABELS_LEN]byte, err error) {
+	parse := func(s string, dest []byte) error {
+		s = strings.TrimPrefix(s, "0x")
+		if len(s)%2 != 0 {
+			s = "0" + s
+		}
+
+		x, err := hex.DecodeString(s)
+		if err != nil {
+			return err
+		}
+
+		if len(x) > len(dest) {
+			return fmt.Errorf("connection tracking labels \"%s\" too long", s)
+		}
+
+		copy(dest[len(dest)-len(x):], x)
+		return nil
+	}
+
+	val := opt
+	if i := strings.Index(opt, "&"); i >= 0 {
+		val = opt[:i]
+		err = parse(opt[i+1:], mask[:])
+	} else {
+		for i := range mask {
+			mask[i] = 0xff
+		}
+	}
+
+	if err == nil {
+		err = parse(val, labels[:])
+	}
+
+	return
+}
+
+func handleCtFlowKeyOptions(flow odp.FlowSpec, ctf *ctFlags) error {
+	if ctf.state != "" {
+		state, mask, err := parseNamedFlags(ctf.state, ctStateNames, 8, "ct_state flag")
+		if err != nil {
+			return err
+		}
+
+		fk := odp.NewCtStateFlowKey()
+		fk.SetMaskedState(state, mask)
+		flow.AddKey(fk)
+	}
+
+	if ctf.zone != "" {
+		zone, mask, err := parseMaskedUint(ctf.zone, 16)
+		if err != nil {
+			return err
+		}
+
+		fk := odp.NewCtZoneFlowKey()
+		fk.SetMaskedZone(uint16(zone), uint16(mask))
+		flow.AddKey(fk)
+	}
+
+	if ctf.mark != "" {
+		mark, mask, err := parseMaskedUint(ctf.mark, 32)
+		if err != nil {
+			return err
 		}
 
-		mask, err = parseMAC(m)
+		fk := odp.NewCtMarkFlowKey()
+		fk.SetMaskedMark(uint32(mark), uint32(mask))
+		flow.AddKey(fk)
 	}
 
-	return
+	if ctf.labels != "" {
+		labels, mask, err := parseCtLabelsOption(ctf.labels)
+		if err != nil {
+			return err
+		}
+
+		fk := odp.NewCtLabelsFlowKey()
+		fk.SetMaskedLabels(labels, mask)
+		flow.AddKey(fk)
+	}
+
+	return handleCtOrigTupleOptions(flow, ctf)
+}
+
+// The original direction tuple is IPv6 if IPv6 addresses are given,
+// or the flow matches IPv6 packets
+func handleCtOrigTupleOptions(flow odp.FlowSpec, ctf *ctFlags) error {
+	if ctf.origSrc == "" && ctf.origDst == "" && ctf.origTpSrc == "" &&
+		ctf.origTpDst == "" && ctf.origProto == "" {
+		return nil
+	}
+
+	ipv6 := strings.Contains(ctf.origSrc, ":") || strings.Contains(ctf.origDst, ":")
+	if et, ok := flow.FlowKeys[odp.OVS_KEY_ATTR_ETHERTYPE].(odp.EthertypeFlowKey); ok && et.Ethertype() == ETH_P_IPV6 {
+		ipv6 = true
+	}
+
+	if ipv6 {
+		fk := odp.NewCtOrigTupleIPv6FlowKey()
+		for _, o := range []struct {
+			opt string
+			set func(netip.Addr, [16]byte)
+		}{
+			{ctf.origSrc, fk.SetMaskedIpv6Src},
+			{ctf.origDst, fk.SetMaskedIpv6Dst},
+		} {
+			if o.opt == "" {
+				continue
+			}
+
+			addr, mask, err := parseIpv6Option(o.opt)
+			if err != nil {
+				return err
+			}
+
+			o.set(addr, mask)
+		}
+
+		err := handleCtOrigTupleL4Options(ctf, fk.SetMaskedSrcPort,
+			fk.SetMaskedDstPort, fk.SetMaskedProto)
+		if err != nil {
+			return err
+		}
+
+		flow.AddKey(fk)
+		return nil
+	}
+
+	fk := odp.NewCtOrigTupleIPv4FlowKey()
+	for _, o := range []struct {
+		opt string
+		set func([4]byte, [4]byte)
+	}{
+		{ctf.origSrc, fk.SetMaskedIpv4Src},
+		{ctf.origDst, fk.SetMaskedIpv4Dst},
+	} {
+		if o.opt == "" {
+			continue
+		}
+
+		addr, mask, err := parseIpv4Option(o.opt)
+		if err != nil {
+			return err
+		}
+
+		o.set(addr, mask)
+	}
+
+	err := handleCtOrigTupleL4Options(ctf, fk.SetMaskedSrcPort,
+		fk.SetMaskedDstPort, fk.SetMaskedProto)
+	if err != nil {
+		return err
+	}
+
+	flow.AddKey(fk)
+	return nil
+}
+
+func handleCtOrigTupleL4Options(ctf *ctFlags, setSrc, setDst func(uint16, uint16),
+	setProto func(uint8, uint8)) error {
+	if err := handlePortOption(ctf.origTpSrc, setSrc); err != nil {
+		return err
+	}
+
+	if err := handlePortOption(ctf.origTpDst, setDst); err != nil {
+		return err
+	}
+
+	return handleUint8Option(ctf.origProto, setProto)
+}
+
+// The --ct option takes a comma-separated list of parameters:
+// commit, force, zone=<zone>, mark=<mark>[&<mask>],
+// labels=<hex>[&<hex>], helper=<name>, and for NAT, nat (to apply
+// an existing NAT), snat[=<range>] or dnat[=<range>], with
+// persistent, hash or random.  A NAT range is
+// <addr>[-<addr>][:<port>[-<port>]], with IPv6 addresses in brackets.
+func parseCtOption(val string) (actionMaker, error) {
+	var a odp.CtAction
+	if val == "true" {
+		return constActions(a), nil
+	}
+
+	var nat odp.CtNat
+	var hasNat, natFlags bool
+	for _, param := range strings.Split(val, ",") {
+		name, arg, hasArg := strings.Cut(param, "=")
+		var err error
+
+		switch name {
+		case "commit":
+			a.Commit = true
+		case "force":
+			a.ForceCommit = true
+		case "zone":
+			var zone uint64
+			zone, err = strconv.ParseUint(arg, 0, 16)
+			a.Zone = uint16(zone)
+		case "mark":
+			var mark, mask uint64
+			mark, mask, err = parseMaskedUint(arg, 32)
+			a.Mark = uint32(mark)
+			a.MarkMask = uint32(mask)
+		case "labels":
+			a.Labels, a.LabelsMask, err = parseCtLabelsOption(arg)
+		case "helper":
+			a.Helper = arg
+		case "eventmask":
+			var mask uint64
+			mask, err = strconv.ParseUint(arg, 0, 32)
+			a.EventMask = uint32(mask)
+			a.HasEventMask = true
+		case "timeout":
+			a.Timeout = arg
+		case "nat":
+			hasNat = true
+		case "snat", "dnat":
+			hasNat = true
+			nat.Src = name == "snat"
+			nat.Dst = name == "dnat"
+			if hasArg {
+				err = parseNatRange(arg, &nat)
+			}
+		case "persistent":
+			nat.Persistent = true
+			natFlags = true
+		case "hash":
+			nat.ProtoHash = true
+			natFlags = true
+		case "random":
+			nat.ProtoRandom = true
+			natFlags = true
+		default:
+			err = fmt.Errorf("unknown ct parameter \"%s\"", param)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if hasNat {
+		a.Nat = &nat
+	} else if natFlags {
+		return nil, fmt.Errorf("ct NAT flags given without nat, snat or dnat")
+	}
+
+	return constActions(a), nil
+}
+
+func parseNatRange(s string, nat *odp.CtNat) error {
+	addrs, ports := s, ""
+	if strings.HasPrefix(s, "[") {
+		i := strings.LastIndex(s, "]")
+		if i < 0 {
+			return fmt.Errorf("invalid NAT range \"%s\"", s)
+		}
+
+		addrs, ports = s[:i+1], s[i+1:]
+		if ports != "" {
+			if ports[0] != ':' {
+				return fmt.Errorf("invalid NAT range \"%s\"", s)
+			}
+
+			ports = ports[1:]
+		}
+	} else if i := strings.Index(s, ":"); i >= 0 {
+		addrs, ports = s[:i], s[i+1:]
+	}
+
+	parseAddr := func(a string) (netip.Addr, error) {
+		return netip.ParseAddr(strings.Trim(a, "[]"))
+	}
+
+	// The address range can be omitted to translate only ports
+	var lo, hi string
+	var hasHi bool
+	var err error
+	if addrs != "" {
+		lo, hi, hasHi = strings.Cut(addrs, "-")
+		if nat.IPMin, err = parseAddr(lo); err != nil {
+			return err
+		}
+
+		if hasHi {
+			if nat.IPMax, err = parseAddr(hi); err != nil {
+				return err
+			}
+		}
+	}
+
+	if ports == "" {
+		return nil
+	}
+
+	lo, hi, hasHi = strings.Cut(ports, "-")
+	port, err := strconv.ParseUint(lo, 0, 16)
+	if err != nil {
+		return err
+	}
+
+	nat.ProtoMin = uint16(port)
+	if hasHi {
+		port, err = strconv.ParseUint(hi, 0, 16)
+		nat.ProtoMax = uint16(port)
+	}
+
+	return err
+}
+
+func formatCtAction(a odp.CtAction) string {
+	var params []string
+	add := func(p string, f bool) {
+		if f {
+			params = append(params, p)
+		}
+	}
+
+	add("commit", a.Commit)
+	add("force", a.ForceCommit)
+	add(fmt.Sprintf("zone=%d", a.Zone), a.Zone != 0)
+
+	if a.MarkMask == 0xffffffff {
+		params = append(params, fmt.Sprintf("mark=%d", a.Mark))
+	} else {
+		add(fmt.Sprintf("mark=%d&0x%x", a.Mark, a.MarkMask), a.MarkMask != 0)
+	}
+
+	if !odp.AllBytes(a.LabelsMask[:], 0) {
+		labels := "labels=" + hex.EncodeToString(a.Labels[:])
+		if !odp.AllBytes(a.LabelsMask[:], 0xff) {
+			labels += "&" + hex.EncodeToString(a.LabelsMask[:])
+		}
+
+		params = append(params, labels)
+	}
+
+	add("helper="+a.Helper, a.Helper != "")
+	add(fmt.Sprintf("eventmask=0x%x", a.EventMask), a.HasEventMask)
+	add("timeout="+a.Timeout, a.Timeout != "")
+
+	if n := a.Nat; n != nil {
+		nat := "nat"
+		switch {
+		case n.Src:
+			nat = "snat"
+		case n.Dst:
+			nat = "dnat"
+		}
+
+		formatAddr := func(addr netip.Addr) string {
+			if addr.Is6() {
+				return "[" + addr.String() + "]"
+			}
+			return addr.String()
+		}
+
+		// The address and port ranges can each be omitted
+		if n.IPMin.IsValid() || n.ProtoMin != 0 {
+			nat += "="
+		}
+
+		if n.IPMin.IsValid() {
+			nat += formatAddr(n.IPMin)
+			if n.IPMax.IsValid() {
+				nat += "-" + formatAddr(n.IPMax)
+			}
+		}
+
+		if n.ProtoMin != 0 {
+			nat += fmt.Sprintf(":%d", n.ProtoMin)
+			if n.ProtoMax != 0 {
+				nat += fmt.Sprintf("-%d", n.ProtoMax)
+			}
+		}
+
+		params = append(params, nat)
+		add("persistent", n.Persistent)
+		add("hash", n.ProtoHash)
+		add("random", n.ProtoRandom)
+	}
+
+	return strings.Join(params, ",")
+}
+
+func printCtAction(w io.Writer, a odp.CtAction) {
+	s := formatCtAction(a)
+	switch {
+	case s == "":
+		fmt.Fprintf(w, " --ct")
+	case strings.ContainsAny(s, "&[]"):
+		fmt.Fprintf(w, " --ct=\"%s\"", s)
+	default:
+		fmt.Fprintf(w, " --ct=%s", s)
+	}
 }
 
 func addFlow(f Flags) bool {
+	var createOnly bool
+	f.BoolVar(&createOnly, "create-only", false, "fail if the flow already exists")
+
+	dpif, err := odp.NewDpif()
+	if err != nil {
+		return printErr("%s", err)
+	}
+	defer dpif.Close()
+
+	dp, flow, ok := flagsToFlowSpec(f, dpif)
+	if !ok {
+		return false
+	}
+
+	if createOnly {
+		err = dp.CreateFlowExclusive(flow)
+	} else {
+		err = dp.CreateFlow(flow)
+	}
+
+	if err != nil {
+		if odp.IsFlowAlreadyExistsError(err) {
+			return printErr("Flow already exists")
+		} else {
+			return printErr("%s", err)
+		}
+	}
+
+	return true
+}
+
+func modifyFlow(f Flags) bool {
+	var opts odp.ModifyOptions
+	f.BoolVar(&opts.ClearStats, "clear-stats", false, "clear flow statistics")
+	f.BoolVar(&opts.CreateIfMissing, "create", false, "create the flow if it does not exist")
+
+	dpif, err := odp.NewDpif()
+	if err != nil {
+		return printErr("%s", err)
+	}
+	defer dpif.Close()
+
+	dp, flow, ok := flagsToFlowSpec(f, dpif)
+	if !ok {
+		return false
+	}
+
+	err = dp.ModifyFlow(flow, opts)
+	if err != nil {
+		if odp.IsNoSuchFlowError(err) {
+			return printErr("No such flow")
+		} else {
+			return printErr("%s", err)
+		}
+	}
+
+	return true
+}
+
+func deleteFlow(f Flags) bool {
+	dpif, err := odp.NewDpif()
+	if err != nil {
+		return printErr("%s", err)
+	}
+	defer dpif.Close()
+
+	dp, flow, ok := flagsToFlowSpec(f, dpif)
+	if !ok {
+		return false
+	}
+
+	err = dp.DeleteFlow(flow.FlowKeys)
+	if err != nil {
+		if odp.IsNoSuchFlowError(err) {
+			return printErr("No such flow")
+		} else {
+			return printErr("%s", err)
+		}
+	}
+
+	return true
+}
+
+func clearFlow(f Flags) bool {
+	dpif, err := odp.NewDpif()
+	if err != nil {
+		return printErr("%s", err)
+	}
+	defer dpif.Close()
+
+	dp, flow, ok := flagsToFlowSpec(f, dpif)
+	if !ok {
+		return false
+	}
+
+	err = dp.ClearFlow(flow)
+	if err != nil {
+		if odp.IsNoSuchFlowError(err) {
+			return printErr("No such flow")
+		} else {
+			return printErr("%s", err)
+		}
+	}
+
+	return true
+}
+
+func getFlow(f Flags) bool {
+	var showStats bool
+	f.BoolVar(&showStats, "stats", false, "show statistics")
+
 	dpif, err := odp.NewDpif()
 	if err != nil {
 		return printErr("%s", err)
@@ -1006,7 +3547,26 @@ func addFlow(f Flags) bool {
 		return false
 	}
 
-	err = dp.CreateFlow(flow)
+	fi, err := dp.LookupFlow(flow.FlowKeys)
+	if err != nil {
+		if odp.IsNoSuchFlowError(err) {
+			return printErr("No such flow")
+		} else {
+			return printErr("%s", err)
+		}
+	}
+
+	dpi, err := dpif.LookupDatapathByID(dp.ID())
+	if err != nil {
+		return printErr("%s", err)
+	}
+
+	names, err := enumerateVportNames(dp)
+	if err != nil {
+		return printErr("%s", err)
+	}
+
+	err = printFlow(os.Stdout, dpi.Name, fi, names, showStats)
 	if err != nil {
 		return printErr("%s", err)
 	}
@@ -1014,23 +3574,35 @@ func addFlow(f Flags) bool {
 	return true
 }
 
-func deleteFlow(f Flags) bool {
-	dpif, err := odp.NewDpif()
+func listFlows(f Flags) bool {
+	var showStats bool
+	f.BoolVar(&showStats, "stats", false, "show statistics")
+	args := f.Parse(1, 1)
+
+	dpif, err := odp.NewDpif()
+	if err != nil {
+		return printErr("%s", err)
+	}
+	defer dpif.Close()
+
+	dp, dpname := lookupDatapath(dpif, args[0])
+	if dp == nil {
+		return false
+	}
+
+	names, err := enumerateVportNames(*dp)
 	if err != nil {
 		return printErr("%s", err)
 	}
-	defer dpif.Close()
 
-	dp, flow, ok := flagsToFlowSpec(f, dpif)
-	if !ok {
-		return false
+	flows, err := dp.EnumerateFlows()
+	if err != nil {
+		return printErr("%s", err)
 	}
 
-	err = dp.DeleteFlow(flow.FlowKeys)
-	if err != nil {
-		if odp.IsNoSuchFlowError(err) {
-			return printErr("No such flow")
-		} else {
+	for _, flow := range flows {
+		err = printFlow(os.Stdout, dpname, flow, names, showStats)
+		if err != nil {
 			return printErr("%s", err)
 		}
 	}
@@ -1038,33 +3610,31 @@ func deleteFlow(f Flags) bool {
 	return true
 }
 
-func clearFlow(f Flags) bool {
-	dpif, err := odp.NewDpif()
+// Print a flow in the form of the "odp flow add" options that would
+// create it
+func printFlow(w io.Writer, dpname string, flow odp.FlowInfo, names vportNames, showStats bool) error {
+	io.WriteString(w, dpname)
+
+	err := printFlowKeys(w, flow.FlowKeys, names)
 	if err != nil {
-		return printErr("%s", err)
+		return err
 	}
-	defer dpif.Close()
 
-	dp, flow, ok := flagsToFlowSpec(f, dpif)
-	if !ok {
-		return false
+	err = printFlowActions(w, flow.Actions, names)
+	if err != nil {
+		return err
 	}
 
-	err = dp.ClearFlow(flow)
-	if err != nil {
-		if odp.IsNoSuchFlowError(err) {
-			return printErr("No such flow")
-		} else {
-			return printErr("%s", err)
-		}
+	if showStats {
+		fmt.Fprintf(w, ": %d packets, %d bytes, used %d",
+			flow.Packets, flow.Bytes, flow.Used)
 	}
 
-	return true
+	io.WriteString(w, "\n")
+	return nil
 }
 
-func listFlows(f Flags) bool {
-	var showStats bool
-	f.BoolVar(&showStats, "stats", false, "show statistics")
+func flushFlows(f Flags) bool {
 	args := f.Parse(1, 1)
 
 	dpif, err := odp.NewDpif()
@@ -1073,41 +3643,61 @@ func listFlows(f Flags) bool {
 	}
 	defer dpif.Close()
 
-	dp, dpname := lookupDatapath(dpif, args[0])
+	dp, _ := lookupDatapath(dpif, args[0])
 	if dp == nil {
 		return false
 	}
 
-	flows, err := dp.EnumerateFlows()
+	err = dp.FlushFlows()
 	if err != nil {
 		return printErr("%s", err)
 	}
 
-	for _, flow := range flows {
-		os.Stdout.WriteString(dpname)
+	return true
+}
 
-		err = printFlowKeys(flow.FlowKeys, *dp)
-		if err != nil {
-			return printErr("%s", err)
-		}
+// Resolves vport names for a single datapath.  Commands that print
+// once use the names from a single enumeration of the vports; those
+// that keep running use a VportCache, so that later vports are named
+// too.
+type vportNames struct {
+	dpid  odp.DatapathID
+	names map[odp.VportID]string
+	cache *odp.VportCache
+}
 
-		err = printFlowActions(flow.Actions, *dp)
-		if err != nil {
-			return printErr("%s", err)
-		}
+func enumerateVportNames(dp odp.DatapathHandle) (vportNames, error) {
+	vports, err := dp.EnumerateVports()
+	if err != nil {
+		return vportNames{}, err
+	}
 
-		if showStats {
-			fmt.Printf(": %d packets, %d bytes, used %d",
-				flow.Packets, flow.Bytes, flow.Used)
-		}
+	names := make(map[odp.VportID]string)
+	for _, vport := range vports {
+		names[vport.ID] = vport.Spec.Name()
+	}
+
+	return vportNames{dpid: dp.ID(), names: names}, nil
+}
 
-		os.Stdout.WriteString("\n")
+func (n vportNames) lookup(id odp.VportID) (string, error) {
+	if n.cache != nil {
+		return n.cache.LookupVportName(n.dpid, id)
 	}
 
-	return true
+	if name, ok := n.names[id]; ok {
+		return name, nil
+	}
+
+	// Like DatapathHandle.LookupVportName, fall back to showing
+	// the vport number
+	return fmt.Sprintf("%d:%d", n.dpid, id), nil
 }
 
-func printFlowKeys(fks odp.FlowKeys, dp odp.DatapathHandle) error {
+func printFlowKeys(w io.Writer, fks odp.FlowKeys, names vportNames) error {
+	fks, vlans := flattenVlanFlowKeys(fks)
+	printVlanOptions(w, vlans)
+
 	for _, fk := range fks {
 		if fk.Ignored() {
 			continue
@@ -1115,107 +3705,656 @@ func printFlowKeys(fks odp.FlowKeys, dp odp.DatapathHandle) error {
 
 		switch fk := fk.(type) {
 		case odp.InPortFlowKey:
-			name, err := dp.LookupVportName(fk.VportID())
+			name, err := names.lookup(fk.VportID())
 			if err != nil {
 				return err
 			}
 
-			fmt.Printf(" --in-port=%s", name)
+			fmt.Fprintf(w, " --in-port=%s", name)
 
 		case odp.EthernetFlowKey:
 			k := fk.Key()
 			m := fk.Mask()
-			printEthAddrOption("eth-src", k.EthSrc[:], m.EthSrc[:])
-			printEthAddrOption("eth-dst", k.EthDst[:], m.EthDst[:])
+			printEthAddrOption(w, "eth-src", k.EthSrc[:], m.EthSrc[:])
+			printEthAddrOption(w, "eth-dst", k.EthDst[:], m.EthDst[:])
+
+		case odp.EthertypeFlowKey:
+			if m := fk.Mask(); m == 0xffff {
+				fmt.Fprintf(w, " --eth-type=0x%04x", fk.Ethertype())
+			} else {
+				fmt.Fprintf(w, " --eth-type=\"0x%04x&0x%04x\"", fk.Ethertype(), m)
+			}
+
+		case odp.IPv4FlowKey:
+			printIPv4Options(w, fk)
+
+		case odp.IPv6FlowKey:
+			printIPv6Options(w, fk)
+
+		case odp.TcpFlowKey:
+			printIntOption(w, "tcp-src", uint(fk.Src()), uint(fk.SrcMask()), 0xffff)
+			printIntOption(w, "tcp-dst", uint(fk.Dst()), uint(fk.DstMask()), 0xffff)
+
+		case odp.UdpFlowKey:
+			printIntOption(w, "udp-src", uint(fk.Src()), uint(fk.SrcMask()), 0xffff)
+			printIntOption(w, "udp-dst", uint(fk.Dst()), uint(fk.DstMask()), 0xffff)
+
+		case odp.SctpFlowKey:
+			printIntOption(w, "sctp-src", uint(fk.Src()), uint(fk.SrcMask()), 0xffff)
+			printIntOption(w, "sctp-dst", uint(fk.Dst()), uint(fk.DstMask()), 0xffff)
+
+		case odp.TcpFlagsFlowKey:
+			printTcpFlagsOption(w, fk.Flags(), fk.Mask())
+
+		case odp.IcmpFlowKey:
+			k := fk.Key()
+			m := fk.Mask()
+			printIntOption(w, "icmp-type", uint(k.IcmpType), uint(m.IcmpType), 0xff)
+			printIntOption(w, "icmp-code", uint(k.IcmpCode), uint(m.IcmpCode), 0xff)
+
+		case odp.Icmpv6FlowKey:
+			k := fk.Key()
+			m := fk.Mask()
+			printIntOption(w, "icmpv6-type", uint(k.IcmpType), uint(m.IcmpType), 0xff)
+			printIntOption(w, "icmpv6-code", uint(k.IcmpCode), uint(m.IcmpCode), 0xff)
+
+		case odp.ArpFlowKey:
+			k := fk.Key()
+			m := fk.Mask()
+			printIpv4Option(w, "arp-sip", k.ArpSip, m.ArpSip)
+			printIpv4Option(w, "arp-tip", k.ArpTip, m.ArpTip)
+			printIntOption(w, "arp-op", uint(fk.Op()), uint(fk.OpMask()), 0xffff)
+			printEthAddrOption(w, "arp-sha", k.ArpSha[:], m.ArpSha[:])
+			printEthAddrOption(w, "arp-tha", k.ArpTha[:], m.ArpTha[:])
+
+		case odp.NdFlowKey:
+			k := fk.Key()
+			m := fk.Mask()
+			printIpv6Option(w, "nd-target", fk.Target(), m.NdTarget)
+			printEthAddrOption(w, "nd-sll", k.NdSll[:], m.NdSll[:])
+			printEthAddrOption(w, "nd-tll", k.NdTll[:], m.NdTll[:])
+
+		case odp.MplsFlowKey:
+			printMplsOptions(w, fk)
 
 		case odp.TunnelFlowKey:
-			printTunnelOptions(fk, "tunnel-")
+			printTunnelOptions(w, fk, "tunnel-")
+
+		case odp.CtStateFlowKey:
+			printNamedFlagsOption(w, "ct-state", fk.State(), fk.Mask(),
+				ctStateNames, 0xff)
+
+		case odp.CtZoneFlowKey:
+			printIntOption(w, "ct-zone", uint(fk.Zone()), uint(fk.Mask()), 0xffff)
+
+		case odp.RecircIdFlowKey:
+			// Packets that have not been recirculated have
+			// recirculation id 0, so that is not worth showing
+			if fk.RecircId() != 0 || fk.Mask() != 0xffffffff {
+				printIntOption(w, "recirc-id", uint(fk.RecircId()), uint(fk.Mask()), 0xffffffff)
+			}
+
+		case odp.DpHashFlowKey:
+			printIntOption(w, "dp-hash", uint(fk.DpHash()), uint(fk.Mask()), 0xffffffff)
+
+		case odp.CtMarkFlowKey:
+			printIntOption(w, "ct-mark", uint(fk.Mark()), uint(fk.Mask()), 0xffffffff)
+
+		case odp.CtLabelsFlowKey:
+			l := fk.Labels()
+			m := fk.Mask()
+			printBytesOption(w, "ct-labels", l[:], m[:], hex.EncodeToString)
+
+		case odp.CtOrigTupleIPv4FlowKey:
+			k := fk.Key()
+			m := fk.Mask()
+			printIpv4Option(w, "ct-orig-src", k.Ipv4Src, m.Ipv4Src)
+			printIpv4Option(w, "ct-orig-dst", k.Ipv4Dst, m.Ipv4Dst)
+			printIntOption(w, "ct-orig-tp-src", uint(fk.SrcPort()), uint(fk.SrcPortMask()), 0xffff)
+			printIntOption(w, "ct-orig-tp-dst", uint(fk.DstPort()), uint(fk.DstPortMask()), 0xffff)
+			printIntOption(w, "ct-orig-proto", uint(k.Ipv4Proto), uint(m.Ipv4Proto), 0xff)
+
+		case odp.CtOrigTupleIPv6FlowKey:
+			k := fk.Key()
+			m := fk.Mask()
+			printIpv6Option(w, "ct-orig-src", fk.Src(), m.Ipv6Src)
+			printIpv6Option(w, "ct-orig-dst", fk.Dst(), m.Ipv6Dst)
+			printIntOption(w, "ct-orig-tp-src", uint(fk.SrcPort()), uint(fk.SrcPortMask()), 0xffff)
+			printIntOption(w, "ct-orig-tp-dst", uint(fk.DstPort()), uint(fk.DstPortMask()), 0xffff)
+			printIntOption(w, "ct-orig-proto", uint(k.Ipv6Proto), uint(m.Ipv6Proto), 0xff)
 
 		default:
-			fmt.Printf(" %T:%v", fk, fk)
+			fmt.Fprintf(w, " %T:%v", fk, fk)
 		}
 	}
 
 	return nil
 }
 
-func printFlowActions(as []odp.Action, dp odp.DatapathHandle) error {
+// Merge the encap flow keys of a VLAN tagged flow into the outer flow
+// keys, returning the VLAN tags from outermost to innermost
+func flattenVlanFlowKeys(fks odp.FlowKeys) (odp.FlowKeys, []odp.VlanFlowKey) {
+	var vlans []odp.VlanFlowKey
+	res := make(odp.FlowKeys)
+
+	for {
+		vlan, ok := fks[odp.OVS_KEY_ATTR_VLAN].(odp.VlanFlowKey)
+		encap, eok := fks[odp.OVS_KEY_ATTR_ENCAP].(odp.EncapFlowKey)
+		if !ok || !eok {
+			break
+		}
+
+		vlans = append(vlans, vlan)
+		for typ, fk := range fks {
+			switch typ {
+			case odp.OVS_KEY_ATTR_ETHERTYPE, odp.OVS_KEY_ATTR_VLAN, odp.OVS_KEY_ATTR_ENCAP:
+			default:
+				res[typ] = fk
+			}
+		}
+
+		fks = encap.Keys()
+	}
+
+	for typ, fk := range fks {
+		res[typ] = fk
+	}
+
+	return res, vlans
+}
+
+func printVlanOptions(w io.Writer, vlans []odp.VlanFlowKey) {
+	vids := make([]string, len(vlans))
+	pcps := make([]string, len(vlans))
+	var anyVid, anyPcp bool
+
+	format := func(k uint16, m uint16, allbits uint16) string {
+		switch m {
+		case 0:
+			return ""
+		case allbits:
+			return strconv.Itoa(int(k))
+		default:
+			return fmt.Sprintf("%d&%d", k, m)
+		}
+	}
+
+	for i, vlan := range vlans {
+		if m := vlan.VidMask(); m != 0 {
+			vids[i] = format(vlan.Vid(), m, odp.VLAN_VID_MASK)
+			anyVid = true
+		}
+
+		if m := vlan.PcpMask(); m != 0 {
+			pcps[i] = format(uint16(vlan.Pcp()), uint16(m),
+				odp.VLAN_PCP_MASK>>odp.VLAN_PCP_SHIFT)
+			anyPcp = true
+		}
+	}
+
+	if anyVid {
+		fmt.Fprintf(w, " --vlan-vid=\"%s\"", strings.Join(vids, ","))
+	}
+
+	if anyPcp {
+		fmt.Fprintf(w, " --vlan-pcp=\"%s\"", strings.Join(pcps, ","))
+	}
+
+	// Tags that match neither vid nor pcp still need to be
+	// shown, so we fall back to a fully wildcarded vid
+	if !anyVid && !anyPcp && len(vlans) > 0 {
+		for i := range vids {
+			vids[i] = "0&0"
+		}
+
+		fmt.Fprintf(w, " --vlan-vid=\"%s\"", strings.Join(vids, ","))
+	}
+}
+
+func printMplsOptions(w io.Writer, fk odp.MplsFlowKey) {
+	fields := []struct {
+		opt     string
+		allbits uint32
+		get     func(i int) (uint32, uint32)
+	}{
+		{"mpls-label", odp.MPLS_LS_LABEL_MASK >> odp.MPLS_LS_LABEL_SHIFT, func(i int) (uint32, uint32) {
+			return fk.Label(i), fk.LabelMask(i)
+		}},
+		{"mpls-tc", odp.MPLS_LS_TC_MASK >> odp.MPLS_LS_TC_SHIFT, func(i int) (uint32, uint32) {
+			return uint32(fk.TC(i)), uint32(fk.TCMask(i))
+		}},
+		{"mpls-bos", 1, func(i int) (uint32, uint32) {
+			var k, m uint32
+			if fk.BOS(i) {
+				k = 1
+			}
+			if fk.BOSMask(i) {
+				m = 1
+			}
+			return k, m
+		}},
+		{"mpls-ttl", odp.MPLS_LS_TTL_MASK, func(i int) (uint32, uint32) {
+			return uint32(fk.TTL(i)), uint32(fk.TTLMask(i))
+		}},
+	}
+
+	for _, field := range fields {
+		strs := make([]string, fk.Depth())
+		found := false
+		for i := range strs {
+			k, m := field.get(i)
+			switch m {
+			case 0:
+			case field.allbits:
+				strs[i] = strconv.Itoa(int(k))
+				found = true
+			default:
+				strs[i] = fmt.Sprintf("%d&%d", k, m)
+				found = true
+			}
+		}
+
+		if found {
+			fmt.Fprintf(w, " --%s=\"%s\"", field.opt, strings.Join(strs, ","))
+		}
+	}
+}
+
+func printFlowActions(w io.Writer, as []odp.Action, names vportNames) error {
+	// Consecutive output actions are combined into one option
 	outputs := make([]string, 0)
+	flushOutputs := func() {
+		if len(outputs) > 0 {
+			fmt.Fprintf(w, " --output=%s", strings.Join(outputs, ","))
+			outputs = outputs[:0]
+		}
+	}
 
 	for _, a := range as {
-		switch a := a.(type) {
-		case odp.OutputAction:
-			name, err := dp.LookupVportName(a.VportID())
+		if a, ok := a.(odp.OutputAction); ok {
+			name, err := names.lookup(a.VportID())
 			if err != nil {
 				return err
 			}
 
 			outputs = append(outputs, name)
+			continue
+		}
+
+		flushOutputs()
 
+		switch a := a.(type) {
 		case odp.SetTunnelAction:
-			printSetTunnelOptions(a)
+			printSetTunnelOptions(w, a)
+
+		case odp.PushVlanAction:
+			fmt.Fprintf(w, " --push-vlan=%d", a.Vid)
+			if a.Pcp != 0 {
+				fmt.Fprintf(w, ",pcp=%d", a.Pcp)
+			}
+			if a.Tpid != odp.ETH_P_8021Q {
+				fmt.Fprintf(w, ",tpid=0x%04x", a.Tpid)
+			}
+
+		case odp.PopVlanAction:
+			fmt.Fprintf(w, " --pop-vlan")
+
+		case odp.UserspaceAction:
+			fmt.Fprintf(w, " --userspace=%d", a.Pid)
+			if a.Userdata != nil {
+				fmt.Fprintf(w, ",userdata=%s", hex.EncodeToString(a.Userdata))
+			}
+			if a.EgressTunPort != nil {
+				name, err := names.lookup(*a.EgressTunPort)
+				if err != nil {
+					return err
+				}
+
+				fmt.Fprintf(w, ",tunnel-port=%s", name)
+			}
+
+		case odp.PushMplsAction:
+			fmt.Fprintf(w, " --push-mpls=%d", a.Label)
+			if a.TC != 0 {
+				fmt.Fprintf(w, ",tc=%d", a.TC)
+			}
+			if a.TTL != 64 {
+				fmt.Fprintf(w, ",ttl=%d", a.TTL)
+			}
+			if !a.BOS {
+				fmt.Fprintf(w, ",bos=0")
+			}
+			if a.Ethertype != odp.ETH_P_MPLS_UC {
+				fmt.Fprintf(w, ",ethertype=0x%04x", a.Ethertype)
+			}
+
+		case odp.PopMplsAction:
+			fmt.Fprintf(w, " --pop-mpls=0x%04x", a.Ethertype)
+
+		case odp.CtAction:
+			printCtAction(w, a)
+
+		case odp.SetMaskedAction:
+			printSetMaskedAction(w, a)
+
+		case odp.HashAction:
+			fmt.Fprintf(w, " --hash=%s", hashAlgName(a.Alg))
+			if a.Basis != 0 {
+				fmt.Fprintf(w, ",basis=%d", a.Basis)
+			}
+
+		case odp.RecircAction:
+			fmt.Fprintf(w, " --recirc=%d", a.RecircId())
+
+		case odp.TruncAction:
+			fmt.Fprintf(w, " --trunc=%d", a.MaxLen)
+
+		case odp.UnknownAction:
+			fmt.Fprintf(w, " --raw-action=%d:%s", a.Type, hex.EncodeToString(a.Data))
+
+		case odp.CloneAction:
+			s, err := formatNestedActions(a.Actions, names)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, " --clone=%s", quoteOption(s))
+
+		case odp.CheckPktLenAction:
+			greater, err := formatNestedActions(a.IfGreater, names)
+			if err != nil {
+				return err
+			}
+			lessEqual, err := formatNestedActions(a.IfLessEqual, names)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, " --check-pkt-len=%s",
+				quoteOption(fmt.Sprintf("%d:%s | %s", a.PktLen, greater, lessEqual)))
+
+		case odp.DecTtlAction:
+			fmt.Fprintf(w, " --dec-ttl")
+			if len(a.Actions) > 0 {
+				s, err := formatNestedActions(a.Actions, names)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(w, "=%s", quoteOption(s))
+			}
+
+		case odp.PushEthAction:
+			fmt.Fprintf(w, " --push-eth=%s,%s", net.HardwareAddr(a.EthSrc[:]),
+				net.HardwareAddr(a.EthDst[:]))
+
+		case odp.PopEthAction:
+			fmt.Fprintf(w, " --pop-eth")
+
+		case odp.MeterAction:
+			fmt.Fprintf(w, " --meter=%d", a.MeterId())
+
+		case odp.DropAction:
+			fmt.Fprintf(w, " --drop")
+			if a.Reason != 0 {
+				fmt.Fprintf(w, "=%d", a.Reason)
+			}
+
+		case odp.SampleAction:
+			s, err := formatNestedActions(a.Actions, names)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, " --sample=%s",
+				quoteOption(fmt.Sprintf("%d:%s", sampleOneInN(a.Probability), s)))
 
 		default:
-			fmt.Printf(" %v", a)
+			fmt.Fprintf(w, " %v", a)
+		}
+	}
+
+	flushOutputs()
+	return nil
+}
+
+// Nested action lists are given as the value of a single option, so
+// they are formatted as a string to be quoted
+func formatNestedActions(as []odp.Action, names vportNames) (string, error) {
+	var buf bytes.Buffer
+	if err := printFlowActions(&buf, as, names); err != nil {
+		return "", err
+	}
+
+	return strings.TrimPrefix(buf.String(), " "), nil
+}
+
+// Quote an option value for the shell, in the form that
+// splitOptionWords reverses for nested action options
+func quoteOption(s string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for _, c := range []byte(s) {
+		switch c {
+		case '"', '\\', '$', '`':
+			buf.WriteByte('\\')
 		}
+		buf.WriteByte(c)
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+func printSetMaskedAction(w io.Writer, a odp.SetMaskedAction) {
+	switch fk := a.Key().(type) {
+	case odp.EthernetFlowKey:
+		k := fk.Key()
+		m := fk.Mask()
+		printEthAddrOption(w, "set-eth-src", k.EthSrc[:], m.EthSrc[:])
+		printEthAddrOption(w, "set-eth-dst", k.EthDst[:], m.EthDst[:])
+
+	case odp.IPv4FlowKey:
+		k := fk.Key()
+		m := fk.Mask()
+		printIpv4Option(w, "set-ipv4-src", k.Ipv4Src, m.Ipv4Src)
+		printIpv4Option(w, "set-ipv4-dst", k.Ipv4Dst, m.Ipv4Dst)
+		printIntOption(w, "set-ipv4-tos", uint(k.Ipv4Tos), uint(m.Ipv4Tos), 0xff)
+		printIntOption(w, "set-ipv4-ttl", uint(k.Ipv4Ttl), uint(m.Ipv4Ttl), 0xff)
+
+	case odp.IPv6FlowKey:
+		k := fk.Key()
+		m := fk.Mask()
+		printIpv6Option(w, "set-ipv6-src", fk.Src(), m.Ipv6Src)
+		printIpv6Option(w, "set-ipv6-dst", fk.Dst(), m.Ipv6Dst)
+		printIntOption(w, "set-ipv6-tclass", uint(k.Ipv6Tclass), uint(m.Ipv6Tclass), 0xff)
+		printIntOption(w, "set-ipv6-hlimit", uint(k.Ipv6Hlimit), uint(m.Ipv6Hlimit), 0xff)
+
+	case odp.TcpFlowKey:
+		printIntOption(w, "set-tcp-src", uint(fk.Src()), uint(fk.SrcMask()), 0xffff)
+		printIntOption(w, "set-tcp-dst", uint(fk.Dst()), uint(fk.DstMask()), 0xffff)
+
+	case odp.UdpFlowKey:
+		printIntOption(w, "set-udp-src", uint(fk.Src()), uint(fk.SrcMask()), 0xffff)
+		printIntOption(w, "set-udp-dst", uint(fk.Dst()), uint(fk.DstMask()), 0xffff)
+
+	default:
+		fmt.Fprintf(w, " %v", a)
 	}
+}
 
-	if len(outputs) > 0 {
-		fmt.Printf(" --output=%s", strings.Join(outputs, ","))
+// Invert odp.SampleOneIn, rounding to the nearest n
+func sampleOneInN(p uint32) uint64 {
+	if p == 0 {
+		return 0
 	}
 
-	return nil
+	return (uint64(odp.SampleProbabilityAll) + uint64(p)/2) / uint64(p)
 }
 
-func printEthAddrOption(opt string, k []byte, m []byte) {
-	printBytesOption(opt, k, m, func(a []byte) string {
+func printEthAddrOption(w io.Writer, opt string, k []byte, m []byte) {
+	printBytesOption(w, opt, k, m, func(a []byte) string {
 		return net.HardwareAddr(a).String()
 	})
 }
 
-func printBytesOption(opt string, k []byte, m []byte, f func([]byte) string) {
+func printBytesOption(w io.Writer, opt string, k []byte, m []byte, f func([]byte) string) {
 	if !odp.AllBytes(m, 0) {
 		if odp.AllBytes(m, 0xff) {
-			fmt.Printf(" --%s=%s", opt, f(k))
+			fmt.Fprintf(w, " --%s=%s", opt, f(k))
 		} else {
-			fmt.Printf(" --%s=\"%s&%s\"", opt, f(k), f(m))
+			fmt.Fprintf(w, " --%s=\"%s&%s\"", opt, f(k), f(m))
 		}
 	}
 }
 
-func printIntOption(opt string, k uint, m uint, allbits uint) {
+func printIntOption(w io.Writer, opt string, k uint, m uint, allbits uint) {
 	if m != 0 {
 		if m == allbits {
-			fmt.Printf(" --%s=%d", opt, k)
+			fmt.Fprintf(w, " --%s=%d", opt, k)
 		} else {
-			fmt.Printf(" --%s=\"%d&%d\"", opt, k, m)
+			fmt.Fprintf(w, " --%s=\"%d&%d\"", opt, k, m)
+		}
+	}
+}
+
+// Show an IPv4 address option using prefix notation where possible
+func printIpv4Option(w io.Writer, opt string, k [4]byte, m [4]byte) {
+	if odp.AllBytes(m[:], 0) || odp.AllBytes(m[:], 0xff) {
+		printBytesOption(w, opt, k[:], m[:], ipv4ToString)
+		return
+	}
+
+	ones, bits := net.IPMask(m[:]).Size()
+	if bits == 0 {
+		printBytesOption(w, opt, k[:], m[:], ipv4ToString)
+	} else {
+		fmt.Fprintf(w, " --%s=%s/%d", opt, ipv4ToString(k[:]), ones)
+	}
+}
+
+func printIPv4Options(w io.Writer, fk odp.IPv4FlowKey) {
+	k := fk.Key()
+	m := fk.Mask()
+
+	printIpv4Option(w, "ipv4-src", k.Ipv4Src, m.Ipv4Src)
+	printIpv4Option(w, "ipv4-dst", k.Ipv4Dst, m.Ipv4Dst)
+	printIntOption(w, "ip-proto", uint(k.Ipv4Proto), uint(m.Ipv4Proto), 0xff)
+	printIntOption(w, "ip-tos", uint(k.Ipv4Tos), uint(m.Ipv4Tos), 0xff)
+	printIntOption(w, "ip-ttl", uint(k.Ipv4Ttl), uint(m.Ipv4Ttl), 0xff)
+	printFragOption(w, k.Ipv4Frag, m.Ipv4Frag)
+}
+
+func printIpv6Option(w io.Writer, opt string, k netip.Addr, m [16]byte) {
+	if odp.AllBytes(m[:], 0) {
+		return
+	}
+
+	ones, bits := net.IPMask(m[:]).Size()
+	switch {
+	case bits == 0:
+		fmt.Fprintf(w, " --%s=\"%s&%s\"", opt, k, netip.AddrFrom16(m))
+	case ones == 128:
+		fmt.Fprintf(w, " --%s=%s", opt, k)
+	default:
+		fmt.Fprintf(w, " --%s=%s/%d", opt, k, ones)
+	}
+}
+
+func printIPv6Options(w io.Writer, fk odp.IPv6FlowKey) {
+	k := fk.Key()
+	m := fk.Mask()
+
+	printIpv6Option(w, "ipv6-src", fk.Src(), m.Ipv6Src)
+	printIpv6Option(w, "ipv6-dst", fk.Dst(), m.Ipv6Dst)
+	printIntOption(w, "ipv6-label", uint(fk.Label()), uint(fk.LabelMask()), odp.IPV6_LABEL_MASK)
+	printIntOption(w, "ip-proto", uint(k.Ipv6Proto), uint(m.Ipv6Proto), 0xff)
+	printIntOption(w, "ip-tos", uint(k.Ipv6Tclass), uint(m.Ipv6Tclass), 0xff)
+	printIntOption(w, "ip-ttl", uint(k.Ipv6Hlimit), uint(m.Ipv6Hlimit), 0xff)
+	printFragOption(w, k.Ipv6Frag, m.Ipv6Frag)
+}
+
+func printTcpFlagsOption(w io.Writer, flags uint16, mask uint16) {
+	printNamedFlagsOption(w, "tcp-flags", uint32(flags), uint32(mask),
+		tcpFlagNames, 0xfff)
+}
+
+func printNamedFlagsOption(w io.Writer, opt string, flags uint32, mask uint32, names []flagName, allbits uint) {
+	var named uint32
+	for _, f := range names {
+		named |= f.flag
+	}
+
+	if mask&^named != 0 {
+		printIntOption(w, opt, uint(flags), uint(mask), allbits)
+		return
+	}
+
+	var buf bytes.Buffer
+	for _, f := range names {
+		if mask&f.flag != 0 {
+			if flags&f.flag != 0 {
+				buf.WriteString("+")
+			} else {
+				buf.WriteString("-")
+			}
+			buf.WriteString(f.name)
 		}
 	}
+
+	fmt.Fprintf(w, " --%s=%s", opt, buf.String())
+}
+
+func printFragOption(w io.Writer, k uint8, m uint8) {
+	if m == 0xff {
+		fmt.Fprintf(w, " --ip-frag=%s", fragTypeName(k))
+	} else {
+		printIntOption(w, "ip-frag", uint(k), uint(m), 0xff)
+	}
 }
 
-func printTunnelOptions(fk odp.TunnelFlowKey, prefix string) {
+func printTunnelOptions(w io.Writer, fk odp.TunnelFlowKey, prefix string) {
 	k := fk.Key()
 	m := fk.Mask()
 
-	printBytesOption(prefix+"id", k.TunnelId[:], m.TunnelId[:], hex.EncodeToString)
-	printBytesOption(prefix+"ipv4-src", k.Ipv4Src[:], m.Ipv4Src[:], ipv4ToString)
-	printBytesOption(prefix+"ipv4-dst", k.Ipv4Dst[:], m.Ipv4Dst[:], ipv4ToString)
-	printIntOption(prefix+"tos", uint(k.Tos), uint(m.Tos), 0xff)
-	printIntOption(prefix+"ttl", uint(k.Ttl), uint(m.Ttl), 0xff)
+	printBytesOption(w, prefix+"id", k.TunnelId[:], m.TunnelId[:], hex.EncodeToString)
+	printBytesOption(w, prefix+"ipv4-src", k.Ipv4Src[:], m.Ipv4Src[:], ipv4ToString)
+	printBytesOption(w, prefix+"ipv4-dst", k.Ipv4Dst[:], m.Ipv4Dst[:], ipv4ToString)
+	printIpv6Option(w, prefix+"ipv6-src", netip.AddrFrom16(k.Ipv6Src), m.Ipv6Src)
+	printIpv6Option(w, prefix+"ipv6-dst", netip.AddrFrom16(k.Ipv6Dst), m.Ipv6Dst)
+	printIntOption(w, prefix+"tos", uint(k.Tos), uint(m.Tos), 0xff)
+	printIntOption(w, prefix+"ttl", uint(k.Ttl), uint(m.Ttl), 0xff)
 
 	if m.Df {
-		fmt.Printf(" --%sdf=%t", prefix, k.Df)
+		fmt.Fprintf(w, " --%sdf=%t", prefix, k.Df)
 	}
 
 	if m.Csum {
-		fmt.Printf(" --%scsum=%t", prefix, k.Csum)
+		fmt.Fprintf(w, " --%scsum=%t", prefix, k.Csum)
+	}
+
+	printIntOption(w, prefix+"tp-src", uint(k.TpSrc), uint(m.TpSrc), 0xffff)
+	printIntOption(w, prefix+"tp-dst", uint(k.TpDst), uint(m.TpDst), 0xffff)
+
+	if len(k.GeneveOpts) != 0 {
+		var strs []string
+		for i, o := range k.GeneveOpts {
+			s := formatGeneveOption(o)
+			if i < len(m.GeneveOpts) && !isExactGeneveMask(m.GeneveOpts[i]) {
+				s += "&" + formatGeneveOption(m.GeneveOpts[i])
+			}
+			strs = append(strs, s)
+		}
+
+		opts := strings.Join(strs, ",")
+		if strings.Contains(opts, "&") {
+			fmt.Fprintf(w, " --%sgeneve=\"%s\"", prefix, opts)
+		} else {
+			fmt.Fprintf(w, " --%sgeneve=%s", prefix, opts)
+		}
 	}
+}
 
-	printIntOption(prefix+"tp-src", uint(k.TpSrc), uint(m.TpSrc), 0xffff)
-	printIntOption(prefix+"tp-dst", uint(k.TpDst), uint(m.TpDst), 0xffff)
+func formatGeneveOption(o odp.GeneveOption) string {
+	return fmt.Sprintf("0x%04x:0x%02x:%s", o.Class, o.Type, hex.EncodeToString(o.Data))
 }
 
-func printSetTunnelOptions(a odp.SetTunnelAction) {
+func printSetTunnelOptions(w io.Writer, a odp.SetTunnelAction) {
 	var fk odp.TunnelFlowKey
 	if a.Present.TunnelId {
 		fk.SetTunnelId(a.TunnelId)
@@ -1226,6 +4365,12 @@ func printSetTunnelOptions(a odp.SetTunnelAction) {
 	if a.Present.Ipv4Dst {
 		fk.SetIpv4Dst(a.Ipv4Dst)
 	}
+	if a.Present.Ipv6Src {
+		fk.SetIpv6Src(a.Ipv6Src)
+	}
+	if a.Present.Ipv6Dst {
+		fk.SetIpv6Dst(a.Ipv6Dst)
+	}
 	if a.Present.Tos {
 		fk.SetTos(a.Tos)
 	}
@@ -1244,5 +4389,8 @@ func printSetTunnelOptions(a odp.SetTunnelAction) {
 	if a.Present.TpDst {
 		fk.SetTpDst(a.TpDst)
 	}
-	printTunnelOptions(fk, "set-tunnel-")
+	if a.Present.GeneveOpts {
+		fk.SetGeneveOptions(a.GeneveOpts)
+	}
+	printTunnelOptions(w, fk, "set-tunnel-")
 }
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/weaveworks/go-odp/odp"
+)
+
+// Nested action lists should be printed in a form that parses back to
+// the same actions.  The printed options are split as the shell
+// would split them.
+func TestNestedActionsRoundTrip(t *testing.T) {
+	tos := odp.NewIPv4FlowKey()
+	tos.SetMaskedTos(5, 0xfc)
+
+	ct := odp.CtAction{Commit: true, MarkMask: 0xff00, Mark: 0x100}
+
+	inner := []odp.Action{
+		odp.NewSetMaskedAction(tos),
+		ct,
+		odp.NewUserspaceAction(1, []byte{1, 2}),
+	}
+
+	actions := []odp.Action{
+		odp.NewCloneAction([]odp.Action{
+			odp.NewCloneAction(inner),
+			odp.NewSampleAction(odp.SampleOneIn(10), inner),
+		}),
+		odp.NewCheckPktLenAction(1500,
+			[]odp.Action{
+				odp.NewCheckPktLenAction(100, inner, nil),
+				odp.NewDropAction(0),
+			},
+			[]odp.Action{odp.NewDecTtlAction(inner)}),
+		odp.NewSampleAction(odp.SampleOneIn(100), []odp.Action{
+			odp.NewCheckPktLenAction(64, nil, inner),
+		}),
+		odp.NewDecTtlAction(nil),
+	}
+
+	s, err := formatNestedActions(actions, vportNames{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	words, err := splitOptionWords(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(words) != 1 {
+		t.Fatalf("unexpected top-level \"|\" in %s", s)
+	}
+
+	m, err := parseNestedActionWords("test", words[0])
+	if err != nil {
+		t.Fatalf("%s: %s", s, err)
+	}
+
+	parsed, err := m(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(parsed) != len(actions) {
+		t.Fatalf("%s: got %v", s, parsed)
+	}
+
+	for i := range actions {
+		if !actions[i].Equals(parsed[i]) {
+			t.Fatalf("%s: expected %v, got %v", s, actions[i], parsed[i])
+		}
+	}
+}
+
+func TestSplitOptionWords(t *testing.T) {
+	groups, err := splitOptionWords(`--a="x y" --b='"' \| | --c="\"\\"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]string{{"--a=x y", `--b="`, "|"}, {`--c="\`}}
+	if len(groups) != len(expected) {
+		t.Fatal(groups)
+	}
+
+	for i := range expected {
+		if len(groups[i]) != len(expected[i]) {
+			t.Fatal(groups)
+		}
+
+		for j := range expected[i] {
+			if groups[i][j] != expected[i][j] {
+				t.Fatal(groups)
+			}
+		}
+	}
+
+	if _, err := splitOptionWords(`--a="x`); err == nil {
+		t.Fatal("expected unterminated quote error")
+	}
+}
+
+func TestCtOptionRoundTrip(t *testing.T) {
+	for _, opt := range []string{
+		"commit,zone=5,snat=10.0.0.1-10.0.0.9:1000-2000,random",
+		"commit,snat=:1024-2047",
+		"commit,dnat=[fd00::1]",
+		"commit,eventmask=0x5,timeout=tp1",
+	} {
+		m, err := parseCtOption(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		as, err := m(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if s := formatCtAction(as[0].(odp.CtAction)); s != opt {
+			t.Fatalf("expected %s, got %s", opt, s)
+		}
+	}
+}
+
+// Masked Geneve options contain "&", so must be quoted
+func TestGeneveOptionsQuoted(t *testing.T) {
+	var fk odp.TunnelFlowKey
+	fk.SetMaskedGeneveOptions(
+		[]odp.GeneveOption{
+			{Class: 0x102, Type: 0x80, Data: []byte{1, 2, 3, 4}},
+			{Class: 0x103, Type: 0x1, Data: []byte{5, 6, 7, 8}},
+		},
+		[]odp.GeneveOption{
+			{Class: 0xffff, Type: 0xff, Data: []byte{0xff, 0xff, 0, 0}},
+			{Class: 0xffff, Type: 0xff, Data: []byte{0xff, 0xff, 0xff, 0xff}},
+		})
+
+	var buf bytes.Buffer
+	printTunnelOptions(&buf, fk, "tunnel-")
+
+	words, err := splitOptionWords(buf.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "--tunnel-geneve=0x0102:0x80:01020304&0xffff:0xff:ffff0000,0x0103:0x01:05060708"
+	if len(words) != 1 || len(words[0]) != 1 || words[0][0] != expected {
+		t.Fatalf("expected %s, got %s", expected, buf.String())
+	}
+}
+
+// A vport type registered by an application linked with the tool
+type testVportSpec struct {
+	odp.VportSpecBase
+	key uint32
+}
+
+func (testVportSpec) TypeName() string {
+	return "test"
+}
+
+func (testVportSpec) TypeId() uint32 {
+	return 200
+}
+
+func (v testVportSpec) OptionNlAttrs(req *odp.NlMsgBuilder) {
+	req.PutUint32Attr(1, v.key)
+}
+
+func init() {
+	err := odp.RegisterVportType(odp.VportType{
+		ID:   200,
+		Name: "test",
+		Parse: func(name string, opts odp.Attrs) (odp.VportSpec, error) {
+			key, err := opts.GetUint32(1)
+			return testVportSpec{odp.NewVportSpecBase(name), key}, err
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Vports without their own "vport add" command are listed as raw
+// vports, in a form that parses back to the same vport
+func TestRawVportRoundTrip(t *testing.T) {
+	for _, c := range []struct {
+		spec    odp.VportSpec
+		comment string
+	}{
+		{testVportSpec{odp.NewVportSpecBase("t0"), 42}, ""},
+		{odp.NewUnknownVportSpec("u0", 100, odp.Attrs{1: {1, 2, 3, 4}}), ""},
+		{odp.NewUnknownVportSpec("u1", 101, nil), ""},
+		{odp.NewUnknownVportSpec("e0", odp.OVS_VPORT_TYPE_ERSPAN, odp.Attrs{1: {1}}), "erspan"},
+	} {
+		spec := c.spec
+		s := formatVport("dp", spec)
+
+		// Strip the type name comment, as the shell would
+		cmd, comment, _ := strings.Cut(s, " # ")
+		if comment != c.comment {
+			t.Fatalf("%s: expected type name comment \"%s\"", s, c.comment)
+		}
+
+		words := strings.Fields(cmd)
+		if len(words) < 3 || words[0] != "raw" || words[1] != "dp" {
+			t.Fatalf("unexpected vport line %s", s)
+		}
+
+		f := flag.NewFlagSet("raw", flag.ContinueOnError)
+		rawSpec := rawVportFlags(Flags{f, nil})
+		if err := f.Parse(words[3:]); err != nil {
+			t.Fatalf("%s: %s", s, err)
+		}
+
+		parsed, err := rawSpec(words[2])
+		if err != nil {
+			t.Fatalf("%s: %s", s, err)
+		}
+
+		if !reflect.DeepEqual(spec, parsed) {
+			t.Fatalf("%s: expected %v, got %v", s, spec, parsed)
+		}
+	}
+
+	// The registered parser checks the options
+	f := flag.NewFlagSet("raw", flag.ContinueOnError)
+	rawSpec := rawVportFlags(Flags{f, nil})
+	if err := f.Parse([]string{"--type=test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rawSpec("t1"); err == nil {
+		t.Fatal("expected error for missing vport option")
+	}
+}